@@ -0,0 +1,13 @@
+-- Tag events loaded by the event-gateway historical import (/v1/import)
+-- so a migration can be verified or rolled back per batch:
+--   SELECT count() FROM audit.events WHERE import_batch_id = '...';
+--   ALTER TABLE audit.events DELETE WHERE import_batch_id = '...';
+--
+-- Note: the table TTL (event_date + 90 days) also applies to imported
+-- events. Extend it before backfilling older history.
+
+ALTER TABLE audit.events
+    ADD COLUMN IF NOT EXISTS import_batch_id String DEFAULT '' AFTER raw_event;
+
+ALTER TABLE audit.events
+    ADD INDEX IF NOT EXISTS idx_import_batch_id import_batch_id TYPE bloom_filter GRANULARITY 4;
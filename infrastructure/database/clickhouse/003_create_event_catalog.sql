@@ -0,0 +1,77 @@
+-- Event catalog: which actions and resource types each tenant produces.
+-- Maintained incrementally by materialized views on audit.events, one row
+-- per (tenant, kind, name, day) so that recent volume can be summed and
+-- first/last seen merged across days. Served by the query-api
+-- /v1/catalog/actions and /v1/catalog/resource-types endpoints.
+--
+-- Counts are taken at insert time, so retried deliveries that
+-- ReplacingMergeTree later collapses are counted more than once.
+
+-- Producing APISIX consumer, captured by Vector from X-Consumer-Name, or
+-- the event-gateway source (adapter, import, outbox relay) that sent it
+ALTER TABLE audit.events
+    ADD COLUMN IF NOT EXISTS producer LowCardinality(String) DEFAULT '' AFTER tenant_id;
+
+CREATE TABLE IF NOT EXISTS audit.event_catalog
+(
+    tenant_id String,
+    kind LowCardinality(String), -- 'action' or 'resource_type'
+    name String,
+    day Date,
+    first_seen SimpleAggregateFunction(min, DateTime64(3)),
+    last_seen SimpleAggregateFunction(max, DateTime64(3)),
+    events SimpleAggregateFunction(sum, UInt64),
+    successes SimpleAggregateFunction(sum, UInt64),
+    producers AggregateFunction(groupUniqArray(20), String),
+    sample_event_id AggregateFunction(argMax, UUID, DateTime64(3))
+)
+ENGINE = AggregatingMergeTree()
+PARTITION BY toYYYYMM(day)
+ORDER BY (tenant_id, kind, name, day);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS audit.event_catalog_actions_mv
+TO audit.event_catalog
+AS SELECT
+    tenant_id,
+    'action' AS kind,
+    action_name AS name,
+    event_date AS day,
+    min(received_at) AS first_seen,
+    max(received_at) AS last_seen,
+    count() AS events,
+    countIf(result_success) AS successes,
+    groupUniqArrayStateIf(20)(producer, producer != '') AS producers,
+    argMaxState(event_id, received_at) AS sample_event_id
+FROM audit.events
+GROUP BY tenant_id, action_name, event_date;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS audit.event_catalog_resource_types_mv
+TO audit.event_catalog
+AS SELECT
+    tenant_id,
+    'resource_type' AS kind,
+    resource_type AS name,
+    event_date AS day,
+    min(received_at) AS first_seen,
+    max(received_at) AS last_seen,
+    count() AS events,
+    countIf(result_success) AS successes,
+    groupUniqArrayStateIf(20)(producer, producer != '') AS producers,
+    argMaxState(event_id, received_at) AS sample_event_id
+FROM audit.events
+GROUP BY tenant_id, resource_type, event_date;
+
+-- One-off backfill of events stored before the views existed. Run once,
+-- right after creating the views:
+--
+-- INSERT INTO audit.event_catalog
+-- SELECT tenant_id, 'action', action_name, event_date, min(received_at), max(received_at),
+--        count(), countIf(result_success), groupUniqArrayStateIf(20)(producer, producer != ''),
+--        argMaxState(event_id, received_at)
+-- FROM audit.events GROUP BY tenant_id, action_name, event_date;
+--
+-- INSERT INTO audit.event_catalog
+-- SELECT tenant_id, 'resource_type', resource_type, event_date, min(received_at), max(received_at),
+--        count(), countIf(result_success), groupUniqArrayStateIf(20)(producer, producer != ''),
+--        argMaxState(event_id, received_at)
+-- FROM audit.events GROUP BY tenant_id, resource_type, event_date;
@@ -0,0 +1,35 @@
+-- Events per tenant and hour of received_at, maintained by a materialized
+-- view on audit.events. Served by the query-api /v1/events/timeseries
+-- endpoint for 1h and 1d buckets. events_hourly_count cannot answer those:
+-- its hour is of received_at but its date is the event's own, and the two
+-- differ for imports and late deliveries.
+--
+-- Counts are taken at insert time, so retried deliveries that
+-- ReplacingMergeTree later collapses are counted more than once.
+
+CREATE TABLE IF NOT EXISTS audit.events_received_hourly
+(
+    tenant_id String,
+    received_hour DateTime('UTC'),
+    event_count UInt64
+)
+ENGINE = SummingMergeTree()
+PARTITION BY toYYYYMM(received_hour)
+ORDER BY (tenant_id, received_hour)
+TTL received_hour + INTERVAL 90 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS audit.events_received_hourly_mv
+TO audit.events_received_hourly
+AS SELECT
+    tenant_id,
+    toStartOfHour(received_at, 'UTC') AS received_hour,
+    count() AS event_count
+FROM audit.events
+GROUP BY tenant_id, received_hour;
+
+-- One-off backfill of events stored before the view existed. Run once,
+-- right after creating the view:
+--
+-- INSERT INTO audit.events_received_hourly
+-- SELECT tenant_id, toStartOfHour(received_at, 'UTC'), count()
+-- FROM audit.events GROUP BY tenant_id, toStartOfHour(received_at, 'UTC');
@@ -0,0 +1,26 @@
+-- 002_cdc_audit_actor.sql
+-- Support objects for the event-gateway PostgreSQL CDC source.
+--
+-- Applications identify the acting user per transaction:
+--   SET LOCAL audit.actor = 'alice@example.com';
+-- audit_emit_actor() writes it into the WAL as a transactional logical
+-- decoding message, which the CDC source attaches to the row changes that
+-- follow it in the same transaction.
+CREATE OR REPLACE FUNCTION audit_emit_actor() RETURNS trigger AS $$
+DECLARE
+    actor TEXT := current_setting('audit.actor', true);
+BEGIN
+    IF actor IS NOT NULL AND actor <> '' THEN
+        PERFORM pg_logical_emit_message(true, 'audit.actor', actor);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Per captured table:
+--   CREATE TRIGGER orders_audit_actor
+--       BEFORE INSERT OR UPDATE OR DELETE ON orders
+--       FOR EACH STATEMENT EXECUTE FUNCTION audit_emit_actor();
+--
+-- Full before-images (needed for changed_columns on updates):
+--   ALTER TABLE orders REPLICA IDENTITY FULL;
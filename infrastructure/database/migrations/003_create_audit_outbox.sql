@@ -0,0 +1,38 @@
+-- 003_create_audit_outbox.sql
+-- Transactional outbox for producers that write audit events in the same
+-- transaction as their business change. The outbox-relay service claims
+-- pending rows with FOR UPDATE SKIP LOCKED, submits them to the event
+-- gateway using id as the idempotency key and marks them delivered.
+CREATE TABLE IF NOT EXISTS audit_outbox (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id VARCHAR(255),
+    payload JSONB NOT NULL, -- audit event: actor, action, resource, result, context
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    delivered_at TIMESTAMPTZ,
+    event_id UUID, -- event_id assigned by the gateway
+    parked_at TIMESTAMPTZ -- set after the last allowed attempt; requeue by clearing it
+);
+
+-- Pending rows in claim order
+CREATE INDEX IF NOT EXISTS idx_audit_outbox_pending
+    ON audit_outbox (next_attempt_at, created_at)
+    WHERE delivered_at IS NULL AND parked_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_audit_outbox_parked
+    ON audit_outbox (parked_at)
+    WHERE parked_at IS NOT NULL;
+
+-- Producer usage:
+--   BEGIN;
+--   UPDATE orders SET status = 'paid' WHERE id = 42;
+--   INSERT INTO audit_outbox (tenant_id, payload) VALUES ('shop', '{
+--     "actor": {"id": "alice"}, "action": {"name": "order.paid"},
+--     "resource": {"type": "order", "id": "42"}}');
+--   COMMIT;
+--
+-- Requeue parked rows:
+--   UPDATE audit_outbox SET parked_at = NULL, attempts = 0, next_attempt_at = NOW()
+--   WHERE parked_at IS NOT NULL;
@@ -147,6 +147,9 @@ function Install-Vector {
         -f vector/values.yaml `
         --wait
 
+    # Only the event-gateway may reach the internal gateway_ingest source
+    kubectl apply -f vector/network-policy.yaml
+
     Write-Host "Applying Vector Alias Service in APISIX namespace..."
     kubectl apply -f apisix/vector-alias.yaml
     
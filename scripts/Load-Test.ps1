@@ -23,7 +23,7 @@ for ($i = 1; $i -le $Requests; $i++) {
     $jobs += Start-Job -ScriptBlock {
         param($url)
         try {
-            Invoke-RestMethod -Uri "$url/v1/events?limit=10" -Headers @{"X-Consumer-Name" = "audit-producer" } -TimeoutSec 30 | Out-Null
+            Invoke-RestMethod -Uri "$url/v1/events?limit=10" -Headers @{"X-Consumer-Name" = "audit-admin" } -TimeoutSec 30 | Out-Null
             return "OK"
         }
         catch {
@@ -53,7 +53,7 @@ for ($i = 1; $i -le $Requests; $i++) {
     $jobs += Start-Job -ScriptBlock {
         param($url)
         try {
-            Invoke-RestMethod -Uri "$url/v1/events/aggregations" -Headers @{"X-Consumer-Name" = "audit-producer" } -TimeoutSec 30 | Out-Null
+            Invoke-RestMethod -Uri "$url/v1/events/aggregations" -Headers @{"X-Consumer-Name" = "audit-admin" } -TimeoutSec 30 | Out-Null
             return "OK"
         }
         catch {
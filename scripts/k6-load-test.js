@@ -42,7 +42,7 @@ const EVENT_GATEWAY_URL = __ENV.EVENT_GATEWAY_URL || 'http://localhost:8090';
 
 export function queryList() {
     const res = http.get(`${QUERY_API_URL}/v1/events?limit=20`, {
-        headers: { 'X-Consumer-Name': 'audit-producer' },
+        headers: { 'X-Consumer-Name': 'audit-admin' },
     });
 
     const success = check(res, {
@@ -59,7 +59,7 @@ export function queryList() {
 
 export function queryAggregations() {
     const res = http.get(`${QUERY_API_URL}/v1/events/aggregations`, {
-        headers: { 'X-Consumer-Name': 'audit-producer' },
+        headers: { 'X-Consumer-Name': 'audit-admin' },
     });
 
     const success = check(res, {
@@ -22,7 +22,7 @@ echo ""
 echo "=== Test 1: GET /v1/events (List) ==="
 START=$(date +%s%N)
 for i in $(seq 1 $REQUESTS); do
-    curl -s "$QUERY_API_URL/v1/events?limit=10" -H "X-Consumer-Name: audit-producer" > /dev/null &
+    curl -s "$QUERY_API_URL/v1/events?limit=10" -H "X-Consumer-Name: audit-admin" > /dev/null &
     if [ $((i % CONCURRENCY)) -eq 0 ]; then wait; fi
 done
 wait
@@ -36,7 +36,7 @@ echo ""
 echo "=== Test 2: GET /v1/events/aggregations ==="
 START=$(date +%s%N)
 for i in $(seq 1 $REQUESTS); do
-    curl -s "$QUERY_API_URL/v1/events/aggregations" -H "X-Consumer-Name: audit-producer" > /dev/null &
+    curl -s "$QUERY_API_URL/v1/events/aggregations" -H "X-Consumer-Name: audit-admin" > /dev/null &
     if [ $((i % CONCURRENCY)) -eq 0 ]; then wait; fi
 done
 wait
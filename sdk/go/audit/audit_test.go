@@ -0,0 +1,218 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeGateway accepts batches and rejects events whose actor is "bad"
+type fakeGateway struct {
+	mu      sync.Mutex
+	down    atomic.Bool
+	batches [][]Event
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if g.down.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		Events []Event `json:"events"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	g.mu.Lock()
+	g.batches = append(g.batches, req.Events)
+	g.mu.Unlock()
+
+	var resp struct {
+		Events []map[string]string `json:"events"`
+	}
+	for _, event := range req.Events {
+		if event.Actor.ID == "bad" {
+			resp.Events = append(resp.Events, map[string]string{"status": "rejected", "error": "actor.id is required"})
+		} else {
+			resp.Events = append(resp.Events, map[string]string{"event_id": "id-" + event.IdempotencyKey, "status": "accepted"})
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (g *fakeGateway) sent() []Event {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var events []Event
+	for _, batch := range g.batches {
+		events = append(events, batch...)
+	}
+	return events
+}
+
+func testEvent(t *testing.T, actor string) Event {
+	t.Helper()
+	event, err := NewEvent("document.read").Actor(actor, ActorUser).Resource("document", "d-1").Success().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestBuilder_Validation(t *testing.T) {
+	_, err := NewEvent("document.read").Actor("alice", ActorUser).Build()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || invalid.Field != "resource.type and resource.id" {
+		t.Fatalf("Expected resource validation error, got %v", err)
+	}
+
+	event, err := NewEvent("document.read").Actor("alice", ActorUser).Resource("document", "d-1").IP("10.0.0.1").Failure("denied").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.IdempotencyKey == "" || event.Timestamp == "" || event.Context["ip"] != "10.0.0.1" || event.Result.Success {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestClient_SendBatchTypedRejections(t *testing.T) {
+	gw := &fakeGateway{}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "key")
+	good, bad := testEvent(t, "alice"), testEvent(t, "bad")
+	result, err := client.SendBatch(context.Background(), []Event{good, bad})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Accepted) != 1 || result.EventIDs[0] != "id-"+good.IdempotencyKey {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	var rejected *RejectedError
+	if !errors.As(result.Err(), &rejected) || rejected.IdempotencyKey != bad.IdempotencyKey {
+		t.Errorf("Expected RejectedError for the bad event, got %v", result.Err())
+	}
+}
+
+func TestClient_RetriesTemporaryErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"event_id":"e-1","received_at":"now"}`))
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "")
+	client.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	accepted, err := client.Send(context.Background(), testEvent(t, "alice"))
+	if err != nil || accepted.EventID != "e-1" || calls.Load() != 3 {
+		t.Fatalf("Expected success on third attempt, got %v after %d calls", err, calls.Load())
+	}
+
+	calls.Store(-10)
+	_, err = client.Send(context.Background(), testEvent(t, "alice"))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 {
+		t.Errorf("Expected APIError 503 after retries, got %v", err)
+	}
+}
+
+func TestClient_SendBatchKeysEventsBeforeRetrying(t *testing.T) {
+	gw := &fakeGateway{}
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gw.down.Store(calls.Add(1) == 1)
+		gw.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	event := testEvent(t, "alice")
+	event.IdempotencyKey = ""
+	client := NewClient(srv.URL, "")
+	client.Retry = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	result, err := client.SendBatch(context.Background(), []Event{event})
+	if err != nil || calls.Load() != 2 {
+		t.Fatalf("Expected success on the retry, got %v after %d calls", err, calls.Load())
+	}
+	sent := gw.sent()
+	if len(sent) != 1 || sent[0].IdempotencyKey == "" || result.EventIDs[0] != "id-"+sent[0].IdempotencyKey {
+		t.Errorf("Expected a generated key, sent %+v with IDs %v", sent, result.EventIDs)
+	}
+}
+
+func TestSender_BatchesAndCloseFlushes(t *testing.T) {
+	gw := &fakeGateway{}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	var rejections atomic.Int32
+	sender := NewSender(NewClient(srv.URL, ""), SenderOptions{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		OnError: func(err error) {
+			var rejected *RejectedError
+			if errors.As(err, &rejected) {
+				rejections.Add(1)
+			}
+		},
+	})
+	for _, actor := range []string{"a", "b", "bad"} {
+		if err := sender.Enqueue(testEvent(t, actor)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sender.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(gw.sent()) != 3 || len(gw.batches) != 2 || rejections.Load() != 1 {
+		t.Errorf("Expected 3 events in 2 batches with 1 rejection, got %d in %d batches, %d rejections",
+			len(gw.sent()), len(gw.batches), rejections.Load())
+	}
+	if err := sender.Enqueue(testEvent(t, "late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestSender_SpoolsDuringOutageAndReplays(t *testing.T) {
+	gw := &fakeGateway{}
+	gw.down.Store(true)
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	client := NewClient(srv.URL, "")
+	client.Retry = RetryPolicy{MaxAttempts: 1}
+	sender := NewSender(client, SenderOptions{SpoolDir: dir, FlushInterval: time.Hour})
+
+	event := testEvent(t, "alice")
+	sender.Enqueue(event)
+	if err := sender.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("Expected 1 spool file during outage, got %d", len(files))
+	}
+
+	gw.down.Store(false)
+	sender.Flush(context.Background())
+	sender.Close(context.Background())
+
+	sent := gw.sent()
+	if len(sent) != 1 || sent[0].IdempotencyKey != event.IdempotencyKey {
+		t.Errorf("Expected the spooled event replayed with its key, got %+v", sent)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected empty spool after replay, got %d files", len(files))
+	}
+}
@@ -0,0 +1,226 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxBatchSize is the largest batch the gateway accepts
+const MaxBatchSize = 1000
+
+// RetryPolicy controls retries of temporary failures. The delay before
+// attempt n is a random duration up to min(MaxDelay, BaseDelay*2^n) ("full
+// jitter").
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used when a Client has no policy set
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: 200 * time.Millisecond, MaxDelay: 10 * time.Second}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	backoff := p.BaseDelay << attempt
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// Client sends events to the event gateway synchronously
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	Retry      RetryPolicy
+}
+
+// NewClient returns a Client for the gateway at baseURL
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Retry:      DefaultRetryPolicy,
+	}
+}
+
+// Accepted is the gateway's receipt for an ingested event
+type Accepted struct {
+	EventID    string `json:"event_id"`
+	ReceivedAt string `json:"received_at"`
+}
+
+// BatchResult is the outcome of one batch. Accepted and Rejected hold the
+// events' indexes in the submitted batch.
+type BatchResult struct {
+	EventIDs []string
+	Accepted []int
+	Rejected map[int]*RejectedError
+}
+
+// Err returns the rejections joined into one error, or nil
+func (r BatchResult) Err() error {
+	errs := make([]error, 0, len(r.Rejected))
+	for _, err := range r.Rejected {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Send posts a single event to /v1/events. Missing actor and context
+// fields are filled from the request in ctx, and an event without an
+// idempotency key gets one before the first attempt.
+func (c *Client) Send(ctx context.Context, event Event) (Accepted, error) {
+	if info, ok := RequestInfoFrom(ctx); ok {
+		info.apply(&event)
+	}
+	if err := event.Validate(); err != nil {
+		return Accepted{}, err
+	}
+	if event.IdempotencyKey == "" {
+		event.IdempotencyKey = newKey()
+	}
+	var accepted Accepted
+	err := c.withRetry(ctx, func() error {
+		return c.post(ctx, "/v1/events", event, &accepted)
+	})
+	return accepted, err
+}
+
+// SendBatch posts events to /v1/events/batch. Events without an
+// idempotency key get one before the first attempt, so retries of the batch
+// carry the same keys; events is not modified. Per-event rejections are
+// reported in the result; the error is only set when the whole request failed.
+func (c *Client) SendBatch(ctx context.Context, events []Event) (BatchResult, error) {
+	if len(events) == 0 {
+		return BatchResult{}, nil
+	}
+	if len(events) > MaxBatchSize {
+		return BatchResult{}, fmt.Errorf("audit: batch of %d exceeds %d events", len(events), MaxBatchSize)
+	}
+	events = append([]Event(nil), events...)
+	for i := range events {
+		if events[i].IdempotencyKey == "" {
+			events[i].IdempotencyKey = newKey()
+		}
+	}
+
+	var resp struct {
+		Events []struct {
+			EventID string `json:"event_id"`
+			Status  string `json:"status"`
+			Error   string `json:"error"`
+		} `json:"events"`
+	}
+	err := c.withRetry(ctx, func() error {
+		return c.post(ctx, "/v1/events/batch", map[string]interface{}{"events": events}, &resp)
+	})
+	if err != nil {
+		return BatchResult{}, err
+	}
+	if len(resp.Events) != len(events) {
+		return BatchResult{}, fmt.Errorf("audit: gateway returned %d results for %d events", len(resp.Events), len(events))
+	}
+
+	result := BatchResult{EventIDs: make([]string, len(events)), Rejected: map[int]*RejectedError{}}
+	for i, ev := range resp.Events {
+		if ev.Status == "accepted" {
+			result.EventIDs[i] = ev.EventID
+			result.Accepted = append(result.Accepted, i)
+			continue
+		}
+		reason := ev.Error
+		if reason == "" {
+			reason = ev.Status
+		}
+		result.Rejected[i] = &RejectedError{IdempotencyKey: events[i].IdempotencyKey, Reason: reason}
+	}
+	return result, nil
+}
+
+func (c *Client) withRetry(ctx context.Context, fn func() error) error {
+	policy := c.Retry
+	if policy.MaxAttempts <= 0 {
+		policy = DefaultRetryPolicy
+	}
+	var err error
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return errors.Join(ctx.Err(), err)
+			case <-time.After(policy.delay(attempt)):
+			}
+		}
+		if err = fn(); err == nil || !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) post(ctx context.Context, path string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &msg) == nil {
+			apiErr.Message = msg.Error
+		}
+		// A single event the gateway refuses is a rejection, not a transport problem
+		if path == "/v1/events" && (resp.StatusCode == 400 || resp.StatusCode == 422) {
+			if event, ok := payload.(Event); ok {
+				return &RejectedError{IdempotencyKey: event.IdempotencyKey, Reason: apiErr.Message}
+			}
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("audit: invalid gateway response: %w", err)
+	}
+	return nil
+}
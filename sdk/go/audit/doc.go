@@ -0,0 +1,32 @@
+// Package audit is the Go client for the event gateway.
+//
+// Build events with NewEvent and send them either synchronously with a
+// Client or in the background with a Sender, which batches events to
+// /v1/events/batch by size and interval:
+//
+//	client := audit.NewClient("https://audit.example.com", "api-key")
+//	sender := audit.NewSender(client, audit.SenderOptions{SpoolDir: "/var/spool/audit"})
+//	defer sender.Close(context.Background())
+//
+//	event, err := audit.NewEvent("document.read").
+//		Actor("alice", audit.ActorUser).
+//		Resource("document", "doc-42").
+//		Success().
+//		Build()
+//	if err != nil {
+//		return err
+//	}
+//	sender.Enqueue(event)
+//
+// Middleware (and auditfiber.New for Fiber) captures the client IP, user
+// agent, request ID, trace context and principal of each request. Events
+// sent with Client.Send or Sender.EnqueueContext using the request context
+// are enriched with them.
+//
+// Events get an idempotency key when they are built, or from Client and
+// Sender before their first attempt when they have none, so retries and
+// replays from the disk buffer carry the same key. The event gateway turns
+// the key into a stable event_id. Behind APISIX, /v1/events and
+// /v1/events/batch go straight to Vector, which ignores idempotency_key
+// today: a retried request there can store the event twice.
+package audit
@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrClosed is returned when enqueueing to a closed Sender
+	ErrClosed = errors.New("audit: sender is closed")
+	// ErrBufferFull is returned when the in-memory queue is full and no
+	// disk buffer is configured
+	ErrBufferFull = errors.New("audit: buffer is full")
+)
+
+// ValidationError reports a required field missing from an event
+type ValidationError struct {
+	Field string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("audit: %s is required", e.Field)
+}
+
+// RejectedError is a single event the gateway refused. Rejections are
+// permanent and are never retried.
+type RejectedError struct {
+	IdempotencyKey string
+	Reason         string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("audit: event %s rejected: %s", e.IdempotencyKey, e.Reason)
+}
+
+// APIError is a non-2xx response from the gateway
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("audit: gateway returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("audit: gateway returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether retrying the request may succeed
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == 408 || e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// isRetryable reports whether err is worth another attempt: transport
+// failures and temporary API errors are, rejections and bad requests are not
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var rejected *RejectedError
+	var invalid *ValidationError
+	return !errors.As(err, &rejected) && !errors.As(err, &invalid)
+}
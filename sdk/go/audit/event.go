@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// Actor types understood by the query API and dashboards
+const (
+	ActorUser           = "user"
+	ActorServiceAccount = "service_account"
+	ActorSystem         = "system"
+	ActorBot            = "bot"
+)
+
+// Actor identifies who performed the action
+type Actor struct {
+	ID    string `json:"id"`
+	Type  string `json:"type,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// Action names what was done, for example "document.read"
+type Action struct {
+	Name string `json:"name"`
+}
+
+// Resource identifies what the action was performed on
+type Resource struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Result records whether the action succeeded
+type Result struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+// Event is an audit event in the gateway's wire format
+type Event struct {
+	Actor          Actor                  `json:"actor"`
+	Action         Action                 `json:"action"`
+	Resource       Resource               `json:"resource"`
+	Result         *Result                `json:"result,omitempty"`
+	Context        map[string]interface{} `json:"context,omitempty"`
+	Timestamp      string                 `json:"timestamp,omitempty"`
+	TenantID       string                 `json:"tenant_id,omitempty"`
+	IdempotencyKey string                 `json:"idempotency_key,omitempty"`
+}
+
+// Validate checks the fields the gateway requires
+func (e Event) Validate() error {
+	if e.Actor.ID == "" {
+		return &ValidationError{Field: "actor.id"}
+	}
+	if e.Action.Name == "" {
+		return &ValidationError{Field: "action.name"}
+	}
+	if e.Resource.Type == "" || e.Resource.ID == "" {
+		return &ValidationError{Field: "resource.type and resource.id"}
+	}
+	return nil
+}
+
+// EventBuilder assembles an Event. Methods can be chained; Build validates
+// the result.
+type EventBuilder struct {
+	event Event
+}
+
+// NewEvent starts an event for the given action name
+func NewEvent(action string) *EventBuilder {
+	return &EventBuilder{event: Event{Action: Action{Name: action}}}
+}
+
+// Actor sets the actor ID and type
+func (b *EventBuilder) Actor(id, actorType string) *EventBuilder {
+	b.event.Actor.ID = id
+	b.event.Actor.Type = actorType
+	return b
+}
+
+// ActorEmail sets the actor's email
+func (b *EventBuilder) ActorEmail(email string) *EventBuilder {
+	b.event.Actor.Email = email
+	return b
+}
+
+// Resource sets the resource type and ID
+func (b *EventBuilder) Resource(resourceType, id string) *EventBuilder {
+	b.event.Resource = Resource{Type: resourceType, ID: id}
+	return b
+}
+
+// Success marks the action as successful
+func (b *EventBuilder) Success() *EventBuilder {
+	b.event.Result = &Result{Success: true}
+	return b
+}
+
+// Failure marks the action as failed with a reason
+func (b *EventBuilder) Failure(message string) *EventBuilder {
+	b.event.Result = &Result{Success: false, Message: message}
+	return b
+}
+
+// IP sets context.ip
+func (b *EventBuilder) IP(ip string) *EventBuilder {
+	return b.With("ip", ip)
+}
+
+// UserAgent sets context.user_agent
+func (b *EventBuilder) UserAgent(userAgent string) *EventBuilder {
+	return b.With("user_agent", userAgent)
+}
+
+// With adds a context attribute
+func (b *EventBuilder) With(key string, value interface{}) *EventBuilder {
+	if b.event.Context == nil {
+		b.event.Context = map[string]interface{}{}
+	}
+	b.event.Context[key] = value
+	return b
+}
+
+// Tenant sets the tenant the event belongs to
+func (b *EventBuilder) Tenant(tenantID string) *EventBuilder {
+	b.event.TenantID = tenantID
+	return b
+}
+
+// At sets when the action happened. Build defaults it to the current time.
+func (b *EventBuilder) At(t time.Time) *EventBuilder {
+	b.event.Timestamp = t.UTC().Format(time.RFC3339Nano)
+	return b
+}
+
+// IdempotencyKey overrides the generated key, for example with the ID of
+// the business record the event describes
+func (b *EventBuilder) IdempotencyKey(key string) *EventBuilder {
+	b.event.IdempotencyKey = key
+	return b
+}
+
+// Build validates the event and fills in the timestamp and idempotency key
+func (b *EventBuilder) Build() (Event, error) {
+	event := b.event
+	if err := event.Validate(); err != nil {
+		return Event{}, err
+	}
+	if event.Timestamp == "" {
+		event.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	if event.IdempotencyKey == "" {
+		event.IdempotencyKey = newKey()
+	}
+	return event, nil
+}
+
+// newKey returns a random UUID v4
+func newKey() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("audit: reading random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,267 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// RequestInfo is the audit context of one inbound request. The middleware
+// stores it in the request context; events sent with that context are
+// enriched from it.
+type RequestInfo struct {
+	mu sync.RWMutex
+
+	IP          string
+	UserAgent   string
+	RequestID   string
+	TraceID     string
+	SpanID      string
+	Principal   string
+	ActorType   string
+	ActorEmail  string
+	TraceParent string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a context carrying info
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFrom returns the RequestInfo stored in ctx, if any
+func RequestInfoFrom(ctx context.Context) (*RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info, ok && info != nil
+}
+
+// SetPrincipal records the authenticated principal for the request in ctx.
+// Authentication usually runs after the audit middleware, so it calls this
+// once the user is known.
+func SetPrincipal(ctx context.Context, id, actorType, email string) {
+	info, ok := RequestInfoFrom(ctx)
+	if !ok {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.Principal = id
+	info.ActorType = actorType
+	info.ActorEmail = email
+}
+
+// apply fills the event fields that are still empty from the request
+func (info *RequestInfo) apply(event *Event) {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+
+	if event.Actor.ID == "" && info.Principal != "" {
+		event.Actor.ID = info.Principal
+		if event.Actor.Type == "" {
+			event.Actor.Type = info.ActorType
+		}
+		if event.Actor.Email == "" {
+			event.Actor.Email = info.ActorEmail
+		}
+	}
+	// Never write into a context map the caller may still hold
+	event.Context = maps.Clone(event.Context)
+	for key, val := range map[string]string{
+		"ip":          info.IP,
+		"user_agent":  info.UserAgent,
+		"request_id":  info.RequestID,
+		"trace_id":    info.TraceID,
+		"span_id":     info.SpanID,
+		"traceparent": info.TraceParent,
+	} {
+		if val == "" {
+			continue
+		}
+		if event.Context == nil {
+			event.Context = map[string]interface{}{}
+		}
+		if _, ok := event.Context[key]; !ok {
+			event.Context[key] = val
+		}
+	}
+}
+
+// FromContext enriches the event from the request in ctx. Fields already
+// set on the builder win. Client.Send and Sender.EnqueueContext do the same
+// automatically.
+func (b *EventBuilder) FromContext(ctx context.Context) *EventBuilder {
+	if info, ok := RequestInfoFrom(ctx); ok {
+		info.apply(&b.event)
+	}
+	return b
+}
+
+// MiddlewareOptions configures how request context is extracted
+type MiddlewareOptions struct {
+	// TrustedProxies are the proxies whose X-Forwarded-For and X-Real-IP
+	// headers are believed. Requests from anywhere else use the peer address.
+	TrustedProxies []netip.Prefix
+	// RequestIDHeader is read for the request ID and set on the response
+	// when one is generated (default X-Request-ID)
+	RequestIDHeader string
+	// PrincipalHeader names a header set by an authenticating proxy, such
+	// as X-Consumer-Name from APISIX. It is only read from TrustedProxies,
+	// so clients that bypass the proxy cannot choose the actor. Empty
+	// disables it; use SetPrincipal from application auth instead.
+	PrincipalHeader string
+}
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges
+func ParseTrustedProxies(list ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("audit: invalid trusted proxy %q: %w", item, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("audit: invalid trusted proxy %q: %w", item, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (o MiddlewareOptions) requestIDHeader() string {
+	if o.RequestIDHeader == "" {
+		return "X-Request-ID"
+	}
+	return o.RequestIDHeader
+}
+
+func (o MiddlewareOptions) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range o.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract builds the RequestInfo for a request from its peer address and
+// headers. It is the transport-independent core of the middleware.
+func (o MiddlewareOptions) Extract(remoteAddr string, header func(string) string) (info *RequestInfo, generatedID bool) {
+	info = &RequestInfo{
+		IP:        o.clientIP(remoteAddr, header),
+		UserAgent: header("User-Agent"),
+		RequestID: header(o.requestIDHeader()),
+	}
+	if info.RequestID == "" {
+		info.RequestID = header("X-Correlation-ID")
+	}
+	if info.RequestID == "" {
+		info.RequestID = newKey()
+		generatedID = true
+	}
+	if traceID, spanID, ok := parseTraceParent(header("traceparent")); ok {
+		info.TraceParent = header("traceparent")
+		info.TraceID = traceID
+		info.SpanID = spanID
+	}
+	if _, peer, err := peerAddr(remoteAddr); err == nil && o.PrincipalHeader != "" && o.trusted(peer) {
+		info.Principal = header(o.PrincipalHeader)
+	}
+	return info, generatedID
+}
+
+// peerAddr splits the host off a host:port or bare host peer and parses it
+func peerAddr(remoteAddr string) (string, netip.Addr, error) {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	return host, addr, err
+}
+
+// clientIP walks X-Forwarded-For from the nearest hop outwards while the
+// hops are trusted proxies; the first untrusted address is the client
+func (o MiddlewareOptions) clientIP(remoteAddr string, header func(string) string) string {
+	host, peer, err := peerAddr(remoteAddr)
+	if err != nil {
+		return host
+	}
+	if !o.trusted(peer) {
+		return peer.Unmap().String()
+	}
+
+	var hops []string
+	for _, part := range strings.Split(header("X-Forwarded-For"), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			hops = append(hops, part)
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		client = addr
+		if !o.trusted(addr) {
+			return addr.Unmap().String()
+		}
+	}
+	if len(hops) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(header("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	return client.Unmap().String()
+}
+
+// parseTraceParent reads a W3C traceparent header: version-traceid-spanid-flags
+func parseTraceParent(value string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	if !isHex(parts[1]) || !isHex(parts[2]) || strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware captures the audit context of each request for net/http
+// handlers. Read it with RequestInfoFrom(r.Context()).
+func Middleware(opts MiddlewareOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info, generated := opts.Extract(r.RemoteAddr, r.Header.Get)
+			if generated {
+				w.Header().Set(opts.requestIDHeader(), info.RequestID)
+			}
+			next.ServeHTTP(w, r.WithContext(WithRequestInfo(r.Context(), info)))
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtract_TrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", "192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MiddlewareOptions{TrustedProxies: proxies}
+	cases := []struct {
+		remote, xff, want string
+	}{
+		// Untrusted peers cannot spoof the client address
+		{"203.0.113.9:5000", "1.2.3.4", "203.0.113.9"},
+		{"10.1.2.3:5000", "1.2.3.4", "1.2.3.4"},
+		// The rightmost untrusted hop wins over anything the client prepended
+		{"10.1.2.3:5000", "6.6.6.6, 1.2.3.4, 192.168.1.1", "1.2.3.4"},
+		{"10.1.2.3:5000", "", "10.1.2.3"},
+		{"[::ffff:10.1.2.3]:5000", "2001:db8::1", "2001:db8::1"},
+	}
+	for _, tc := range cases {
+		header := http.Header{}
+		if tc.xff != "" {
+			header.Set("X-Forwarded-For", tc.xff)
+		}
+		info, _ := opts.Extract(tc.remote, header.Get)
+		if info.IP != tc.want {
+			t.Errorf("Extract(%s, %q) IP = %s, want %s", tc.remote, tc.xff, info.IP, tc.want)
+		}
+	}
+
+	if _, err := ParseTrustedProxies("not-an-ip"); err == nil {
+		t.Error("Expected error for invalid proxy")
+	}
+}
+
+func TestMiddleware_EnrichesSentEvents(t *testing.T) {
+	var got Event
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"event_id":"e-1"}`))
+	}))
+	defer gateway.Close()
+	client := NewClient(gateway.URL, "")
+
+	app := Middleware(MiddlewareOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetPrincipal(r.Context(), "alice", ActorUser, "alice@example.com")
+		event := Event{Action: Action{Name: "document.read"}, Resource: Resource{Type: "document", ID: "d-1"}}
+		if _, err := client.Send(r.Context(), event); err != nil {
+			t.Error(err)
+		}
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	app.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.Actor.ID != "alice" || got.Actor.Email != "alice@example.com" {
+		t.Errorf("Expected principal as actor, got %+v", got.Actor)
+	}
+	want := map[string]string{"ip": "198.51.100.7", "user_agent": "test-agent", "request_id": "req-1", "trace_id": "4bf92f3577b34da6a3ce929d0e0e4736", "span_id": "00f067aa0ba902b7"}
+	for key, val := range want {
+		if got.Context[key] != val {
+			t.Errorf("context.%s = %v, want %s", key, got.Context[key], val)
+		}
+	}
+}
+
+func TestFromContext_KeepsExplicitFields(t *testing.T) {
+	ctx := WithRequestInfo(context.Background(), &RequestInfo{IP: "10.0.0.1", Principal: "alice"})
+	event, err := NewEvent("x").Actor("svc", ActorServiceAccount).Resource("t", "1").IP("1.1.1.1").FromContext(ctx).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Actor.ID != "svc" || event.Context["ip"] != "1.1.1.1" {
+		t.Errorf("Expected explicit fields kept, got %+v", event)
+	}
+}
+
+func TestExtract_PrincipalOnlyFromTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MiddlewareOptions{TrustedProxies: proxies, PrincipalHeader: "X-Consumer-Name"}
+	header := http.Header{}
+	header.Set("X-Consumer-Name", "billing")
+
+	if info, _ := opts.Extract("10.1.2.3:5000", header.Get); info.Principal != "billing" {
+		t.Errorf("Trusted proxy: principal = %q, want billing", info.Principal)
+	}
+	// A client that bypasses the proxy cannot choose the actor
+	if info, _ := opts.Extract("203.0.113.9:5000", header.Get); info.Principal != "" {
+		t.Errorf("Untrusted peer: principal = %q, want none", info.Principal)
+	}
+}
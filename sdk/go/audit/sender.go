@@ -0,0 +1,272 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SenderOptions configures a Sender. Zero values select the defaults.
+type SenderOptions struct {
+	// BatchSize flushes once this many events are buffered (default 100)
+	BatchSize int
+	// FlushInterval flushes whatever is buffered at this interval (default 1s)
+	FlushInterval time.Duration
+	// QueueSize bounds the in-memory queue (default 10000)
+	QueueSize int
+	// SpoolDir enables the disk buffer. Batches that cannot be delivered
+	// after retries, and events enqueued while the queue is full, are
+	// written there and replayed once the gateway accepts events again.
+	SpoolDir string
+	// OnError receives rejections and delivery failures. Events are never
+	// silently dropped: without a disk buffer they are reported here.
+	OnError func(error)
+}
+
+// Sender buffers events and sends them to /v1/events/batch in the background
+type Sender struct {
+	client *Client
+	opts   SenderOptions
+	spool  *spool
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan Event
+	flush  chan chan struct{}
+	done   chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewSender starts a background sender. Call Close to flush and stop it.
+func NewSender(client *Client, opts SenderOptions) *Sender {
+	if opts.BatchSize <= 0 || opts.BatchSize > MaxBatchSize {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(error) {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sender{
+		client: client,
+		opts:   opts,
+		queue:  make(chan Event, opts.QueueSize),
+		flush:  make(chan chan struct{}),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if opts.SpoolDir != "" {
+		s.spool = &spool{dir: opts.SpoolDir}
+	}
+	go s.run()
+	return s
+}
+
+// Enqueue buffers an event for sending. Events without an idempotency key
+// get one here, so every retry of the event carries the same key.
+func (s *Sender) Enqueue(event Event) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	if event.IdempotencyKey == "" {
+		event.IdempotencyKey = newKey()
+	}
+	if event.Timestamp == "" {
+		event.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+	select {
+	case s.queue <- event:
+		return nil
+	default:
+	}
+	if s.spool == nil {
+		return ErrBufferFull
+	}
+	return s.spool.write([]Event{event})
+}
+
+// EnqueueContext is Enqueue after filling missing actor and context
+// fields from the request in ctx
+func (s *Sender) EnqueueContext(ctx context.Context, event Event) error {
+	if info, ok := RequestInfoFrom(ctx); ok {
+		info.apply(&event)
+	}
+	return s.Enqueue(event)
+}
+
+// Flush sends everything buffered so far and waits for it to complete
+func (s *Sender) Flush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case s.flush <- ack:
+	case <-s.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting events and sends what is buffered. If ctx expires
+// first, in-flight requests are cancelled and the remaining events are
+// written to the disk buffer (or reported to OnError without one).
+func (s *Sender) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	// Deliver what a previous process left in the disk buffer
+	s.replay()
+
+	batch := make([]Event, 0, s.opts.BatchSize)
+	send := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		ok := s.send(batch)
+		batch = make([]Event, 0, s.opts.BatchSize)
+		return ok
+	}
+
+	for {
+		select {
+		case event, ok := <-s.queue:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= s.opts.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			if send() {
+				s.replay()
+			}
+		case ack := <-s.flush:
+			ok := true
+		drain:
+			for {
+				select {
+				case event, open := <-s.queue:
+					if !open {
+						break drain
+					}
+					batch = append(batch, event)
+					if len(batch) >= s.opts.BatchSize {
+						ok = send() && ok
+					}
+				default:
+					break drain
+				}
+			}
+			if send() && ok {
+				s.replay()
+			}
+			close(ack)
+		}
+	}
+}
+
+// send delivers one batch and reports whether the gateway was reachable
+func (s *Sender) send(batch []Event) bool {
+	result, err := s.client.SendBatch(s.ctx, batch)
+	if err != nil {
+		if isRetryable(err) && s.spool != nil {
+			if spoolErr := s.spool.write(batch); spoolErr != nil {
+				s.opts.OnError(fmt.Errorf("audit: %d events lost: %w", len(batch), errors.Join(err, spoolErr)))
+			}
+		} else {
+			s.opts.OnError(fmt.Errorf("audit: %d events not delivered: %w", len(batch), err))
+		}
+		return false
+	}
+	for _, rejected := range result.Rejected {
+		s.opts.OnError(rejected)
+	}
+	return true
+}
+
+// replay sends spooled batches oldest first and stops at the first one the
+// gateway cannot take yet
+func (s *Sender) replay() {
+	if s.spool == nil {
+		return
+	}
+	files, err := s.spool.files()
+	if err != nil {
+		s.opts.OnError(err)
+		return
+	}
+	for _, file := range files {
+		if s.ctx.Err() != nil {
+			return
+		}
+		events, err := s.spool.read(file)
+		if err != nil {
+			// Set corrupt files aside instead of failing on them every interval
+			os.Rename(file, file+".corrupt")
+			s.opts.OnError(err)
+			continue
+		}
+		for start := 0; start < len(events); start += MaxBatchSize {
+			end := min(start+MaxBatchSize, len(events))
+			result, err := s.client.SendBatch(s.ctx, events[start:end])
+			if err != nil && isRetryable(err) {
+				// Keep the file; batches already sent are deduplicated by idempotency key
+				return
+			}
+			if err != nil {
+				s.opts.OnError(fmt.Errorf("audit: %d spooled events not delivered: %w", end-start, err))
+			}
+			for _, rejected := range result.Rejected {
+				s.opts.OnError(rejected)
+			}
+		}
+		if err := s.spool.remove(file); err != nil {
+			s.opts.OnError(err)
+		}
+	}
+}
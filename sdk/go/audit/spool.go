@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// spool is the disk buffer: one NDJSON file per batch, named so that
+// lexical order is write order
+type spool struct {
+	dir string
+	mu  sync.Mutex
+	seq atomic.Uint64
+}
+
+func (s *spool) write(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("audit: creating spool dir: %w", err)
+	}
+	name := fmt.Sprintf("%020d-%06d.ndjson", time.Now().UnixNano(), s.seq.Add(1)%1000000)
+	tmp, err := os.CreateTemp(s.dir, ".spool-*")
+	if err != nil {
+		return fmt.Errorf("audit: writing spool: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, event := range events {
+		if err = enc.Encode(event); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Rename last so readers never see a partial file
+		err = os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("audit: writing spool: %w", err)
+	}
+	return nil
+}
+
+func (s *spool) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("audit: reading spool dir: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".ndjson") {
+			files = append(files, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *spool) read(file string) ([]Event, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("audit: reading spool: %w", err)
+	}
+	defer f.Close()
+	var events []Event
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var event Event
+		if err := dec.Decode(&event); err != nil {
+			return nil, fmt.Errorf("audit: corrupt spool file %s: %w", filepath.Base(file), err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *spool) remove(file string) error {
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("audit: removing spool file: %w", err)
+	}
+	return nil
+}
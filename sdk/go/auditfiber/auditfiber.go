@@ -0,0 +1,41 @@
+// Package auditfiber captures audit request context in Fiber applications.
+// Handlers pass c.UserContext() to audit.Client.Send or
+// audit.Sender.EnqueueContext and the events are enriched automatically.
+package auditfiber
+
+import (
+	"github.com/alfredohmlopes/poc-auditproject/sdk/go/audit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// New returns middleware that stores an audit.RequestInfo in the request's
+// user context. The client IP is resolved from X-Forwarded-For only when
+// the peer is one of opts.TrustedProxies, independent of Fiber's own
+// proxy settings.
+func New(opts audit.MiddlewareOptions) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Fiber's header values are only valid during the request, and
+		// events may be sent from the Sender's goroutine after it
+		info, generated := opts.Extract(c.Context().RemoteAddr().String(), func(key string) string {
+			return utils.CopyString(c.Get(key))
+		})
+		if generated {
+			c.Set(requestIDHeader(opts), info.RequestID)
+		}
+		c.SetUserContext(audit.WithRequestInfo(c.UserContext(), info))
+		return c.Next()
+	}
+}
+
+// SetPrincipal records the authenticated principal for the current request
+func SetPrincipal(c *fiber.Ctx, id, actorType, email string) {
+	audit.SetPrincipal(c.UserContext(), id, actorType, email)
+}
+
+func requestIDHeader(opts audit.MiddlewareOptions) string {
+	if opts.RequestIDHeader == "" {
+		return "X-Request-ID"
+	}
+	return opts.RequestIDHeader
+}
@@ -0,0 +1,40 @@
+package auditfiber
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alfredohmlopes/poc-auditproject/sdk/go/audit"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMiddleware_StoresRequestInfo(t *testing.T) {
+	var got *audit.RequestInfo
+	// app.Test connections come from 0.0.0.0
+	proxies, err := audit.ParseTrustedProxies("0.0.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Use(New(audit.MiddlewareOptions{TrustedProxies: proxies, PrincipalHeader: "X-Consumer-Name"}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		got, _ = audit.RequestInfoFrom(c.UserContext())
+		return c.SendStatus(204)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "curl/8")
+	req.Header.Set("X-Consumer-Name", "billing")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got == nil || got.UserAgent != "curl/8" || got.Principal != "billing" || got.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("Unexpected request info: %+v", got)
+	}
+	if resp.Header.Get("X-Request-ID") != got.RequestID {
+		t.Errorf("Expected generated request ID on the response, got %q", resp.Header.Get("X-Request-ID"))
+	}
+}
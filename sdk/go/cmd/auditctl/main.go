@@ -0,0 +1,180 @@
+// Command auditctl sends and queries audit events from the command line.
+//
+//	auditctl profile set prod --gateway https://audit.example.com --query https://audit.example.com --api-key KEY
+//	auditctl send --action document.read --actor alice --resource-type document --resource-id d-1
+//	auditctl query --action user.login --from 2025-01-01 -o csv
+//	auditctl tail --action user.login
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: auditctl [--profile NAME] <command> [flags]
+
+Commands:
+  send       Send one event, or an NDJSON file of events, to the gateway
+  query      List events matching filters (follows pagination)
+  get        Show one event by ID
+  aggregate  Count events grouped by one or more dimensions
+  export     Download events as CSV
+  tail       Poll for new events and print them as they arrive
+  profile    Manage profiles (set, use, list, show)
+
+Run "auditctl <command> -h" for the flags of a command.
+`
+
+type command func(ctx context.Context, p Profile, args []string, stdout io.Writer) error
+
+var commands = map[string]command{
+	"send":      runSend,
+	"query":     runQuery,
+	"get":       runGet,
+	"aggregate": runAggregate,
+	"export":    runExport,
+	"tail":      runTail,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "auditctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	global := flag.NewFlagSet("auditctl", flag.ContinueOnError)
+	global.Usage = func() { fmt.Fprint(global.Output(), usage) }
+	profileName := global.String("profile", os.Getenv("AUDITCTL_PROFILE"), "profile to use")
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return errors.New("no command given")
+	}
+
+	name, rest := global.Arg(0), global.Args()[1:]
+	if name == "profile" {
+		return runProfile(rest, stdout)
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		global.Usage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+	profile, err := loadProfile(*profileName)
+	if err != nil {
+		return err
+	}
+	return cmd(ctx, profile, rest, stdout)
+}
+
+// filterFlags are the /v1/events filters shared by query, export and tail
+type filterFlags struct {
+	action, actorID, resourceType, resourceID, from, to, success, q string
+}
+
+func (f *filterFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.action, "action", "", "action name")
+	fs.StringVar(&f.actorID, "actor", "", "actor ID")
+	fs.StringVar(&f.resourceType, "resource-type", "", "resource type")
+	fs.StringVar(&f.resourceID, "resource-id", "", "resource ID")
+	fs.StringVar(&f.from, "from", "", "first event date (YYYY-MM-DD)")
+	fs.StringVar(&f.to, "to", "", "last event date (YYYY-MM-DD)")
+	fs.StringVar(&f.success, "success", "", "true or false")
+	fs.StringVar(&f.q, "q", "", "query, e.g. 'actor.id:alice AND action:auth.*' or free text")
+}
+
+func (f *filterFlags) values() url.Values {
+	v := url.Values{}
+	for key, val := range map[string]string{
+		"action":        f.action,
+		"actor_id":      f.actorID,
+		"resource_type": f.resourceType,
+		"resource_id":   f.resourceID,
+		"from":          f.from,
+		"to":            f.to,
+		"success":       f.success,
+		"q":             f.q,
+	} {
+		if val != "" {
+			v.Set(key, val)
+		}
+	}
+	return v
+}
+
+// apiError is a non-2xx response from the query API or gateway
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// queryGet sends a GET to the query API and returns the response for
+// streaming; callers close the body
+func queryGet(ctx context.Context, p Profile, path string, params url.Values) (*http.Response, error) {
+	if p.QueryURL == "" {
+		return nil, errors.New("no query URL configured; run auditctl profile set")
+	}
+	target := strings.TrimRight(p.QueryURL, "/") + path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.APIKey != "" {
+		req.Header.Set("X-API-Key", p.APIKey)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		var msg struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if json.Unmarshal(data, &msg) == nil {
+			apiErr.Message = msg.Error
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// queryJSON decodes a query API response into out
+func queryJSON(ctx context.Context, p Profile, path string, params url.Values, out interface{}) error {
+	resp, err := queryGet(ctx, p, path, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
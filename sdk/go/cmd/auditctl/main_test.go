@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfiles_SetUseAndOverride(t *testing.T) {
+	t.Setenv("AUDITCTL_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	ctx := context.Background()
+	var out bytes.Buffer
+
+	if err := run(ctx, []string{"profile", "set", "dev", "--gateway", "http://gw", "--api-key", "k-dev"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(ctx, []string{"profile", "set", "prod", "--query", "http://q", "--api-key", "k-prod-1234"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(ctx, []string{"profile", "use", "prod"}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := loadProfile("")
+	if err != nil || profile.QueryURL != "http://q" || profile.APIKey != "k-prod-1234" {
+		t.Fatalf("Unexpected current profile %+v (%v)", profile, err)
+	}
+	t.Setenv("AUDITCTL_API_KEY", "override")
+	if profile, _ = loadProfile("dev"); profile.GatewayURL != "http://gw" || profile.APIKey != "override" {
+		t.Errorf("Unexpected dev profile %+v", profile)
+	}
+
+	out.Reset()
+	run(ctx, []string{"profile", "show"}, &out)
+	if strings.Contains(out.String(), "k-prod-1234") || !strings.Contains(out.String(), "****1234") {
+		t.Errorf("Expected masked API key, got %s", out.String())
+	}
+}
+
+func TestQuery_FollowsCursors(t *testing.T) {
+	var cursors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "k" || r.URL.Query().Get("action") != "user.login" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		cursor := r.URL.Query().Get("cursor")
+		cursors = append(cursors, cursor)
+		resp := map[string]interface{}{"data": []map[string]interface{}{{"event_id": "e-" + cursor, "actor": map[string]string{"id": "alice"}}}}
+		if cursor == "" {
+			resp["pagination"] = map[string]interface{}{"cursor": "next", "has_more": true}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	t.Setenv("AUDITCTL_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("AUDITCTL_QUERY_URL", srv.URL)
+	t.Setenv("AUDITCTL_API_KEY", "k")
+	var out bytes.Buffer
+	if err := run(context.Background(), []string{"query", "--action", "user.login", "-o", "csv"}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cursors) != 2 || cursors[1] != "next" {
+		t.Errorf("Expected two pages, got cursors %q", cursors)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "e-,") || !strings.HasPrefix(lines[2], "e-next,") {
+		t.Errorf("Unexpected CSV output:\n%s", out.String())
+	}
+}
+
+func TestTail_PagesBackToLastSeen(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"data":[{"event_id":"e5"},{"event_id":"e4"}],"pagination":{"cursor":"c1","has_more":true}}`,
+		"c1": `{"data":[{"event_id":"e3"},{"event_id":"e2"}],"pagination":{"cursor":"c2","has_more":true}}`,
+		"c2": `{"data":[{"event_id":"e1"}]}`,
+	}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(pages[r.URL.Query().Get("cursor")]))
+	}))
+	defer srv.Close()
+
+	events, err := pollEvents(context.Background(), Profile{QueryURL: srv.URL}, url.Values{}, map[string]bool{"e3": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, ev := range events {
+		ids = append(ids, ev.EventID)
+	}
+	if strings.Join(ids, ",") != "e5,e4,e3,e2" || requests != 2 {
+		t.Errorf("Got %v in %d requests, want e5..e2 in 2", ids, requests)
+	}
+}
+
+func TestSend_SingleEvent(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"event_id":"e-1","received_at":"2025-01-01T00:00:00Z"}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("AUDITCTL_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("AUDITCTL_GATEWAY_URL", srv.URL)
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"send", "--action", "document.read", "--actor", "alice",
+		"--resource-type", "document", "--resource-id", "d-1", "--context", "ip=10.0.0.1"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "e-1") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+	if ctx, _ := got["context"].(map[string]interface{}); ctx["ip"] != "10.0.0.1" || got["idempotency_key"] == "" {
+		t.Errorf("Unexpected sent event %v", got)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Profile holds the endpoints and credentials of one environment
+type Profile struct {
+	GatewayURL string `json:"gateway_url"`
+	QueryURL   string `json:"query_url"`
+	APIKey     string `json:"api_key"`
+}
+
+// profileFile is stored at $AUDITCTL_CONFIG or <user config dir>/auditctl/config.json
+type profileFile struct {
+	Current  string             `json:"current"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+func configPath() (string, error) {
+	if path := os.Getenv("AUDITCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "auditctl", "config.json"), nil
+}
+
+func readProfiles() (profileFile, error) {
+	file := profileFile{Profiles: map[string]Profile{}}
+	path, err := configPath()
+	if err != nil {
+		return file, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return file, err
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if file.Profiles == nil {
+		file.Profiles = map[string]Profile{}
+	}
+	return file, nil
+}
+
+func writeProfiles(file profileFile) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The file holds API keys
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// loadProfile resolves the named (or current) profile. AUDITCTL_GATEWAY_URL,
+// AUDITCTL_QUERY_URL and AUDITCTL_API_KEY override its fields.
+func loadProfile(name string) (Profile, error) {
+	file, err := readProfiles()
+	if err != nil {
+		return Profile{}, err
+	}
+	if name == "" {
+		name = file.Current
+	}
+	var profile Profile
+	if name != "" {
+		var ok bool
+		if profile, ok = file.Profiles[name]; !ok {
+			return Profile{}, fmt.Errorf("unknown profile %q", name)
+		}
+	}
+	if v := os.Getenv("AUDITCTL_GATEWAY_URL"); v != "" {
+		profile.GatewayURL = v
+	}
+	if v := os.Getenv("AUDITCTL_QUERY_URL"); v != "" {
+		profile.QueryURL = v
+	}
+	if v := os.Getenv("AUDITCTL_API_KEY"); v != "" {
+		profile.APIKey = v
+	}
+	return profile, nil
+}
+
+func runProfile(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: auditctl profile set|use|list|show [NAME]")
+	}
+	file, err := readProfiles()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "set":
+		fs := flag.NewFlagSet("profile set", flag.ContinueOnError)
+		gateway := fs.String("gateway", "", "event gateway base URL")
+		query := fs.String("query", "", "query API base URL")
+		apiKey := fs.String("api-key", "", "API key sent as X-API-Key")
+		if len(args) < 2 {
+			return errors.New("usage: auditctl profile set NAME [--gateway URL] [--query URL] [--api-key KEY]")
+		}
+		name := args[1]
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		profile := file.Profiles[name]
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "gateway":
+				profile.GatewayURL = *gateway
+			case "query":
+				profile.QueryURL = *query
+			case "api-key":
+				profile.APIKey = *apiKey
+			}
+		})
+		file.Profiles[name] = profile
+		if file.Current == "" {
+			file.Current = name
+		}
+		return writeProfiles(file)
+	case "use":
+		if len(args) != 2 {
+			return errors.New("usage: auditctl profile use NAME")
+		}
+		if _, ok := file.Profiles[args[1]]; !ok {
+			return fmt.Errorf("unknown profile %q", args[1])
+		}
+		file.Current = args[1]
+		return writeProfiles(file)
+	case "list":
+		names := make([]string, 0, len(file.Profiles))
+		for name := range file.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			marker := " "
+			if name == file.Current {
+				marker = "*"
+			}
+			fmt.Fprintf(stdout, "%s %s\t%s\t%s\n", marker, name, file.Profiles[name].GatewayURL, file.Profiles[name].QueryURL)
+		}
+		return nil
+	case "show":
+		name := file.Current
+		if len(args) > 1 {
+			name = args[1]
+		}
+		profile, ok := file.Profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+		if profile.APIKey != "" {
+			profile.APIKey = maskKey(profile.APIKey)
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(profile)
+	}
+	return fmt.Errorf("unknown profile command %q", args[0])
+}
+
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// event is the query API's event representation
+type event struct {
+	EventID    string                 `json:"event_id"`
+	TenantID   string                 `json:"tenant_id"`
+	EventDate  string                 `json:"event_date"`
+	ReceivedAt string                 `json:"received_at"`
+	Timestamp  string                 `json:"timestamp,omitempty"`
+	Actor      map[string]interface{} `json:"actor"`
+	Action     map[string]interface{} `json:"action"`
+	Resource   map[string]interface{} `json:"resource"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	Context    map[string]interface{} `json:"context,omitempty"`
+}
+
+type listResponse struct {
+	Data       []event `json:"data"`
+	Pagination struct {
+		Cursor  string `json:"cursor"`
+		HasMore bool   `json:"has_more"`
+	} `json:"pagination"`
+}
+
+// listEvents follows pagination cursors until max events are collected or
+// the server reports no more pages
+func listEvents(ctx context.Context, p Profile, params url.Values, max int) ([]event, error) {
+	var events []event
+	pageSize := 1000
+	if max > 0 && max < pageSize {
+		pageSize = max
+	}
+	params.Set("limit", strconv.Itoa(pageSize))
+	for {
+		var page listResponse
+		if err := queryJSON(ctx, p, "/v1/events", params, &page); err != nil {
+			return nil, err
+		}
+		events = append(events, page.Data...)
+		if max > 0 && len(events) >= max {
+			return events[:max], nil
+		}
+		if !page.Pagination.HasMore || page.Pagination.Cursor == "" {
+			return events, nil
+		}
+		params.Set("cursor", page.Pagination.Cursor)
+	}
+}
+
+// pollEvents pages through the newest events until a page contains one of
+// seen, so a burst larger than a page is returned in full. Events come
+// newest first.
+func pollEvents(ctx context.Context, p Profile, params url.Values, seen map[string]bool) ([]event, error) {
+	var events []event
+	params.Set("limit", "200")
+	for {
+		var page listResponse
+		if err := queryJSON(ctx, p, "/v1/events", params, &page); err != nil {
+			return nil, err
+		}
+		events = append(events, page.Data...)
+		reached := false
+		for _, ev := range page.Data {
+			reached = reached || seen[ev.EventID]
+		}
+		if reached || !page.Pagination.HasMore || page.Pagination.Cursor == "" {
+			return events, nil
+		}
+		params.Set("cursor", page.Pagination.Cursor)
+	}
+}
+
+func runQuery(ctx context.Context, p Profile, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	var filters filterFlags
+	filters.register(fs)
+	output := fs.String("o", "table", "output format: table, json or csv")
+	max := fs.Int("max", 1000, "maximum number of events (0 for all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" && *output != "csv" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	events, err := listEvents(ctx, p, filters.values(), *max)
+	if err != nil {
+		return err
+	}
+	return writeEvents(stdout, *output, events)
+}
+
+func runGet(ctx context.Context, p Profile, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: auditctl get EVENT_ID")
+	}
+	var ev map[string]interface{}
+	if err := queryJSON(ctx, p, "/v1/events/"+url.PathEscape(args[0]), nil, &ev); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ev)
+}
+
+func runAggregate(ctx context.Context, p Profile, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("aggregate", flag.ContinueOnError)
+	var filters filterFlags
+	filters.register(fs)
+	groupBy := fs.String("group-by", "action", "comma-separated dimensions: action, actor, actor_type, resource_type, resource_id, success, date, tenant")
+	metrics := fs.String("metrics", "", "comma-separated metrics: count, success, failed, success_rate, failure_rate, unique_actors, unique_resources")
+	having := fs.String("having", "", "thresholds, e.g. count>=10,failure_rate>0.5")
+	orderBy := fs.String("order-by", "", "metric to rank groups by (default count)")
+	topN := fs.Int("top", 0, "number of groups before the rest is summed into other (default 100)")
+	output := fs.String("o", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	params := filters.values()
+	params.Set("group_by", *groupBy)
+	for key, val := range map[string]string{"metrics": *metrics, "having": *having, "order_by": *orderBy} {
+		if val != "" {
+			params.Set(key, val)
+		}
+	}
+	if *topN > 0 {
+		params.Set("top_n", strconv.Itoa(*topN))
+	}
+
+	var resp struct {
+		GroupBy      []string                 `json:"group_by"`
+		Metrics      []string                 `json:"metrics"`
+		Aggregations []map[string]interface{} `json:"aggregations"`
+		Other        map[string]interface{}   `json:"other,omitempty"`
+		Total        int64                    `json:"total"`
+	}
+	if err := queryJSON(ctx, p, "/v1/events/aggregations", params, &resp); err != nil {
+		return err
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	var header []string
+	for _, dim := range resp.GroupBy {
+		header = append(header, strings.ToUpper(dim))
+	}
+	for _, m := range resp.Metrics {
+		header = append(header, strings.ToUpper(m))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	row := func(bucket map[string]interface{}, label string) {
+		key, _ := bucket["key"].(map[string]interface{})
+		var cells []string
+		for i, dim := range resp.GroupBy {
+			cell := fmt.Sprint(key[dim])
+			if label != "" {
+				cell = ""
+				if i == 0 {
+					cell = label
+				}
+			}
+			cells = append(cells, cell)
+		}
+		for _, m := range resp.Metrics {
+			cells = append(cells, formatMetric(bucket[m]))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	for _, bucket := range resp.Aggregations {
+		row(bucket, "")
+	}
+	if resp.Other != nil {
+		row(resp.Other, "(other)")
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\n", resp.Total)
+	return tw.Flush()
+}
+
+// formatMetric prints counts as integers and rates with three decimals
+func formatMetric(v interface{}) string {
+	f, ok := v.(float64)
+	if !ok {
+		return ""
+	}
+	if f == float64(int64(f)) {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+func runExport(ctx context.Context, p Profile, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var filters filterFlags
+	filters.register(fs)
+	format := fs.String("format", "csv", "export format: csv, ndjson, json, parquet or arrow")
+	columns := fs.String("columns", "", "comma-separated columns (default set chosen by the server)")
+	outPath := fs.String("out", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	params := filters.values()
+	params.Set("format", *format)
+	if *columns != "" {
+		params.Set("columns", *columns)
+	}
+
+	resp, err := queryGet(ctx, p, "/v1/events/export", params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	out := stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// runTail polls for the newest events and prints the ones not seen yet,
+// oldest first. Each poll pages back to the events of the previous one.
+func runTail(ctx context.Context, p Profile, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	var filters filterFlags
+	filters.register(fs)
+	interval := fs.Duration("interval", 2*time.Second, "poll interval")
+	output := fs.String("o", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	first, header := true, true
+	for {
+		params := filters.values()
+		if filters.from == "" {
+			params.Set("from", time.Now().UTC().Format("2006-01-02"))
+		}
+		var events []event
+		var err error
+		if first {
+			events, err = listEvents(ctx, p, params, 200)
+		} else {
+			events, err = pollEvents(ctx, p, params, seen)
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintln(os.Stderr, "auditctl tail:", err)
+		}
+
+		// Events come newest first; print the unseen ones oldest first
+		var fresh []event
+		current := map[string]bool{}
+		for i := len(events) - 1; i >= 0; i-- {
+			current[events[i].EventID] = true
+			if !seen[events[i].EventID] {
+				fresh = append(fresh, events[i])
+			}
+		}
+		if err == nil {
+			// Only remember this poll's events so memory stays bounded
+			seen = current
+			// Like tail(1), start with the last few events only
+			if first && len(fresh) > 20 {
+				fresh = fresh[len(fresh)-20:]
+			}
+			first = false
+		}
+		if len(fresh) > 0 {
+			writeEventStream(stdout, *output, fresh, header)
+			header = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+func writeEvents(w io.Writer, format string, events []event) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if events == nil {
+			events = []event{}
+		}
+		return enc.Encode(events)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"event_id", "tenant_id", "event_date", "received_at", "actor_id", "action", "resource_type", "resource_id", "success"})
+		for _, ev := range events {
+			cw.Write(eventRow(ev))
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return writeEventStream(w, format, events, true)
+}
+
+// writeEventStream writes table rows, optionally with the header, or one
+// JSON object per line
+func writeEventStream(w io.Writer, format string, events []event, header bool) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, ev := range events {
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if header {
+		fmt.Fprintln(tw, "RECEIVED_AT\tACTOR\tACTION\tRESOURCE\tSUCCESS\tEVENT_ID")
+	}
+	for _, ev := range events {
+		row := eventRow(ev)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s/%s\t%s\t%s\n", row[3], row[4], row[5], row[6], row[7], row[8], row[0])
+	}
+	return tw.Flush()
+}
+
+func eventRow(ev event) []string {
+	str := func(m map[string]interface{}, key string) string {
+		if v, ok := m[key]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	return []string{
+		ev.EventID, ev.TenantID, ev.EventDate, ev.ReceivedAt,
+		str(ev.Actor, "id"), str(ev.Action, "name"),
+		str(ev.Resource, "type"), str(ev.Resource, "id"),
+		str(ev.Result, "success"),
+	}
+}
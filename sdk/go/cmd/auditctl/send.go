@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alfredohmlopes/poc-auditproject/sdk/go/audit"
+)
+
+// kvFlags collects repeated key=value flags
+type kvFlags map[string]string
+
+func (kv kvFlags) String() string { return "" }
+
+func (kv kvFlags) Set(s string) error {
+	key, val, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	kv[key] = val
+	return nil
+}
+
+func runSend(ctx context.Context, p Profile, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	file := fs.String("f", "", "NDJSON file of events to send (- for stdin)")
+	action := fs.String("action", "", "action name")
+	actor := fs.String("actor", "", "actor ID")
+	actorType := fs.String("actor-type", audit.ActorUser, "actor type")
+	actorEmail := fs.String("actor-email", "", "actor email")
+	resourceType := fs.String("resource-type", "", "resource type")
+	resourceID := fs.String("resource-id", "", "resource ID")
+	tenant := fs.String("tenant", "", "tenant ID")
+	failure := fs.String("failure", "", "mark the action failed with this message")
+	contextKV := kvFlags{}
+	fs.Var(contextKV, "context", "context attribute key=value (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if p.GatewayURL == "" {
+		return errors.New("no gateway URL configured; run auditctl profile set")
+	}
+	client := audit.NewClient(p.GatewayURL, p.APIKey)
+
+	if *file != "" {
+		return sendFile(ctx, client, *file, stdout)
+	}
+
+	b := audit.NewEvent(*action).
+		Actor(*actor, *actorType).
+		ActorEmail(*actorEmail).
+		Resource(*resourceType, *resourceID).
+		Tenant(*tenant)
+	if *failure != "" {
+		b.Failure(*failure)
+	} else {
+		b.Success()
+	}
+	for key, val := range contextKV {
+		b.With(key, val)
+	}
+	event, err := b.Build()
+	if err != nil {
+		return err
+	}
+	accepted, err := client.Send(ctx, event)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s\t%s\n", accepted.EventID, accepted.ReceivedAt)
+	return nil
+}
+
+// sendFile sends an NDJSON file in batches. SendBatch keys events without
+// an idempotency key for its own retries, but a re-run generates new keys,
+// so re-running after a partial failure is safe only for files whose
+// events carry their own keys.
+func sendFile(ctx context.Context, client *audit.Client, path string, stdout io.Writer) error {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var accepted, rejected int
+	var batch []audit.Event
+	var lines []int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result, err := client.SendBatch(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("sending lines %d-%d: %w", lines[0], lines[len(lines)-1], err)
+		}
+		accepted += len(result.Accepted)
+		for i, rej := range result.Rejected {
+			rejected++
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", lines[i], rej.Reason)
+		}
+		batch, lines = batch[:0], lines[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var event audit.Event
+		if err := json.Unmarshal([]byte(text), &event); err != nil {
+			rejected++
+			fmt.Fprintf(os.Stderr, "line %d: invalid JSON\n", line)
+			continue
+		}
+		if err := event.Validate(); err != nil {
+			rejected++
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+			continue
+		}
+		batch = append(batch, event)
+		lines = append(lines, line)
+		if len(batch) == audit.MaxBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "accepted %d, rejected %d\n", accepted, rejected)
+	if rejected > 0 {
+		return fmt.Errorf("%d events rejected", rejected)
+	}
+	return nil
+}
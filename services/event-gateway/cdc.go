@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const cdcStatusInterval = 10 * time.Second
+
+// cdcConfig configures the PostgreSQL logical replication source
+type cdcConfig struct {
+	// DatabaseURL must include replication=database
+	DatabaseURL  string
+	Slot         string
+	Publication  string
+	Tables       []string
+	ActorPrefix  string
+	ActorColumns []string
+	DefaultActor string
+	Tenant       string
+}
+
+func loadCDCConfig() cdcConfig {
+	cfg := cdcConfig{
+		DatabaseURL:  os.Getenv("CDC_DATABASE_URL"),
+		Slot:         getEnv("CDC_SLOT", "audit_cdc"),
+		Publication:  getEnv("CDC_PUBLICATION", "audit_cdc"),
+		ActorPrefix:  getEnv("CDC_ACTOR_MESSAGE_PREFIX", "audit.actor"),
+		DefaultActor: getEnv("CDC_DEFAULT_ACTOR", "postgres"),
+		Tenant:       os.Getenv("CDC_TENANT"),
+	}
+	cfg.Tables = csvList(os.Getenv("CDC_TABLES"))
+	cfg.ActorColumns = csvList(getEnv("CDC_ACTOR_COLUMNS", "updated_by,modified_by,created_by"))
+	return cfg
+}
+
+// csvList parses a comma-separated list, keeping its order
+func csvList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// cdcChange is one row change inside a transaction
+type cdcChange struct {
+	Operation string
+	Relation  *pglogrepl.RelationMessage
+	Before    map[string]interface{}
+	After     map[string]interface{}
+	Changed   []string
+	Actor     string
+	LSN       pglogrepl.LSN
+}
+
+// cdcTransaction buffers row changes until the transaction commits
+type cdcTransaction struct {
+	Xid        uint32
+	CommitTime time.Time
+	Actor      string
+	Changes    []cdcChange
+}
+
+// cdcDecoder turns pgoutput messages into audit events
+type cdcDecoder struct {
+	cfg       cdcConfig
+	relations map[uint32]*pglogrepl.RelationMessage
+	tx        *cdcTransaction
+}
+
+func newCDCDecoder(cfg cdcConfig) *cdcDecoder {
+	return &cdcDecoder{cfg: cfg, relations: map[uint32]*pglogrepl.RelationMessage{}}
+}
+
+// handle consumes one pgoutput message. Events are only returned once the
+// transaction that produced them commits.
+func (d *cdcDecoder) handle(msg pglogrepl.Message, walStart pglogrepl.LSN) ([]Event, error) {
+	switch m := msg.(type) {
+	case *pglogrepl.RelationMessage:
+		d.relations[m.RelationID] = m
+	case *pglogrepl.BeginMessage:
+		d.tx = &cdcTransaction{Xid: m.Xid, CommitTime: m.CommitTime}
+	case *pglogrepl.LogicalDecodingMessage:
+		// Emitted by audit_emit_actor() from the audit.actor session variable
+		if m.Prefix == d.cfg.ActorPrefix && d.tx != nil {
+			d.tx.Actor = string(m.Content)
+		}
+	case *pglogrepl.InsertMessage:
+		rel, err := d.relation(m.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		d.addChange(cdcChange{Operation: "created", Relation: rel, After: decodeTuple(rel, m.Tuple), LSN: walStart})
+	case *pglogrepl.UpdateMessage:
+		rel, err := d.relation(m.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		change := cdcChange{Operation: "updated", Relation: rel, After: decodeTuple(rel, m.NewTuple), LSN: walStart}
+		if m.OldTuple != nil {
+			change.Before = decodeTuple(rel, m.OldTuple)
+			change.Changed = changedColumns(rel, change.Before, change.After, m.NewTuple)
+		}
+		d.addChange(change)
+	case *pglogrepl.DeleteMessage:
+		rel, err := d.relation(m.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		d.addChange(cdcChange{Operation: "deleted", Relation: rel, Before: decodeTuple(rel, m.OldTuple), LSN: walStart})
+	case *pglogrepl.CommitMessage:
+		if d.tx == nil {
+			return nil, nil
+		}
+		events := make([]Event, 0, len(d.tx.Changes))
+		for i, change := range d.tx.Changes {
+			event := d.toEvent(change, d.tx)
+			// A transaction replayed after a reconnect keeps its event_ids
+			event.IdempotencyKey = fmt.Sprintf("%s/%d", m.CommitLSN, i)
+			events = append(events, event)
+		}
+		d.tx = nil
+		return events, nil
+	}
+	return nil, nil
+}
+
+// inTransaction reports whether a transaction has begun but not committed
+func (d *cdcDecoder) inTransaction() bool {
+	return d.tx != nil
+}
+
+func (d *cdcDecoder) relation(id uint32) (*pglogrepl.RelationMessage, error) {
+	rel, ok := d.relations[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown relation %d", id)
+	}
+	return rel, nil
+}
+
+func (d *cdcDecoder) addChange(change cdcChange) {
+	if d.tx == nil {
+		return
+	}
+	// A statement-level trigger emits the actor before the rows it touches
+	change.Actor = d.tx.Actor
+	d.tx.Changes = append(d.tx.Changes, change)
+}
+
+// toEvent maps a row change to resource.created/updated/deleted
+func (d *cdcDecoder) toEvent(change cdcChange, tx *cdcTransaction) Event {
+	rel := change.Relation
+	row := change.After
+	if row == nil {
+		row = change.Before
+	}
+
+	event := Event{
+		Actor:     map[string]interface{}{"type": "user"},
+		Action:    map[string]interface{}{"name": "resource." + change.Operation},
+		Resource:  map[string]interface{}{"type": rel.RelationName},
+		Result:    map[string]interface{}{"success": true},
+		Timestamp: tx.CommitTime.UTC().Format(time.RFC3339Nano),
+		TenantID:  d.cfg.Tenant,
+		Producer:  "cdc",
+		Context: map[string]interface{}{
+			"source": "postgres_cdc",
+			"schema": rel.Namespace,
+			"table":  rel.RelationName,
+			"xid":    tx.Xid,
+			"lsn":    change.LSN.String(),
+		},
+	}
+
+	actor := change.Actor
+	if actor == "" {
+		for _, col := range d.cfg.ActorColumns {
+			if val, ok := row[col]; ok && val != nil && fmt.Sprint(val) != "" {
+				actor = fmt.Sprint(val)
+				break
+			}
+		}
+	}
+	if actor == "" {
+		actor = d.cfg.DefaultActor
+		event.Actor["type"] = "system"
+	}
+	event.Actor["id"] = actor
+
+	var keys []string
+	for _, col := range rel.Columns {
+		if col.Flags&1 == 1 {
+			keys = append(keys, fmt.Sprint(row[col.Name]))
+		}
+	}
+	if len(keys) > 0 {
+		event.Resource["id"] = strings.Join(keys, ",")
+	} else {
+		// Tables without a replica identity key are identified by the change position
+		event.Resource["id"] = change.LSN.String()
+	}
+
+	if change.Before != nil {
+		event.Context["before"] = change.Before
+	}
+	if change.After != nil {
+		event.Context["after"] = change.After
+	}
+	if change.Changed != nil {
+		event.Context["changed_columns"] = change.Changed
+	}
+	return event
+}
+
+// decodeTuple converts a pgoutput tuple into a column map. Unchanged TOAST
+// values are not sent by the server and are left out.
+func decodeTuple(rel *pglogrepl.RelationMessage, tuple *pglogrepl.TupleData) map[string]interface{} {
+	if tuple == nil {
+		return nil
+	}
+	row := make(map[string]interface{}, len(tuple.Columns))
+	for i, col := range tuple.Columns {
+		if i >= len(rel.Columns) {
+			break
+		}
+		name := rel.Columns[i].Name
+		switch col.DataType {
+		case pglogrepl.TupleDataTypeNull:
+			row[name] = nil
+		case pglogrepl.TupleDataTypeText:
+			row[name] = decodeTextValue(rel.Columns[i].DataType, string(col.Data))
+		}
+	}
+	return row
+}
+
+// decodeTextValue keeps JSON-friendly types for common scalars and returns
+// the text representation for everything else
+func decodeTextValue(oid uint32, text string) interface{} {
+	switch oid {
+	case pgtype.BoolOID:
+		return text == "t"
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID:
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n
+		}
+	case pgtype.Float4OID, pgtype.Float8OID:
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		var v interface{}
+		if err := json.Unmarshal([]byte(text), &v); err == nil {
+			return v
+		}
+	}
+	return text
+}
+
+// changedColumns lists the columns whose value differs between the before
+// and after images, in table order
+func changedColumns(rel *pglogrepl.RelationMessage, before, after map[string]interface{}, newTuple *pglogrepl.TupleData) []string {
+	changed := []string{}
+	for i, col := range rel.Columns {
+		if newTuple != nil && i < len(newTuple.Columns) && newTuple.Columns[i].DataType == pglogrepl.TupleDataTypeToast {
+			continue
+		}
+		if !reflect.DeepEqual(before[col.Name], after[col.Name]) {
+			changed = append(changed, col.Name)
+		}
+	}
+	return changed
+}
+
+// startCDCSource runs the logical replication consumer when CDC_DATABASE_URL is set
+func startCDCSource() {
+	cfg := loadCDCConfig()
+	if cfg.DatabaseURL == "" {
+		return
+	}
+	log.Printf("PostgreSQL CDC source started (slot %s, publication %s)", cfg.Slot, cfg.Publication)
+	go func() {
+		for {
+			if err := runCDC(context.Background(), cfg); err != nil {
+				log.Printf("CDC error, reconnecting: %v", err)
+			}
+			time.Sleep(5 * time.Second)
+		}
+	}()
+}
+
+// runCDC streams changes until the connection fails or a transaction cannot
+// be delivered. The slot is the checkpoint: a transaction is confirmed to it
+// only after Vector took its events, so a restart resumes with the first
+// transaction that was not delivered.
+func runCDC(ctx context.Context, cfg cdcConfig) error {
+	conn, err := pgconn.Connect(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	if err := ensurePublication(ctx, conn, cfg); err != nil {
+		return err
+	}
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, cfg.Slot, "pgoutput", pglogrepl.CreateReplicationSlotOptions{})
+	var pgErr *pgconn.PgError
+	if err != nil && !(errors.As(err, &pgErr) && pgErr.Code == "42710") {
+		return fmt.Errorf("create slot: %w", err)
+	}
+
+	confirmed, err := slotConfirmedLSN(ctx, conn, cfg.Slot)
+	if err != nil {
+		return err
+	}
+	err = pglogrepl.StartReplication(ctx, conn, cfg.Slot, confirmed, pglogrepl.StartReplicationOptions{
+		PluginArgs: []string{
+			"proto_version '1'",
+			fmt.Sprintf("publication_names '%s'", cfg.Publication),
+			"messages 'true'",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("start replication: %w", err)
+	}
+
+	decoder := newCDCDecoder(cfg)
+	nextStatus := time.Now().Add(cdcStatusInterval)
+	for {
+		if time.Now().After(nextStatus) {
+			if err := pglogrepl.SendStandbyStatusUpdate(ctx, conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: confirmed}); err != nil {
+				return err
+			}
+			nextStatus = time.Now().Add(cdcStatusInterval)
+		}
+
+		recvCtx, cancel := context.WithDeadline(ctx, nextStatus)
+		raw, err := conn.ReceiveMessage(recvCtx)
+		cancel()
+		if err != nil {
+			if pgconn.Timeout(err) {
+				continue
+			}
+			return err
+		}
+
+		copyData, ok := raw.(*pgproto3.CopyData)
+		if !ok {
+			if errMsg, ok := raw.(*pgproto3.ErrorResponse); ok {
+				return fmt.Errorf("replication error: %s", errMsg.Message)
+			}
+			continue
+		}
+
+		switch copyData.Data[0] {
+		case pglogrepl.PrimaryKeepaliveMessageByteID:
+			keepalive, err := pglogrepl.ParsePrimaryKeepaliveMessage(copyData.Data[1:])
+			if err != nil {
+				return err
+			}
+			// With no transaction open everything up to the server's WAL end
+			// was delivered or not published, so the slot can release it
+			if !decoder.inTransaction() && keepalive.ServerWALEnd > confirmed {
+				confirmed = keepalive.ServerWALEnd
+			}
+			if keepalive.ReplyRequested {
+				nextStatus = time.Time{}
+			}
+		case pglogrepl.XLogDataByteID:
+			xld, err := pglogrepl.ParseXLogData(copyData.Data[1:])
+			if err != nil {
+				return err
+			}
+			msg, err := pglogrepl.Parse(xld.WALData)
+			if err != nil {
+				return err
+			}
+			events, err := decoder.handle(msg, xld.WALStart)
+			if err != nil {
+				return err
+			}
+			if commit, ok := msg.(*pglogrepl.CommitMessage); ok {
+				if len(events) > 0 {
+					resp, err := deliverBatch(events, time.Now().UTC().Format(time.RFC3339Nano))
+					if err != nil {
+						return fmt.Errorf("deliver transaction %d: %w", commit.TransactionEndLSN, err)
+					}
+					if resp.Rejected > 0 {
+						log.Printf("CDC: %d row changes rejected", resp.Rejected)
+					}
+				}
+				confirmed = commit.TransactionEndLSN
+			}
+		}
+	}
+}
+
+// slotConfirmedLSN is how far the slot has been confirmed. It is the only
+// checkpoint, so it survives the gateway's restarts.
+func slotConfirmedLSN(ctx context.Context, conn *pgconn.PgConn, slot string) (pglogrepl.LSN, error) {
+	sql := fmt.Sprintf("SELECT confirmed_flush_lsn FROM pg_replication_slots WHERE slot_name = '%s'", strings.ReplaceAll(slot, "'", "''"))
+	results, err := conn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(results) == 0 || len(results[0].Rows) == 0 || results[0].Rows[0][0] == nil {
+		return 0, nil
+	}
+	return pglogrepl.ParseLSN(string(results[0].Rows[0][0]))
+}
+
+// ensurePublication creates the publication for the configured tables if missing
+func ensurePublication(ctx context.Context, conn *pgconn.PgConn, cfg cdcConfig) error {
+	results, err := conn.Exec(ctx, fmt.Sprintf("SELECT 1 FROM pg_publication WHERE pubname = '%s'", strings.ReplaceAll(cfg.Publication, "'", "''"))).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(results) > 0 && len(results[0].Rows) > 0 {
+		return nil
+	}
+	if len(cfg.Tables) == 0 {
+		return errors.New("publication does not exist and CDC_TABLES is empty")
+	}
+
+	tables := make([]string, 0, len(cfg.Tables))
+	for _, table := range cfg.Tables {
+		tables = append(tables, pgx.Identifier(strings.Split(table, ".")).Sanitize())
+	}
+	sql := fmt.Sprintf("CREATE PUBLICATION %s FOR TABLE %s", pgx.Identifier{cfg.Publication}.Sanitize(), strings.Join(tables, ", "))
+	_, err = conn.Exec(ctx, sql).ReadAll()
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func textTuple(values ...string) *pglogrepl.TupleData {
+	tuple := &pglogrepl.TupleData{ColumnNum: uint16(len(values))}
+	for _, v := range values {
+		tuple.Columns = append(tuple.Columns, &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Data: []byte(v)})
+	}
+	return tuple
+}
+
+func TestCDC_UpdateWithSessionActor(t *testing.T) {
+	decoder := newCDCDecoder(cdcConfig{ActorPrefix: "audit.actor", ActorColumns: []string{"updated_by"}, DefaultActor: "postgres", Tenant: "shop"})
+	commitTime := time.Date(2025, 12, 20, 10, 0, 0, 0, time.UTC)
+
+	msgs := []pglogrepl.Message{
+		&pglogrepl.RelationMessage{RelationID: 1, Namespace: "public", RelationName: "orders", Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: pgtype.Int8OID},
+			{Name: "status", DataType: pgtype.TextOID},
+			{Name: "paid", DataType: pgtype.BoolOID},
+			{Name: "updated_by", DataType: pgtype.TextOID},
+		}},
+		&pglogrepl.BeginMessage{Xid: 77, CommitTime: commitTime},
+		&pglogrepl.LogicalDecodingMessage{Transactional: true, Prefix: "audit.actor", Content: []byte("alice")},
+		&pglogrepl.UpdateMessage{RelationID: 1, OldTupleType: 'O', OldTuple: textTuple("42", "pending", "f", "bob"), NewTuple: textTuple("42", "paid", "t", "bob")},
+	}
+	for _, msg := range msgs {
+		if events, err := decoder.handle(msg, 100); err != nil || events != nil {
+			t.Fatalf("Expected no events before commit, got %v (%v)", events, err)
+		}
+	}
+
+	events, err := decoder.handle(&pglogrepl.CommitMessage{CommitTime: commitTime}, 200)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected 1 event on commit, got %d (%v)", len(events), err)
+	}
+	event := events[0]
+	if err := validateEvent(event); err != nil {
+		t.Fatalf("Expected valid event, got %v", err)
+	}
+	if event.Action["name"] != "resource.updated" || event.Actor["id"] != "alice" || event.Resource["type"] != "orders" || event.Resource["id"] != "42" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	changed, _ := event.Context["changed_columns"].([]string)
+	if len(changed) != 2 || changed[0] != "status" || changed[1] != "paid" {
+		t.Errorf("Unexpected changed columns: %v", event.Context["changed_columns"])
+	}
+	if after, _ := event.Context["after"].(map[string]interface{}); after["paid"] != true || after["id"] != int64(42) {
+		t.Errorf("Unexpected after image: %v", event.Context["after"])
+	}
+	if event.Timestamp != "2025-12-20T10:00:00Z" || event.TenantID != "shop" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestCDC_ActorFromColumnAndDefault(t *testing.T) {
+	decoder := newCDCDecoder(cdcConfig{ActorColumns: []string{"created_by"}, DefaultActor: "postgres"})
+	decoder.handle(&pglogrepl.RelationMessage{RelationID: 2, RelationName: "users", Columns: []*pglogrepl.RelationMessageColumn{
+		{Flags: 1, Name: "id", DataType: pgtype.TextOID},
+		{Name: "created_by", DataType: pgtype.TextOID},
+	}}, 0)
+	decoder.handle(&pglogrepl.BeginMessage{Xid: 1}, 0)
+	if !decoder.inTransaction() {
+		t.Error("Expected a transaction in flight after Begin")
+	}
+	decoder.handle(&pglogrepl.InsertMessage{RelationID: 2, Tuple: textTuple("u-1", "carol")}, 0)
+	decoder.handle(&pglogrepl.DeleteMessage{RelationID: 2, OldTupleType: 'K', OldTuple: textTuple("u-2")}, 0)
+	events, _ := decoder.handle(&pglogrepl.CommitMessage{CommitLSN: 0x16B3748}, 0)
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if decoder.inTransaction() {
+		t.Error("Expected no transaction in flight after Commit")
+	}
+	if events[0].IdempotencyKey != "0/16B3748/0" || events[1].IdempotencyKey != "0/16B3748/1" {
+		t.Errorf("Expected keys from the commit LSN, got %q and %q", events[0].IdempotencyKey, events[1].IdempotencyKey)
+	}
+	if events[0].Action["name"] != "resource.created" || events[0].Actor["id"] != "carol" {
+		t.Errorf("Unexpected insert event: %+v", events[0])
+	}
+	if events[1].Action["name"] != "resource.deleted" || events[1].Actor["id"] != "postgres" || events[1].Actor["type"] != "system" || events[1].Resource["id"] != "u-2" {
+		t.Errorf("Unexpected delete event: %+v", events[1])
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// cloudTrailFile is the envelope of a CloudTrail log file
+type cloudTrailFile struct {
+	Records []cloudTrailRecord `json:"Records"`
+}
+
+// cloudTrailRecord is a single CloudTrail event. Only mapped fields are declared.
+type cloudTrailRecord struct {
+	EventVersion string `json:"eventVersion"`
+	UserIdentity struct {
+		Type           string `json:"type"`
+		PrincipalID    string `json:"principalId"`
+		ARN            string `json:"arn"`
+		AccountID      string `json:"accountId"`
+		UserName       string `json:"userName"`
+		InvokedBy      string `json:"invokedBy"`
+		SessionContext *struct {
+			SessionIssuer struct {
+				UserName string `json:"userName"`
+				ARN      string `json:"arn"`
+			} `json:"sessionIssuer"`
+		} `json:"sessionContext,omitempty"`
+	} `json:"userIdentity"`
+	EventTime          string          `json:"eventTime"`
+	EventSource        string          `json:"eventSource"`
+	EventName          string          `json:"eventName"`
+	AWSRegion          string          `json:"awsRegion"`
+	SourceIPAddress    string          `json:"sourceIPAddress"`
+	UserAgent          string          `json:"userAgent"`
+	ErrorCode          string          `json:"errorCode"`
+	ErrorMessage       string          `json:"errorMessage"`
+	RequestParameters  json.RawMessage `json:"requestParameters"`
+	RequestID          string          `json:"requestID"`
+	EventID            string          `json:"eventID"`
+	ReadOnly           *bool           `json:"readOnly,omitempty"`
+	EventType          string          `json:"eventType"`
+	RecipientAccountID string          `json:"recipientAccountId"`
+	Resources          []struct {
+		ARN       string `json:"ARN"`
+		AccountID string `json:"accountId"`
+		Type      string `json:"type"`
+	} `json:"resources"`
+}
+
+// cloudTrailSource lists and opens CloudTrail log files
+type cloudTrailSource interface {
+	// List returns the log file keys under prefix that sort after
+	// startAfter, in key order
+	List(ctx context.Context, prefix, startAfter string) ([]string, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+func isCloudTrailFile(key string) bool {
+	return strings.HasSuffix(key, ".json.gz") || strings.HasSuffix(key, ".json")
+}
+
+// dirSource reads CloudTrail files from a local directory tree
+type dirSource struct {
+	root string
+}
+
+func (s dirSource) List(ctx context.Context, prefix, startAfter string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.root, path)
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			// Only descend into directories on the way to or inside prefix
+			if key != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if isCloudTrailFile(key) && strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (s dirSource) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.root, filepath.FromSlash(key)))
+}
+
+// s3Source reads CloudTrail files from an S3-compatible bucket
+type s3Source struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// List uses StartAfter so only objects newer than the marker are listed
+func (s s3Source) List(ctx context.Context, prefix, startAfter string) ([]string, error) {
+	var keys []string
+	opts := minio.ListObjectsOptions{Prefix: s.prefix + prefix, StartAfter: startAfter, Recursive: true}
+	for obj := range s.client.ListObjects(ctx, s.bucket, opts) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if isCloudTrailFile(obj.Key) {
+			keys = append(keys, obj.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s s3Source) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+}
+
+// checkpointStore persists a checkpoint document. Load returns nil when
+// none was saved yet.
+type checkpointStore interface {
+	Load(ctx context.Context) ([]byte, error)
+	Save(ctx context.Context, data []byte) error
+}
+
+// fileCheckpoint keeps the checkpoint in a file, which must be on a
+// persistent volume to survive restarts
+type fileCheckpoint struct {
+	path string
+}
+
+func (f fileCheckpoint) Load(ctx context.Context) ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save replaces the file atomically
+func (f fileCheckpoint) Save(ctx context.Context, data []byte) error {
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// s3Checkpoint keeps the checkpoint as an object
+type s3Checkpoint struct {
+	client *minio.Client
+	bucket string
+	key    string
+}
+
+func (s s3Checkpoint) Load(ctx context.Context) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s s3Checkpoint) Save(ctx context.Context, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	return err
+}
+
+// cloudTrailCheckpoint holds, per prefix, the last key that was imported.
+// CloudTrail names files by delivery time, so within one account and
+// region prefix everything after the marker is new.
+type cloudTrailCheckpoint struct {
+	store   checkpointStore
+	mu      sync.Mutex
+	Markers map[string]string `json:"markers"`
+}
+
+func loadCloudTrailCheckpoint(ctx context.Context, store checkpointStore) (*cloudTrailCheckpoint, error) {
+	cp := &cloudTrailCheckpoint{store: store, Markers: map[string]string{}}
+	data, err := store.Load(ctx)
+	if err != nil || data == nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	if cp.Markers == nil {
+		cp.Markers = map[string]string{}
+	}
+	return cp, nil
+}
+
+func (cp *cloudTrailCheckpoint) marker(prefix string) string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.Markers[prefix]
+}
+
+// advance moves the marker of prefix to key and persists the checkpoint
+func (cp *cloudTrailCheckpoint) advance(ctx context.Context, prefix, key string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Markers[prefix] = key
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return cp.store.Save(ctx, data)
+}
+
+// cloudTrailImporter imports new CloudTrail files from a source
+type cloudTrailImporter struct {
+	source     cloudTrailSource
+	checkpoint *cloudTrailCheckpoint
+	// prefixes are walked independently, each from its own marker
+	prefixes []string
+	tenant   string
+}
+
+func newCloudTrailS3Client() (*minio.Client, error) {
+	return minio.New(getEnv("CLOUDTRAIL_S3_ENDPOINT", "s3.amazonaws.com"), &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv("CLOUDTRAIL_S3_ACCESS_KEY"), os.Getenv("CLOUDTRAIL_S3_SECRET_KEY"), ""),
+		Secure: getEnv("CLOUDTRAIL_S3_USE_SSL", "true") == "true",
+		Region: os.Getenv("CLOUDTRAIL_S3_REGION"),
+	})
+}
+
+// newCloudTrailSource builds a source from CLOUDTRAIL_SOURCE, which is either
+// s3://bucket/prefix or a local directory
+func newCloudTrailSource(uri string) (cloudTrailSource, error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if !ok {
+		return dirSource{root: strings.TrimPrefix(uri, "file://")}, nil
+	}
+
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	client, err := newCloudTrailS3Client()
+	if err != nil {
+		return nil, err
+	}
+	return s3Source{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// newCheckpointStore builds a store from CLOUDTRAIL_CHECKPOINT, which is
+// either s3://bucket/key or a file path on a persistent volume
+func newCheckpointStore(uri string) (checkpointStore, error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if !ok {
+		return fileCheckpoint{path: strings.TrimPrefix(uri, "file://")}, nil
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	client, err := newCloudTrailS3Client()
+	if err != nil {
+		return nil, err
+	}
+	return s3Checkpoint{client: client, bucket: bucket, key: key}, nil
+}
+
+// startCloudTrailImporter runs the importer in the background when
+// CLOUDTRAIL_SOURCE is set. With CLOUDTRAIL_POLL_INTERVAL=0 it runs once.
+// CLOUDTRAIL_PREFIXES lists the trail prefixes below the source, one per
+// account and region (AWSLogs/<account>/CloudTrail/<region>/), so that
+// each sorts by time; by default the whole source is one prefix.
+func startCloudTrailImporter() {
+	uri := os.Getenv("CLOUDTRAIL_SOURCE")
+	if uri == "" {
+		return
+	}
+	source, err := newCloudTrailSource(uri)
+	if err != nil {
+		log.Fatalf("CloudTrail source error: %v", err)
+	}
+	checkpointURI := os.Getenv("CLOUDTRAIL_CHECKPOINT")
+	if checkpointURI == "" {
+		log.Fatal("CLOUDTRAIL_CHECKPOINT is required with CLOUDTRAIL_SOURCE")
+	}
+	store, err := newCheckpointStore(checkpointURI)
+	if err != nil {
+		log.Fatalf("CloudTrail checkpoint error: %v", err)
+	}
+	checkpoint, err := loadCloudTrailCheckpoint(context.Background(), store)
+	if err != nil {
+		log.Fatalf("CloudTrail checkpoint error: %v", err)
+	}
+	interval, err := time.ParseDuration(getEnv("CLOUDTRAIL_POLL_INTERVAL", "5m"))
+	if err != nil {
+		log.Fatalf("Invalid CLOUDTRAIL_POLL_INTERVAL: %v", err)
+	}
+	prefixes := []string{""}
+	if list := os.Getenv("CLOUDTRAIL_PREFIXES"); list != "" {
+		prefixes = strings.Split(list, ",")
+	}
+
+	importer := &cloudTrailImporter{source: source, checkpoint: checkpoint, prefixes: prefixes, tenant: os.Getenv("CLOUDTRAIL_TENANT")}
+	log.Printf("CloudTrail importer started for %s", uri)
+	go func() {
+		for {
+			if err := importer.run(context.Background()); err != nil {
+				log.Printf("CloudTrail import error: %v", err)
+			}
+			if interval <= 0 {
+				return
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// run imports the files after each prefix's marker. A file that fails
+// stops its prefix so the marker never passes it; the next run retries it.
+func (imp *cloudTrailImporter) run(ctx context.Context) error {
+	for _, prefix := range imp.prefixes {
+		keys, err := imp.source.List(ctx, prefix, imp.checkpoint.marker(prefix))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			accepted, rejected, err := imp.importFile(ctx, key)
+			if err != nil {
+				log.Printf("CloudTrail file %s failed: %v", key, err)
+				break
+			}
+			log.Printf("CloudTrail file %s imported: accepted=%d rejected=%d", key, accepted, rejected)
+			if err := imp.checkpoint.advance(ctx, prefix, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importFile decodes one (optionally gzipped) CloudTrail file and delivers
+// its records in batches
+func (imp *cloudTrailImporter) importFile(ctx context.Context, key string) (int, int, error) {
+	rc, err := imp.source.Open(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if strings.HasSuffix(key, ".gz") {
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var file cloudTrailFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return 0, 0, fmt.Errorf("decode: %w", err)
+	}
+
+	accepted, rejected := 0, 0
+	receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	for start := 0; start < len(file.Records); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(file.Records))
+		events := make([]Event, 0, end-start)
+		for _, rec := range file.Records[start:end] {
+			event := cloudTrailToEvent(rec)
+			event.TenantID = imp.tenant
+			event.Producer = "cloudtrail"
+			event.Context["cloudtrail_object"] = key
+			events = append(events, event)
+		}
+		// A retry after a failed batch resends earlier batches too; the
+		// eventID idempotency keys collapse them in storage
+		resp, err := deliverBatch(events, receivedAt)
+		if err != nil {
+			return accepted, rejected, err
+		}
+		accepted += resp.Accepted
+		rejected += resp.Rejected
+	}
+	return accepted, rejected, nil
+}
+
+// cloudTrailToEvent maps userIdentity, eventName, resources and errorCode
+// into actor, action, resource and result, keeping the original eventTime
+func cloudTrailToEvent(rec cloudTrailRecord) Event {
+	service := strings.TrimSuffix(rec.EventSource, ".amazonaws.com")
+	event := Event{
+		Actor:     map[string]interface{}{},
+		Action:    map[string]interface{}{"name": fmt.Sprintf("aws.%s.%s", service, rec.EventName)},
+		Resource:  map[string]interface{}{},
+		Result:    map[string]interface{}{"success": rec.ErrorCode == ""},
+		Timestamp: rec.EventTime,
+		// Reimported files keep their event_ids
+		IdempotencyKey: rec.EventID,
+		Context: map[string]interface{}{
+			"source":       "cloudtrail",
+			"event_source": rec.EventSource,
+			"event_name":   rec.EventName,
+			"aws_region":   rec.AWSRegion,
+		},
+	}
+
+	id := rec.UserIdentity
+	switch {
+	case id.ARN != "":
+		event.Actor["id"] = id.ARN
+	case id.PrincipalID != "":
+		event.Actor["id"] = id.PrincipalID
+	case id.InvokedBy != "":
+		event.Actor["id"] = id.InvokedBy
+	}
+	putString(event.Actor, "type", strings.ToLower(id.Type))
+	putString(event.Context, "user_name", id.UserName)
+	putString(event.Context, "account_id", id.AccountID)
+	if id.SessionContext != nil {
+		putString(event.Context, "session_issuer", id.SessionContext.SessionIssuer.ARN)
+	}
+
+	if len(rec.Resources) > 0 {
+		event.Resource["type"] = rec.Resources[0].Type
+		event.Resource["id"] = rec.Resources[0].ARN
+		event.Context["resources"] = rec.Resources
+	} else if rec.RecipientAccountID != "" {
+		event.Resource["type"] = "aws_account"
+		event.Resource["id"] = rec.RecipientAccountID
+	}
+
+	if rec.ErrorCode != "" {
+		message := rec.ErrorCode
+		if rec.ErrorMessage != "" {
+			message += ": " + rec.ErrorMessage
+		}
+		event.Result["message"] = message
+	}
+
+	putString(event.Context, "ip", rec.SourceIPAddress)
+	putString(event.Context, "user_agent", rec.UserAgent)
+	putString(event.Context, "cloudtrail_event_id", rec.EventID)
+	putString(event.Context, "request_id", rec.RequestID)
+	putString(event.Context, "recipient_account_id", rec.RecipientAccountID)
+	if rec.ReadOnly != nil {
+		event.Context["read_only"] = *rec.ReadOnly
+	}
+	if len(rec.RequestParameters) > 0 && string(rec.RequestParameters) != "null" {
+		event.Context["request_parameters"] = rec.RequestParameters
+	}
+	return event
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const cloudTrailSample = `{"Records":[
+	{"eventVersion":"1.08","userIdentity":{"type":"IAMUser","principalId":"AIDA1","arn":"arn:aws:iam::111122223333:user/alice","accountId":"111122223333","userName":"alice"},
+	 "eventTime":"2025-11-02T08:15:30Z","eventSource":"iam.amazonaws.com","eventName":"CreateAccessKey","awsRegion":"us-east-1",
+	 "sourceIPAddress":"198.51.100.10","userAgent":"aws-cli/2.15","errorCode":"AccessDenied","errorMessage":"not authorized",
+	 "requestParameters":{"userName":"bob"},"eventID":"ev-1","recipientAccountId":"111122223333",
+	 "resources":[{"ARN":"arn:aws:iam::111122223333:user/bob","accountId":"111122223333","type":"AWS::IAM::User"}]},
+	{"userIdentity":{"type":"AWSService","invokedBy":"cloudtrail.amazonaws.com"},"eventTime":"2025-11-02T08:16:00Z",
+	 "eventSource":"s3.amazonaws.com","eventName":"GetBucketAcl","recipientAccountId":"111122223333"}
+]}`
+
+func TestCloudTrail_Mapping(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "AWSLogs", "111122223333", "trail.json.gz")
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	f, _ := os.Create(path)
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(cloudTrailSample))
+	gz.Close()
+	f.Close()
+
+	vector := fakeVector(t)
+	store := fileCheckpoint{path: filepath.Join(dir, "checkpoint.json")}
+	cp, err := loadCloudTrailCheckpoint(context.Background(), store)
+	if err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+	imp := &cloudTrailImporter{source: dirSource{root: dir}, checkpoint: cp, prefixes: []string{"AWSLogs/111122223333/"}, tenant: "aws"}
+
+	keys, _ := imp.source.List(context.Background(), "AWSLogs/", "")
+	if len(keys) != 1 || keys[0] != "AWSLogs/111122223333/trail.json.gz" {
+		t.Fatalf("Unexpected keys: %v", keys)
+	}
+	if keys, _ := imp.source.List(context.Background(), "AWSLogs/", keys[0]); len(keys) != 0 {
+		t.Errorf("Expected nothing after the last key, got %v", keys)
+	}
+
+	// A failed delivery leaves the marker where it was
+	vector.setStatus(503)
+	if err := imp.run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if marker := cp.marker("AWSLogs/111122223333/"); marker != "" {
+		t.Fatalf("Marker advanced past a failed file: %q", marker)
+	}
+
+	vector.setStatus(200)
+	if err := imp.run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if events := vector.received(); len(events) != 2 || events[0].IdempotencyKey != "ev-1" {
+		t.Errorf("Expected 2 events keyed by eventID, got %+v", events)
+	}
+	reloaded, _ := loadCloudTrailCheckpoint(context.Background(), store)
+	if reloaded.marker("AWSLogs/111122223333/") != keys[0] {
+		t.Errorf("Expected the marker to be persisted, got %v", reloaded.Markers)
+	}
+}
+
+func TestCloudTrail_RecordToEvent(t *testing.T) {
+	var file cloudTrailFile
+	if err := json.Unmarshal([]byte(cloudTrailSample), &file); err != nil {
+		t.Fatal(err)
+	}
+	event := cloudTrailToEvent(file.Records[0])
+	if event.Actor["id"] != "arn:aws:iam::111122223333:user/alice" || event.Action["name"] != "aws.iam.CreateAccessKey" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Resource["type"] != "AWS::IAM::User" || event.Result["success"] != false || event.Result["message"] != "AccessDenied: not authorized" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Timestamp != "2025-11-02T08:15:30Z" {
+		t.Errorf("Expected original eventTime, got %s", event.Timestamp)
+	}
+
+	event = cloudTrailToEvent(file.Records[1])
+	if event.Actor["id"] != "cloudtrail.amazonaws.com" || event.Resource["type"] != "aws_account" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
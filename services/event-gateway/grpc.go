@@ -0,0 +1,278 @@
+package main
+
+//go:generate protoc -I proto --go_out=proto --go_opt=paths=source_relative --go-grpc_out=proto --go-grpc_opt=paths=source_relative audit/v1/audit.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	auditv1 "github.com/alfredohmlopes/poc-auditproject/event-gateway/proto/audit/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type grpcTenantKey struct{}
+
+// ingestServer implements audit.v1.IngestService on top of the same
+// validation and enrichment as the HTTP endpoints. Forwarding is
+// synchronous: events are reported accepted only once Vector has them, and
+// a forwarding failure is Unavailable so that clients retry.
+type ingestServer struct {
+	auditv1.UnimplementedIngestServiceServer
+}
+
+// grpcAuth authenticates calls with the x-api-key metadata entry
+type grpcAuth struct {
+	// apiKeys maps an API key to the tenant its events are bound to
+	apiKeys map[string]string
+}
+
+// loadGRPCAuth reads GRPC_API_KEYS, a comma-separated list of key=tenant pairs
+func loadGRPCAuth() grpcAuth {
+	auth := grpcAuth{apiKeys: map[string]string{}}
+	for _, pair := range strings.Split(os.Getenv("GRPC_API_KEYS"), ",") {
+		key, tenant, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if key != "" {
+			auth.apiKeys[key] = strings.TrimSpace(tenant)
+		}
+	}
+	return auth
+}
+
+// authenticate resolves the tenant bound to the caller's API key
+func (a grpcAuth) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get("x-api-key")
+	if len(keys) == 0 || keys[0] == "" {
+		return nil, status.Error(codes.Unauthenticated, "x-api-key metadata is required")
+	}
+	for key, tenant := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(keys[0])) == 1 {
+			return context.WithValue(ctx, grpcTenantKey{}, tenant), nil
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "invalid API key")
+}
+
+func (a grpcAuth) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a grpcAuth) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticatedStream carries the authenticated context into stream handlers
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// Ingest validates and forwards a batch of events
+func (s *ingestServer) Ingest(ctx context.Context, req *auditv1.BatchRequest) (*auditv1.BatchResponse, error) {
+	if len(req.GetEvents()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "No events provided")
+	}
+	if len(req.GetEvents()) > maxBatchSize {
+		return nil, status.Error(codes.InvalidArgument, "Maximum 1000 events per batch")
+	}
+	// Nothing is forwarded once the caller's deadline has passed
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	tenant, _ := ctx.Value(grpcTenantKey{}).(string)
+	events := make([]Event, 0, len(req.GetEvents()))
+	for _, pe := range req.GetEvents() {
+		events = append(events, eventFromProto(pe, tenant))
+	}
+
+	receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	resp, err := deliverGRPC(ctx, events, receivedAt)
+	if err != nil {
+		return nil, err
+	}
+	return batchResponseToProto(resp, receivedAt), nil
+}
+
+// deliverGRPC forwards events synchronously. A failed delivery is
+// Unavailable, and a caller whose deadline passed meanwhile gets the
+// context error: either way it retries, and events with an
+// idempotency_key keep their event_id.
+func deliverGRPC(ctx context.Context, events []Event, receivedAt string) (BatchResponse, error) {
+	resp, err := deliverBatch(events, receivedAt)
+	if err != nil {
+		log.Printf("Forwarding gRPC batch: %v", err)
+		return resp, status.Error(codes.Unavailable, "forwarding to Vector failed")
+	}
+	if err := ctx.Err(); err != nil {
+		return resp, status.FromContextError(err).Err()
+	}
+	return resp, nil
+}
+
+// IngestStream ingests events as they arrive, forwarding them in batches
+// of up to maxBatchSize, and reports the outcome when the client closes
+// its side of the stream. A forwarding failure ends the stream with
+// Unavailable.
+func (s *ingestServer) IngestStream(stream auditv1.IngestService_IngestStreamServer) error {
+	ctx := stream.Context()
+	tenant, _ := ctx.Value(grpcTenantKey{}).(string)
+	receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	resp := BatchResponse{Events: []BatchEventResponse{}}
+	pending := make([]Event, 0, maxBatchSize)
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		result, err := deliverGRPC(ctx, pending, receivedAt)
+		if err != nil {
+			return err
+		}
+		resp.Accepted += result.Accepted
+		resp.Rejected += result.Rejected
+		resp.Events = append(resp.Events, result.Events...)
+		pending = pending[:0]
+		return nil
+	}
+
+	for {
+		pe, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if err := flush(); err != nil {
+				return err
+			}
+			return stream.SendAndClose(batchResponseToProto(resp, receivedAt))
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return status.FromContextError(ctxErr).Err()
+			}
+			return err
+		}
+
+		pending = append(pending, eventFromProto(pe, tenant))
+		if len(pending) == maxBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// eventFromProto converts an audit.v1 Event into the gateway's Event. The
+// tenant bound to the API key, when set, overrides the one in the message.
+func eventFromProto(pe *auditv1.Event, tenant string) Event {
+	event := Event{TenantID: pe.GetTenantId(), IdempotencyKey: pe.GetIdempotencyKey(), Producer: "grpc"}
+	if tenant != "" {
+		event.TenantID = tenant
+	}
+
+	if a := pe.GetActor(); a != nil {
+		event.Actor = map[string]interface{}{}
+		putString(event.Actor, "id", a.GetId())
+		putString(event.Actor, "type", a.GetType())
+		putString(event.Actor, "email", a.GetEmail())
+	}
+	if a := pe.GetAction(); a != nil {
+		event.Action = map[string]interface{}{}
+		putString(event.Action, "name", a.GetName())
+	}
+	if r := pe.GetResource(); r != nil {
+		event.Resource = map[string]interface{}{}
+		putString(event.Resource, "type", r.GetType())
+		putString(event.Resource, "id", r.GetId())
+	}
+	if r := pe.GetResult(); r != nil {
+		event.Result = map[string]interface{}{}
+		if r.Success != nil {
+			event.Result["success"] = r.GetSuccess()
+		}
+		putString(event.Result, "message", r.GetMessage())
+	}
+	if pe.GetTimestamp() != nil {
+		event.Timestamp = pe.GetTimestamp().AsTime().UTC().Format(time.RFC3339Nano)
+	}
+	if pe.GetContext() != nil {
+		event.Context = pe.GetContext().AsMap()
+	}
+	return event
+}
+
+// putString sets dst[key] only for non-empty values so that missing proto
+// fields fail validation the same way missing JSON fields do
+func putString(dst map[string]interface{}, key, val string) {
+	if val != "" {
+		dst[key] = val
+	}
+}
+
+func batchResponseToProto(resp BatchResponse, receivedAt string) *auditv1.BatchResponse {
+	out := &auditv1.BatchResponse{
+		Accepted:   int32(resp.Accepted),
+		Rejected:   int32(resp.Rejected),
+		ReceivedAt: receivedAt,
+		Events:     make([]*auditv1.BatchEventResponse, 0, len(resp.Events)),
+	}
+	for _, e := range resp.Events {
+		out.Events = append(out.Events, &auditv1.BatchEventResponse{
+			EventId: e.EventID,
+			Status:  e.Status,
+			Error:   e.Error,
+		})
+	}
+	return out
+}
+
+// startGRPCServer serves the gRPC ingestion API on GRPC_PORT
+func startGRPCServer() {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = "50051"
+	}
+
+	auth := loadGRPCAuth()
+	if len(auth.apiKeys) == 0 {
+		log.Printf("Warning: GRPC_API_KEYS is empty, all gRPC calls will be rejected")
+	}
+
+	ln, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("gRPC listener failed: %v", err)
+	}
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.unaryInterceptor),
+		grpc.StreamInterceptor(auth.streamInterceptor),
+	)
+	auditv1.RegisterIngestServiceServer(server, &ingestServer{})
+
+	log.Printf("gRPC ingestion API starting on port %s", port)
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+}
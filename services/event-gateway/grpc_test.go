@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	auditv1 "github.com/alfredohmlopes/poc-auditproject/event-gateway/proto/audit/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestIngestClient(t *testing.T) auditv1.IngestServiceClient {
+	ln := bufconn.Listen(1 << 20)
+	auth := grpcAuth{apiKeys: map[string]string{"grpc-test-key": "acme"}}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.unaryInterceptor),
+		grpc.StreamInterceptor(auth.streamInterceptor),
+	)
+	auditv1.RegisterIngestServiceServer(server, &ingestServer{})
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return auditv1.NewIngestServiceClient(conn)
+}
+
+func validProtoEvent(action string) *auditv1.Event {
+	return &auditv1.Event{
+		Actor:    &auditv1.Actor{Id: "u1"},
+		Action:   &auditv1.Action{Name: action},
+		Resource: &auditv1.Resource{Type: "t", Id: "1"},
+	}
+}
+
+func TestGRPC_IngestRequiresAPIKey(t *testing.T) {
+	client := newTestIngestClient(t)
+	_, err := client.Ingest(context.Background(), &auditv1.BatchRequest{Events: []*auditv1.Event{validProtoEvent("grpc.1")}})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestGRPC_IngestPartialSuccess(t *testing.T) {
+	fakeVector(t)
+	client := newTestIngestClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "grpc-test-key")
+
+	resp, err := client.Ingest(ctx, &auditv1.BatchRequest{Events: []*auditv1.Event{
+		validProtoEvent("grpc.valid"),
+		{Actor: &auditv1.Actor{Id: "u2"}},
+	}})
+	if err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	if resp.Accepted != 1 || resp.Rejected != 1 {
+		t.Errorf("Expected 1 accepted and 1 rejected, got %d/%d", resp.Accepted, resp.Rejected)
+	}
+	if resp.Events[1].Error != "action.name is required" {
+		t.Errorf("Unexpected rejection reason: %q", resp.Events[1].Error)
+	}
+}
+
+func TestGRPC_IngestStream(t *testing.T) {
+	vector := fakeVector(t)
+	client := newTestIngestClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "grpc-test-key")
+
+	stream, err := client.IngestStream(ctx)
+	if err != nil {
+		t.Fatalf("IngestStream failed: %v", err)
+	}
+	for _, action := range []string{"stream.1", "stream.2", "stream.3"} {
+		if err := stream.Send(validProtoEvent(action)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv failed: %v", err)
+	}
+	if resp.Accepted != 3 || len(resp.Events) != 3 || len(vector.received()) != 3 {
+		t.Errorf("Expected 3 accepted and delivered, got %d and %d", resp.Accepted, len(vector.received()))
+	}
+}
+
+func TestGRPC_ForwardingFailureIsUnavailable(t *testing.T) {
+	vector := fakeVector(t)
+	vector.setStatus(503)
+	client := newTestIngestClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "grpc-test-key")
+
+	_, err := client.Ingest(ctx, &auditv1.BatchRequest{Events: []*auditv1.Event{validProtoEvent("grpc.down")}})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Ingest: expected Unavailable, got %v", err)
+	}
+
+	stream, err := client.IngestStream(ctx)
+	if err != nil {
+		t.Fatalf("IngestStream failed: %v", err)
+	}
+	if err := stream.Send(validProtoEvent("grpc.down")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("IngestStream: expected Unavailable, got %v", err)
+	}
+}
+
+func TestGRPC_EventFromProtoBindsTenant(t *testing.T) {
+	pe := validProtoEvent("grpc.tenant")
+	pe.TenantId = "other"
+	if got := eventFromProto(pe, "acme").TenantID; got != "acme" {
+		t.Errorf("Expected key tenant to win, got %s", got)
+	}
+	if got := eventFromProto(pe, "").TenantID; got != "other" {
+		t.Errorf("Expected message tenant, got %s", got)
+	}
+
+	pe.IdempotencyKey = "retry-1"
+	if a, b := eventFromProto(pe, "acme"), eventFromProto(pe, "acme"); enrichEvent(a, "").EventID != enrichEvent(b, "").EventID {
+		t.Error("Expected retries with the same idempotency_key to keep their event_id")
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// keycloakEvent covers both Keycloak user events and admin events as sent
+// by HTTP event-listener providers. Admin events carry operationType.
+type keycloakEvent struct {
+	ID            string                 `json:"id"`
+	Time          int64                  `json:"time"`
+	Type          string                 `json:"type"`
+	RealmID       string                 `json:"realmId"`
+	ClientID      string                 `json:"clientId"`
+	UserID        string                 `json:"userId"`
+	SessionID     string                 `json:"sessionId"`
+	IPAddress     string                 `json:"ipAddress"`
+	Error         string                 `json:"error"`
+	Details       map[string]interface{} `json:"details"`
+	OperationType string                 `json:"operationType"`
+	ResourceType  string                 `json:"resourceType"`
+	ResourcePath  string                 `json:"resourcePath"`
+	AuthDetails   *struct {
+		RealmID   string `json:"realmId"`
+		ClientID  string `json:"clientId"`
+		UserID    string `json:"userId"`
+		IPAddress string `json:"ipAddress"`
+	} `json:"authDetails"`
+}
+
+// oktaLogEvent is an Okta System Log event (LogEvent object)
+type oktaLogEvent struct {
+	UUID           string `json:"uuid"`
+	Published      string `json:"published"`
+	EventType      string `json:"eventType"`
+	DisplayMessage string `json:"displayMessage"`
+	Severity       string `json:"severity"`
+	Actor          struct {
+		ID          string `json:"id"`
+		Type        string `json:"type"`
+		AlternateID string `json:"alternateId"`
+		DisplayName string `json:"displayName"`
+	} `json:"actor"`
+	Client struct {
+		IPAddress string `json:"ipAddress"`
+		UserAgent struct {
+			RawUserAgent string `json:"rawUserAgent"`
+		} `json:"userAgent"`
+	} `json:"client"`
+	Outcome struct {
+		Result string `json:"result"`
+		Reason string `json:"reason"`
+	} `json:"outcome"`
+	Target []struct {
+		ID          string `json:"id"`
+		Type        string `json:"type"`
+		AlternateID string `json:"alternateId"`
+		DisplayName string `json:"displayName"`
+	} `json:"target"`
+	Transaction struct {
+		ID string `json:"id"`
+	} `json:"transaction"`
+	AuthenticationContext map[string]interface{} `json:"authenticationContext"`
+}
+
+// keycloakActions maps Keycloak user event types (without the _ERROR suffix)
+// to auth.* actions. Unlisted types become auth.<type in lower case>.
+var keycloakActions = map[string]string{
+	"LOGIN":                   "auth.login",
+	"LOGOUT":                  "auth.logout",
+	"REGISTER":                "auth.register",
+	"CODE_TO_TOKEN":           "auth.token.exchange",
+	"REFRESH_TOKEN":           "auth.token.refresh",
+	"UPDATE_PASSWORD":         "auth.password.update",
+	"RESET_PASSWORD":          "auth.password.reset",
+	"UPDATE_TOTP":             "auth.mfa.enroll",
+	"REMOVE_TOTP":             "auth.mfa.remove",
+	"IMPERSONATE":             "auth.impersonate",
+	"IDENTITY_PROVIDER_LOGIN": "auth.sso",
+}
+
+// oktaActions maps Okta event types to auth.* actions. Unlisted types
+// become auth.<eventType>.
+var oktaActions = map[string]string{
+	"user.session.start":                 "auth.login",
+	"user.session.end":                   "auth.logout",
+	"user.authentication.sso":            "auth.sso",
+	"user.authentication.auth_via_mfa":   "auth.mfa.verify",
+	"user.mfa.factor.activate":           "auth.mfa.enroll",
+	"user.mfa.factor.deactivate":         "auth.mfa.remove",
+	"user.account.lock":                  "auth.account.lock",
+	"user.account.unlock":                "auth.account.unlock",
+	"user.account.update_password":       "auth.password.update",
+	"user.account.reset_password":        "auth.password.reset",
+	"user.authentication.authenticate":   "auth.authenticate",
+	"policy.evaluate_sign_on":            "auth.policy.evaluate",
+	"app.oauth2.as.token.grant":          "auth.token.grant",
+	"app.oauth2.as.token.revoke":         "auth.token.revoke",
+	"system.api_token.create":            "auth.api_token.create",
+	"system.api_token.revoke":            "auth.api_token.revoke",
+	"user.lifecycle.create":              "auth.user.create",
+	"user.lifecycle.deactivate":          "auth.user.deactivate",
+	"group.user_membership.add":          "auth.group.member.add",
+	"group.user_membership.remove":       "auth.group.member.remove",
+	"application.user_membership.add":    "auth.app.assign",
+	"application.user_membership.remove": "auth.app.unassign",
+}
+
+var oktaNextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// idpTenantConfig holds one tenant's shared secrets. Keycloak event
+// listeners send "Authorization: Bearer <keycloak_secret>"; Okta Event
+// Hooks send okta_secret as the configured Authorization header value.
+type idpTenantConfig struct {
+	KeycloakSecret string `json:"keycloak_secret"`
+	OktaSecret     string `json:"okta_secret"`
+}
+
+// idpWebhookConfig is loaded from the JSON file named by IDP_WEBHOOK_CONFIG
+type idpWebhookConfig struct {
+	Tenants map[string]idpTenantConfig `json:"tenants"`
+}
+
+var idpWebhooks idpWebhookConfig
+
+func init() {
+	path := os.Getenv("IDP_WEBHOOK_CONFIG")
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading IDP_WEBHOOK_CONFIG: %v", err)
+	}
+	if err := json.Unmarshal(data, &idpWebhooks); err != nil {
+		log.Fatalf("Error parsing IDP_WEBHOOK_CONFIG: %v", err)
+	}
+}
+
+// idpTenant authenticates an IdP delivery for the tenant in the path. It
+// writes the error response and returns false when the request is refused.
+func idpTenant(c *fiber.Ctx, secret func(idpTenantConfig) string, presented string) bool {
+	cfg, ok := idpWebhooks.Tenants[c.Params("tenant")]
+	if !ok {
+		c.Status(404).JSON(fiber.Map{"error": "Unknown tenant"})
+		return false
+	}
+	if !tokenMatches(presented, secret(cfg)) {
+		c.Status(401).JSON(fiber.Map{"error": "Invalid Authorization header"})
+		return false
+	}
+	return true
+}
+
+func keycloakSecret(cfg idpTenantConfig) string { return cfg.KeycloakSecret }
+func oktaSecret(cfg idpTenantConfig) string     { return cfg.OktaSecret }
+
+// keycloakHandler accepts one Keycloak event or an array of them
+func keycloakHandler(c *fiber.Ctx) error {
+	if !idpTenant(c, keycloakSecret, bearerToken(c)) {
+		return nil
+	}
+	var events []keycloakEvent
+	if err := json.Unmarshal(c.Body(), &events); err != nil {
+		var single keycloakEvent
+		if err := json.Unmarshal(c.Body(), &single); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON: expected Keycloak event or array"})
+		}
+		events = []keycloakEvent{single}
+	}
+
+	batch := make([]Event, 0, len(events))
+	for _, kc := range events {
+		event := keycloakToEvent(kc)
+		event.TenantID = c.Params("tenant")
+		event.Producer = producer(c, "keycloak")
+		batch = append(batch, event)
+	}
+	// Forwarded synchronously so that a failed delivery is a 502 and
+	// Keycloak redelivers it
+	resp, err := deliverBatch(batch, time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		log.Printf("Forwarding Keycloak events: %v", err)
+		return c.Status(502).JSON(fiber.Map{"error": "forwarding to Vector failed"})
+	}
+	return c.Status(202).JSON(resp)
+}
+
+// keycloakToEvent maps a Keycloak user or admin event to an auth.* event
+func keycloakToEvent(kc keycloakEvent) Event {
+	event := Event{
+		Actor:    map[string]interface{}{"type": "user"},
+		Action:   map[string]interface{}{},
+		Resource: map[string]interface{}{},
+		Result:   map[string]interface{}{"success": kc.Error == ""},
+		Context: map[string]interface{}{
+			"source":   "keycloak",
+			"realm_id": kc.RealmID,
+		},
+	}
+	if kc.Time > 0 {
+		event.Timestamp = time.UnixMilli(kc.Time).UTC().Format(time.RFC3339Nano)
+	}
+	putString(event.Result, "message", kc.Error)
+	putString(event.Context, "keycloak_event_id", kc.ID)
+	// Redelivered events keep their event_id
+	event.IdempotencyKey = kc.ID
+
+	if kc.OperationType != "" {
+		// Admin event: an administrator changed realm configuration
+		resourceType := strings.ToLower(kc.ResourceType)
+		event.Action["name"] = fmt.Sprintf("auth.admin.%s.%s", resourceType, strings.ToLower(kc.OperationType))
+		event.Resource["type"] = resourceType
+		putString(event.Resource, "id", kc.ResourcePath)
+		if auth := kc.AuthDetails; auth != nil {
+			putString(event.Actor, "id", auth.UserID)
+			putString(event.Context, "ip", auth.IPAddress)
+			putString(event.Context, "client_id", auth.ClientID)
+		}
+		return event
+	}
+
+	eventType := strings.TrimSuffix(kc.Type, "_ERROR")
+	if eventType != kc.Type {
+		event.Result["success"] = false
+	}
+	action, ok := keycloakActions[eventType]
+	if !ok {
+		action = "auth." + strings.ToLower(eventType)
+	}
+	event.Action["name"] = action
+
+	username, _ := kc.Details["username"].(string)
+	if username != "" {
+		event.Actor["id"] = username
+	} else {
+		putString(event.Actor, "id", kc.UserID)
+	}
+	if email, _ := kc.Details["email"].(string); email != "" {
+		event.Actor["email"] = email
+	}
+
+	if kc.ClientID != "" {
+		event.Resource["type"] = "client"
+		event.Resource["id"] = kc.ClientID
+	} else {
+		event.Resource["type"] = "realm"
+		putString(event.Resource, "id", kc.RealmID)
+	}
+
+	putString(event.Context, "ip", kc.IPAddress)
+	putString(event.Context, "user_id", kc.UserID)
+	putString(event.Context, "session_id", kc.SessionID)
+	if len(kc.Details) > 0 {
+		event.Context["details"] = kc.Details
+	}
+	return event
+}
+
+// oktaHandler accepts System Log events as a JSON array (the /api/v1/logs
+// format) or wrapped in an Okta Event Hook delivery
+func oktaHandler(c *fiber.Ctx) error {
+	if !idpTenant(c, oktaSecret, c.Get(fiber.HeaderAuthorization)) {
+		return nil
+	}
+	var events []oktaLogEvent
+	if err := json.Unmarshal(c.Body(), &events); err != nil {
+		var hook struct {
+			Data struct {
+				Events []oktaLogEvent `json:"events"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(c.Body(), &hook); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON: expected Okta System Log events"})
+		}
+		events = hook.Data.Events
+	}
+	resp, err := ingestOktaEvents(events, c.Params("tenant"))
+	if err != nil {
+		log.Printf("Forwarding Okta events: %v", err)
+		return c.Status(502).JSON(fiber.Map{"error": "forwarding to Vector failed"})
+	}
+	return c.Status(202).JSON(resp)
+}
+
+// oktaVerifyHandler answers the one-time Okta Event Hook verification request
+func oktaVerifyHandler(c *fiber.Ctx) error {
+	if !idpTenant(c, oktaSecret, c.Get(fiber.HeaderAuthorization)) {
+		return nil
+	}
+	return c.JSON(fiber.Map{"verification": c.Get("X-Okta-Verification-Challenge")})
+}
+
+// ingestOktaEvents forwards pushed or polled events synchronously, so an
+// error means Vector may not have them and the delivery must be retried
+func ingestOktaEvents(events []oktaLogEvent, tenant string) (BatchResponse, error) {
+	batch := make([]Event, 0, len(events))
+	for _, le := range events {
+		event := oktaToEvent(le)
+		event.TenantID = tenant
+		event.Producer = "okta"
+		batch = append(batch, event)
+	}
+	return deliverBatch(batch, time.Now().UTC().Format(time.RFC3339Nano))
+}
+
+// oktaToEvent maps an Okta System Log event to an auth.* event
+func oktaToEvent(le oktaLogEvent) Event {
+	action, ok := oktaActions[le.EventType]
+	if !ok {
+		action = "auth." + le.EventType
+	}
+
+	result := strings.ToUpper(le.Outcome.Result)
+	event := Event{
+		Actor:    map[string]interface{}{},
+		Action:   map[string]interface{}{"name": action},
+		Resource: map[string]interface{}{"type": "okta", "id": "org"},
+		Result:   map[string]interface{}{"success": result == "SUCCESS" || result == "ALLOW"},
+		Context: map[string]interface{}{
+			"source":          "okta",
+			"okta_event_type": le.EventType,
+			"okta_uuid":       le.UUID,
+			"outcome":         le.Outcome.Result,
+		},
+		Timestamp: le.Published,
+		// Pushed and polled copies of an event resolve to one event_id
+		IdempotencyKey: le.UUID,
+	}
+
+	message := le.Outcome.Reason
+	if message == "" && result != "SUCCESS" && result != "ALLOW" {
+		message = le.DisplayMessage
+	}
+	putString(event.Result, "message", message)
+
+	if le.Actor.AlternateID != "" && le.Actor.AlternateID != "unknown" {
+		event.Actor["id"] = le.Actor.AlternateID
+	} else {
+		putString(event.Actor, "id", le.Actor.ID)
+	}
+	putString(event.Actor, "type", strings.ToLower(le.Actor.Type))
+	if strings.Contains(le.Actor.AlternateID, "@") {
+		event.Actor["email"] = le.Actor.AlternateID
+	}
+
+	if len(le.Target) > 0 {
+		target := le.Target[0]
+		event.Resource["type"] = strings.ToLower(target.Type)
+		if target.AlternateID != "" && target.AlternateID != "unknown" {
+			event.Resource["id"] = target.AlternateID
+		} else {
+			event.Resource["id"] = target.ID
+		}
+	}
+
+	putString(event.Context, "ip", le.Client.IPAddress)
+	putString(event.Context, "user_agent", le.Client.UserAgent.RawUserAgent)
+	putString(event.Context, "transaction_id", le.Transaction.ID)
+	putString(event.Context, "display_message", le.DisplayMessage)
+	if len(le.AuthenticationContext) > 0 {
+		event.Context["authentication_context"] = le.AuthenticationContext
+	}
+	return event
+}
+
+// startOktaPoller polls the Okta System Log API when OKTA_POLL_URL is set.
+// The next link is checkpointed to OKTA_POLL_CHECKPOINT so a restart
+// resumes where the previous process stopped.
+func startOktaPoller() {
+	pollURL := os.Getenv("OKTA_POLL_URL")
+	if pollURL == "" {
+		return
+	}
+	interval, err := time.ParseDuration(getEnv("OKTA_POLL_INTERVAL", "60s"))
+	if err != nil {
+		log.Fatalf("Invalid OKTA_POLL_INTERVAL: %v", err)
+	}
+	token := os.Getenv("OKTA_API_TOKEN")
+	tenant := os.Getenv("OKTA_POLL_TENANT")
+	checkpoint := os.Getenv("OKTA_POLL_CHECKPOINT")
+
+	next := pollURL
+	if checkpoint != "" {
+		if data, err := os.ReadFile(checkpoint); err == nil && len(data) > 0 {
+			next = strings.TrimSpace(string(data))
+		}
+	}
+	if !strings.Contains(next, "since=") && !strings.Contains(next, "after=") {
+		sep := "?"
+		if strings.Contains(next, "?") {
+			sep = "&"
+		}
+		next += sep + "sortOrder=ASCENDING&since=" + time.Now().UTC().Add(-interval).Format(time.RFC3339)
+	}
+
+	log.Printf("Okta System Log poller started (interval %s)", interval)
+	go func() {
+		client := &http.Client{Timeout: 30 * time.Second}
+		for {
+			var err error
+			next, err = pollOktaOnce(client, next, token, tenant)
+			if err != nil {
+				log.Printf("Okta poll error: %v", err)
+			} else if checkpoint != "" {
+				if err := os.WriteFile(checkpoint, []byte(next), 0o600); err != nil {
+					log.Printf("Error writing Okta checkpoint: %v", err)
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// pollOktaOnce drains all pages available at url and returns the link to
+// poll next. On error, including a failed delivery to Vector, the url of
+// the failing page is returned so nothing is skipped.
+func pollOktaOnce(client *http.Client, url, token, tenant string) (string, error) {
+	for {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return url, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "SSWS "+token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return url, err
+		}
+		var events []oktaLogEvent
+		err = json.NewDecoder(resp.Body).Decode(&events)
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return url, fmt.Errorf("okta returned %d", resp.StatusCode)
+		}
+		if err != nil {
+			return url, err
+		}
+
+		if len(events) > 0 {
+			// The page is not skipped until Vector has it: url is
+			// returned unchanged so the checkpoint stays behind it
+			result, err := ingestOktaEvents(events, tenant)
+			if err != nil {
+				return url, err
+			}
+			if result.Rejected > 0 {
+				log.Printf("Okta poll: %d events rejected", result.Rejected)
+			}
+		}
+
+		m := oktaNextLink.FindStringSubmatch(strings.Join(resp.Header.Values("Link"), ","))
+		if m == nil {
+			// Without a next link, resume from the newest event seen. since
+			// is inclusive; the uuid idempotency key absorbs the overlap.
+			return oktaResumeURL(url, events), nil
+		}
+		// An empty page means we are caught up; its next link is polled later
+		if len(events) == 0 || m[1] == url {
+			return m[1], nil
+		}
+		url = m[1]
+	}
+}
+
+// oktaResumeURL is url polling from the latest published time of events,
+// or url itself when there are none
+func oktaResumeURL(pageURL string, events []oktaLogEvent) string {
+	var latest time.Time
+	for _, le := range events {
+		if t, err := time.Parse(time.RFC3339Nano, le.Published); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	u, err := url.Parse(pageURL)
+	if latest.IsZero() || err != nil {
+		return pageURL
+	}
+	q := u.Query()
+	q.Del("after")
+	q.Set("sortOrder", "ASCENDING")
+	q.Set("since", latest.UTC().Format("2006-01-02T15:04:05.000Z"))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
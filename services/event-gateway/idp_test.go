@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIdP_KeycloakLoginError(t *testing.T) {
+	var kc keycloakEvent
+	json.Unmarshal([]byte(`{"id":"e1","time":1766224800000,"type":"LOGIN_ERROR","realmId":"acme","clientId":"portal",
+		"ipAddress":"198.51.100.4","error":"invalid_user_credentials","details":{"username":"alice"}}`), &kc)
+
+	event := keycloakToEvent(kc)
+	if err := validateEvent(event); err != nil {
+		t.Fatalf("Expected valid event, got %v", err)
+	}
+	if event.Action["name"] != "auth.login" || event.Result["success"] != false || event.Result["message"] != "invalid_user_credentials" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Actor["id"] != "alice" || event.Resource["id"] != "portal" || event.Timestamp != "2025-12-20T10:00:00Z" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestIdP_KeycloakAdminEvent(t *testing.T) {
+	var kc keycloakEvent
+	json.Unmarshal([]byte(`{"time":1766224800000,"realmId":"acme","operationType":"UPDATE","resourceType":"REALM_ROLE_MAPPING",
+		"resourcePath":"users/42/role-mappings/realm","authDetails":{"userId":"admin-1","ipAddress":"10.0.0.2"}}`), &kc)
+
+	event := keycloakToEvent(kc)
+	if event.Action["name"] != "auth.admin.realm_role_mapping.update" || event.Actor["id"] != "admin-1" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestIdP_OktaMapping(t *testing.T) {
+	var le oktaLogEvent
+	json.Unmarshal([]byte(`{"uuid":"u1","published":"2025-12-20T10:00:00.000Z","eventType":"user.session.start",
+		"displayMessage":"User login to Okta","actor":{"id":"00u1","type":"User","alternateId":"bob@example.com"},
+		"client":{"ipAddress":"203.0.113.5","userAgent":{"rawUserAgent":"Mozilla/5.0"}},
+		"outcome":{"result":"FAILURE","reason":"INVALID_CREDENTIALS"},
+		"target":[{"id":"0oa1","type":"AppInstance","alternateId":"Portal"}]}`), &le)
+
+	event := oktaToEvent(le)
+	if err := validateEvent(event); err != nil {
+		t.Fatalf("Expected valid event, got %v", err)
+	}
+	if event.Action["name"] != "auth.login" || event.Result["success"] != false || event.Result["message"] != "INVALID_CREDENTIALS" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Actor["email"] != "bob@example.com" || event.Resource["type"] != "appinstance" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestIdP_OktaPollerFollowsNextLink(t *testing.T) {
+	vector := fakeVector(t)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "SSWS token" {
+			w.WriteHeader(401)
+			return
+		}
+		if r.URL.Query().Get("after") == "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s/api/v1/logs>; rel="self"`, server.URL))
+			w.Header().Add("Link", fmt.Sprintf(`<%s/api/v1/logs?after=1>; rel="next"`, server.URL))
+			fmt.Fprint(w, `[{"uuid":"u1","eventType":"user.session.end","actor":{"alternateId":"bob"},"outcome":{"result":"SUCCESS"}}]`)
+			return
+		}
+		w.Header().Add("Link", fmt.Sprintf(`<%s/api/v1/logs?after=1>; rel="next"`, server.URL))
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	start := server.URL + "/api/v1/logs"
+	vector.setStatus(503)
+	if next, err := pollOktaOnce(server.Client(), start, "token", "acme"); err == nil || next != start {
+		t.Fatalf("Vector down: got %s, %v; want the same url and an error", next, err)
+	}
+
+	vector.setStatus(200)
+	next, err := pollOktaOnce(server.Client(), start, "token", "acme")
+	if err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	if next != server.URL+"/api/v1/logs?after=1" {
+		t.Errorf("Expected next link to be kept, got %s", next)
+	}
+	if len(vector.received()) != 1 {
+		t.Errorf("Expected the page to be delivered once, got %d events", len(vector.received()))
+	}
+}
+
+func TestIdP_OktaPollerResumesFromLastPublished(t *testing.T) {
+	fakeVector(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"uuid":"u1","published":"2025-12-20T10:00:00.000Z","eventType":"user.session.end","actor":{"alternateId":"bob"},"outcome":{"result":"SUCCESS"}},
+			{"uuid":"u2","published":"2025-12-20T10:05:00.123Z","eventType":"user.session.end","actor":{"alternateId":"bob"},"outcome":{"result":"SUCCESS"}}]`)
+	}))
+	defer server.Close()
+
+	start := server.URL + "/api/v1/logs?after=abc&limit=100"
+	next, err := pollOktaOnce(server.Client(), start, "", "acme")
+	if err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	want := server.URL + "/api/v1/logs?limit=100&since=2025-12-20T10%3A05%3A00.123Z&sortOrder=ASCENDING"
+	if next != want {
+		t.Errorf("next = %s, want %s", next, want)
+	}
+	if a, b := oktaToEvent(oktaLogEvent{UUID: "u1"}), oktaToEvent(oktaLogEvent{UUID: "u1"}); a.IdempotencyKey != "u1" || enrichEvent(a, "").EventID != enrichEvent(b, "").EventID {
+		t.Error("Expected the Okta uuid as idempotency key")
+	}
+}
+
+func TestIdP_RequiresTenantSecret(t *testing.T) {
+	saved := idpWebhooks
+	idpWebhooks = idpWebhookConfig{Tenants: map[string]idpTenantConfig{"acme": {KeycloakSecret: "kc", OktaSecret: "okta-secret"}}}
+	defer func() { idpWebhooks = saved }()
+
+	app := fiber.New()
+	app.Post("/v1/idp/keycloak/:tenant", keycloakHandler)
+	app.Post("/v1/idp/okta/:tenant", oktaHandler)
+	app.Get("/v1/idp/okta/:tenant", oktaVerifyHandler)
+
+	tests := []struct {
+		method, path, auth string
+		want               int
+	}{
+		{"POST", "/v1/idp/keycloak/acme", "", 401},
+		{"POST", "/v1/idp/keycloak/acme", "Bearer okta-secret", 401},
+		{"POST", "/v1/idp/keycloak/other", "Bearer kc", 404},
+		{"POST", "/v1/idp/keycloak/acme", "Bearer kc", 202},
+		{"POST", "/v1/idp/okta/acme", "wrong", 401},
+		{"POST", "/v1/idp/okta/acme", "okta-secret", 202},
+		{"GET", "/v1/idp/okta/acme", "", 401},
+		{"GET", "/v1/idp/okta/acme", "okta-secret", 200},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`[]`))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s with %q: status %d, want %d", tt.method, tt.path, tt.auth, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// importChunkSize is how many events are posted to Vector per request
+	importChunkSize = 500
+	// maxImportErrors caps the per-line errors kept in a job report
+	maxImportErrors = 100
+)
+
+// importNamespace scopes the UUIDs derived from non-UUID source event IDs
+var importNamespace = uuid.MustParse("0b6c2a8e-4f1d-5e7a-8c3b-9d2f6a1e4c70")
+
+// importToken is the bearer token import requests must present. Imports
+// choose event_ids and so can replace stored events; the X-Consumer-Name
+// header cannot authorize that, since anything that reaches the gateway
+// Service directly can set it. Import is disabled when it is empty.
+var importToken = os.Getenv("IMPORT_TOKEN")
+
+// ImportError describes one record that could not be imported
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportJob is the status report of one import upload
+type ImportJob struct {
+	JobID         string        `json:"job_id"`
+	ImportBatchID string        `json:"import_batch_id"`
+	Format        string        `json:"format"`
+	Status        string        `json:"status"`
+	Total         int           `json:"total"`
+	Imported      int           `json:"imported"`
+	Rejected      int           `json:"rejected"`
+	Failed        int           `json:"failed"`
+	Errors        []ImportError `json:"errors"`
+	// ErrorsTruncated is set when more errors occurred than are listed
+	ErrorsTruncated bool   `json:"errors_truncated,omitempty"`
+	StartedAt       string `json:"started_at"`
+	FinishedAt      string `json:"finished_at"`
+}
+
+func (job *ImportJob) addError(line int, err error) {
+	if len(job.Errors) >= maxImportErrors {
+		job.ErrorsTruncated = true
+		return
+	}
+	job.Errors = append(job.Errors, ImportError{Line: line, Error: err.Error()})
+}
+
+// importRecord is one NDJSON line: an event plus the source event_id and
+// received_at that regular ingestion would overwrite
+type importRecord struct {
+	Event
+	EventID    string `json:"event_id"`
+	ReceivedAt string `json:"received_at"`
+}
+
+// importHandler imports historical events from an NDJSON or CSV body.
+// Unlike /v1/events it keeps the source event_id, timestamp and
+// received_at, and tags every event with import_batch_id. The body is
+// processed before responding and the response is the job report. The
+// report is not stored: when the connection drops before it arrives, the
+// upload can be repeated with the same import_batch_id, since event_ids
+// are kept or derived from it and the repeat replaces rather than
+// duplicates.
+//
+// Query parameters: format (ndjson|csv, otherwise taken from Content-Type),
+// tenant_id (default for records without one) and import_batch_id (to tag
+// several uploads of one migration alike; defaults to the job ID).
+func importHandler(c *fiber.Ctx) error {
+	if !tokenMatches(bearerToken(c), importToken) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or missing bearer token"})
+	}
+
+	format := strings.ToLower(c.Query("format"))
+	if format == "" {
+		contentType := strings.ToLower(c.Get("Content-Type"))
+		switch {
+		case strings.Contains(contentType, "csv"):
+			format = "csv"
+		case strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "jsonl"), strings.Contains(contentType, "json"):
+			format = "ndjson"
+		}
+	}
+	if format != "ndjson" && format != "csv" {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be ndjson or csv"})
+	}
+
+	jobID := uuid.New().String()
+	batchID := c.Query("import_batch_id", jobID)
+	job := runImport(bytes.NewReader(c.Body()), format, batchID, c.Query("tenant_id"), producer(c, "import"), sendJSON)
+	job.JobID = jobID
+
+	log.Printf("Import %s (batch %s): %d imported, %d rejected, %d failed of %d",
+		jobID, batchID, job.Imported, job.Rejected, job.Failed, job.Total)
+	if job.Status == "failed" {
+		return c.Status(400).JSON(job)
+	}
+	return c.Status(200).JSON(job)
+}
+
+// runImport parses and forwards every record in r. send posts one chunk of
+// events to Vector; a chunk that cannot be delivered counts as failed.
+func runImport(r io.Reader, format, batchID, tenant, producer string, send func(url string, payload interface{}) error) ImportJob {
+	job := ImportJob{
+		ImportBatchID: batchID,
+		Format:        format,
+		Status:        "completed",
+		Errors:        []ImportError{},
+		StartedAt:     time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	var chunk []EnrichedEvent
+	var chunkLines []int
+	flush := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		if err := send(vectorURL, chunk); err != nil {
+			job.Failed += len(chunk)
+			for _, line := range chunkLines {
+				job.addError(line, fmt.Errorf("forwarding failed: %w", err))
+			}
+		} else {
+			job.Imported += len(chunk)
+		}
+		chunk, chunkLines = chunk[:0], chunkLines[:0]
+	}
+	add := func(line int, record importRecord, err error) {
+		job.Total++
+		var enriched EnrichedEvent
+		if err == nil {
+			record.Event.Producer = producer
+			enriched, err = importEvent(record, batchID, tenant, line)
+		}
+		if err != nil {
+			job.Rejected++
+			job.addError(line, err)
+			return
+		}
+		chunk = append(chunk, enriched)
+		chunkLines = append(chunkLines, line)
+		if len(chunk) >= importChunkSize {
+			flush()
+		}
+	}
+
+	var err error
+	if format == "csv" {
+		err = readImportCSV(r, add)
+	} else {
+		err = readImportNDJSON(r, add)
+	}
+	flush()
+	if err != nil {
+		job.Status = "failed"
+		job.addError(0, err)
+	}
+	job.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	return job
+}
+
+// readImportNDJSON decodes one importRecord per non-empty line
+func readImportNDJSON(r io.Reader, add func(int, importRecord, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var record importRecord
+		if err := json.Unmarshal(text, &record); err != nil {
+			add(line, record, errors.New("invalid JSON"))
+			continue
+		}
+		add(line, record, nil)
+	}
+	return scanner.Err()
+}
+
+// readImportCSV maps a CSV export with a header row onto importRecords.
+// Columns follow the audit.events names (actor_id, action_name,
+// resource_type, ...); unknown columns are kept in context.
+func readImportCSV(r io.Reader, add func(int, importRecord, error)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("reading CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	line := 1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line++
+		if err != nil {
+			add(line, importRecord{}, err)
+			continue
+		}
+		if len(row) != len(header) {
+			add(line, importRecord{}, fmt.Errorf("expected %d columns, got %d", len(header), len(row)))
+			continue
+		}
+		record, err := csvImportRecord(header, row)
+		add(line, record, err)
+	}
+}
+
+func csvImportRecord(header, row []string) (importRecord, error) {
+	record := importRecord{Event: Event{
+		Actor:    map[string]interface{}{},
+		Action:   map[string]interface{}{},
+		Resource: map[string]interface{}{},
+		Result:   map[string]interface{}{},
+		Context:  map[string]interface{}{},
+	}}
+	for i, col := range header {
+		val := row[i]
+		switch col {
+		case "event_id":
+			record.EventID = val
+		case "received_at":
+			record.ReceivedAt = val
+		case "timestamp":
+			record.Timestamp = val
+		case "tenant_id":
+			record.TenantID = val
+		case "actor_id", "actor_type", "actor_email":
+			putString(record.Actor, strings.TrimPrefix(col, "actor_"), val)
+		case "action_name":
+			putString(record.Action, "name", val)
+		case "resource_type", "resource_id":
+			putString(record.Resource, strings.TrimPrefix(col, "resource_"), val)
+		case "result_success":
+			if val == "" {
+				continue
+			}
+			success, err := strconv.ParseBool(val)
+			if err != nil {
+				return record, fmt.Errorf("invalid result_success %q", val)
+			}
+			record.Result["success"] = success
+		case "result_message":
+			putString(record.Result, "message", val)
+		case "context_ip", "context_user_agent":
+			putString(record.Context, strings.TrimPrefix(col, "context_"), val)
+		case "event_date", "raw_event", "processing_vector_node", "import_batch_id":
+			// Derived on import
+		default:
+			putString(record.Context, col, val)
+		}
+	}
+	return record, nil
+}
+
+// importEvent validates a record and keeps its source identity. Event IDs
+// that are not UUIDs are mapped to a stable UUID and kept in
+// context.source_event_id; records without one get an ID derived from the
+// batch and line so that re-running the same batch stays idempotent.
+func importEvent(record importRecord, batchID, tenant string, line int) (EnrichedEvent, error) {
+	event := record.Event
+	if event.TenantID == "" {
+		event.TenantID = tenant
+	}
+	if err := validateEvent(event); err != nil {
+		return EnrichedEvent{}, err
+	}
+	event = normalizeEvent(event)
+	if event.Timestamp == "" {
+		return EnrichedEvent{}, errors.New("timestamp is required for import")
+	}
+	ts, err := parseImportTime(event.Timestamp)
+	if err != nil {
+		return EnrichedEvent{}, fmt.Errorf("invalid timestamp %q", event.Timestamp)
+	}
+	event.Timestamp = ts.Format(time.RFC3339Nano)
+
+	receivedAt := event.Timestamp
+	if record.ReceivedAt != "" {
+		parsed, err := parseImportTime(record.ReceivedAt)
+		if err != nil {
+			return EnrichedEvent{}, fmt.Errorf("invalid received_at %q", record.ReceivedAt)
+		}
+		receivedAt = parsed.Format(time.RFC3339Nano)
+	}
+
+	var eventID string
+	if record.EventID == "" {
+		eventID = uuid.NewSHA1(importNamespace, []byte(fmt.Sprintf("%s/%s/%d", event.TenantID, batchID, line))).String()
+	} else if id, err := uuid.Parse(record.EventID); err == nil {
+		eventID = id.String()
+	} else {
+		eventID = uuid.NewSHA1(importNamespace, []byte(event.TenantID+"/"+record.EventID)).String()
+		if event.Context == nil {
+			event.Context = map[string]interface{}{}
+		}
+		event.Context["source_event_id"] = record.EventID
+	}
+
+	return EnrichedEvent{
+		Event:         event,
+		EventID:       eventID,
+		ReceivedAt:    receivedAt,
+		EventDate:     ts.Format("2006-01-02"),
+		ImportBatchID: batchID,
+		Producer:      event.Producer,
+	}, nil
+}
+
+// parseImportTime accepts RFC 3339 and the ClickHouse DateTime text formats
+func parseImportTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
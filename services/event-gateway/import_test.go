@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestImport_NDJSONKeepsSourceIdentity(t *testing.T) {
+	var sent []EnrichedEvent
+	send := func(url string, payload interface{}) error {
+		sent = append(sent, payload.([]EnrichedEvent)...)
+		return nil
+	}
+	body := strings.Join([]string{
+		`{"event_id":"0190b3c4-5d6e-7f80-9a1b-2c3d4e5f6a7b","timestamp":"2021-03-04T05:06:07Z","received_at":"2021-03-04T05:06:08Z","actor":{"id":"alice"},"action":{"name":"user.login"},"resource":{"type":"app","id":"crm"}}`,
+		`{"event_id":"legacy-42","timestamp":"2019-12-31 23:59:59","actor":{"id":"bob"},"action":{"name":"user.logout"},"resource":{"type":"app","id":"crm"}}`,
+		``,
+		`{"actor":{"id":"carol"},"action":{"name":"user.login"},"resource":{"type":"app","id":"crm"}}`,
+		`not json`,
+	}, "\n")
+
+	job := runImport(strings.NewReader(body), "ndjson", "mig-1", "acme", "migrator", send)
+
+	if job.Status != "completed" || job.Total != 4 || job.Imported != 2 || job.Rejected != 2 {
+		t.Fatalf("Unexpected job: %+v", job)
+	}
+	if job.Errors[0].Line != 4 || job.Errors[1].Line != 5 {
+		t.Errorf("Unexpected error lines: %+v", job.Errors)
+	}
+
+	first := sent[0]
+	if first.EventID != "0190b3c4-5d6e-7f80-9a1b-2c3d4e5f6a7b" || first.ReceivedAt != "2021-03-04T05:06:08Z" ||
+		first.EventDate != "2021-03-04" || first.ImportBatchID != "mig-1" || first.TenantID != "acme" || first.Producer != "migrator" {
+		t.Errorf("Unexpected first event: %+v", first)
+	}
+	second := sent[1]
+	if second.Context["source_event_id"] != "legacy-42" || second.EventDate != "2019-12-31" || second.ReceivedAt != "2019-12-31T23:59:59Z" {
+		t.Errorf("Unexpected second event: %+v", second)
+	}
+
+	// Re-importing maps legacy IDs to the same event_id, so storage deduplicates
+	var resent []EnrichedEvent
+	runImport(strings.NewReader(body), "ndjson", "mig-2", "acme", "migrator", func(url string, payload interface{}) error {
+		resent = append(resent, payload.([]EnrichedEvent)...)
+		return nil
+	})
+	if len(resent) != 2 || resent[1].EventID != second.EventID {
+		t.Errorf("Expected stable event_id for legacy-42, got %+v", resent)
+	}
+}
+
+func TestImport_CSV(t *testing.T) {
+	var sent []EnrichedEvent
+	send := func(url string, payload interface{}) error {
+		sent = append(sent, payload.([]EnrichedEvent)...)
+		return nil
+	}
+	body := "event_id,tenant_id,timestamp,actor_id,action_name,resource_type,resource_id,result_success,context_ip,ticket\n" +
+		"0190b3c4-5d6e-7f80-9a1b-2c3d4e5f6a7b,acme,2022-07-01T10:00:00Z,alice,doc.read,document,d-1,false,10.0.0.1,T-9\n" +
+		"0190b3c4-5d6e-7f80-9a1b-2c3d4e5f6a7c,acme,2022-07-01T10:00:00Z,alice,doc.read,document,d-1,maybe,,\n"
+
+	job := runImport(strings.NewReader(body), "csv", "mig-2", "", "migrator", send)
+
+	if job.Imported != 1 || job.Rejected != 1 || job.Errors[0].Line != 3 {
+		t.Fatalf("Unexpected job: %+v", job)
+	}
+	event := sent[0]
+	if event.Result["success"] != false || event.Context["ip"] != "10.0.0.1" || event.Context["ticket"] != "T-9" || event.Actor["id"] != "alice" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestImport_ForwardingFailureCounted(t *testing.T) {
+	body := `{"event_id":"e1","timestamp":"2020-01-01T00:00:00Z","actor":{"id":"a"},"action":{"name":"x"},"resource":{"type":"t","id":"1"}}`
+	job := runImport(strings.NewReader(body), "ndjson", "mig-3", "", "migrator", func(string, interface{}) error {
+		return errors.New("connection refused")
+	})
+	if job.Failed != 1 || job.Imported != 0 || len(job.Errors) != 1 {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+}
+
+func TestImport_RequiresToken(t *testing.T) {
+	vector := fakeVector(t)
+	saved := importToken
+	importToken = "import-s3cret"
+	defer func() { importToken = saved }()
+
+	app := fiber.New()
+	app.Post("/v1/import", importHandler)
+	body := `{"event_id":"e1","timestamp":"2020-01-01T00:00:00Z","actor":{"id":"a"},"action":{"name":"x"},"resource":{"type":"t","id":"1"}}`
+	post := func(auth string) int {
+		req := httptest.NewRequest("POST", "/v1/import?format=ndjson", strings.NewReader(body))
+		// The consumer header alone proves nothing
+		req.Header.Set("X-Consumer-Name", "audit-importer")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := post(""); got != 401 {
+		t.Errorf("No token: status %d, want 401", got)
+	}
+	if got := post("Bearer wrong"); got != 401 {
+		t.Errorf("Wrong token: status %d, want 401", got)
+	}
+	if got := post("Bearer import-s3cret"); got != 200 || len(vector.received()) != 1 {
+		t.Errorf("Valid token: status %d with %d delivered", got, len(vector.received()))
+	}
+}
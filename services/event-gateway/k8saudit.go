@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// k8sAuditEventList mirrors audit.k8s.io/v1 EventList as posted by the
+// API server's webhook backend. Only the fields that are mapped are declared.
+type k8sAuditEventList struct {
+	Kind       string          `json:"kind"`
+	APIVersion string          `json:"apiVersion"`
+	Items      []k8sAuditEvent `json:"items"`
+}
+
+type k8sAuditEvent struct {
+	Level                    string                 `json:"level"`
+	AuditID                  string                 `json:"auditID"`
+	Stage                    string                 `json:"stage"`
+	RequestURI               string                 `json:"requestURI"`
+	Verb                     string                 `json:"verb"`
+	User                     k8sUserInfo            `json:"user"`
+	ImpersonatedUser         *k8sUserInfo           `json:"impersonatedUser,omitempty"`
+	SourceIPs                []string               `json:"sourceIPs"`
+	UserAgent                string                 `json:"userAgent"`
+	ObjectRef                *k8sObjectReference    `json:"objectRef,omitempty"`
+	ResponseStatus           *k8sStatus             `json:"responseStatus,omitempty"`
+	RequestObject            json.RawMessage        `json:"requestObject,omitempty"`
+	ResponseObject           json.RawMessage        `json:"responseObject,omitempty"`
+	RequestReceivedTimestamp string                 `json:"requestReceivedTimestamp"`
+	StageTimestamp           string                 `json:"stageTimestamp"`
+	Annotations              map[string]interface{} `json:"annotations,omitempty"`
+}
+
+type k8sUserInfo struct {
+	Username string   `json:"username"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+type k8sObjectReference struct {
+	Resource    string `json:"resource"`
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	UID         string `json:"uid"`
+	APIGroup    string `json:"apiGroup"`
+	APIVersion  string `json:"apiVersion"`
+	Subresource string `json:"subresource"`
+}
+
+type k8sStatus struct {
+	Code    int    `json:"code"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+// k8sAuditFilter selects which audit stages and levels are kept
+type k8sAuditFilter struct {
+	Stages map[string]bool
+	Levels map[string]bool
+	Tenant string
+	// Token is the bearer token the API server's webhook kubeconfig sends
+	Token string
+}
+
+var k8sAudit k8sAuditFilter
+
+func init() {
+	k8sAudit = k8sAuditFilter{
+		Stages: csvSet(getEnv("K8S_AUDIT_STAGES", "ResponseComplete,Panic")),
+		Levels: csvSet(getEnv("K8S_AUDIT_LEVELS", "Metadata,Request,RequestResponse")),
+		Tenant: os.Getenv("K8S_AUDIT_TENANT"),
+		Token:  os.Getenv("K8S_AUDIT_TOKEN"),
+	}
+}
+
+// k8sAuditHandler accepts the EventList batches sent by the Kubernetes
+// audit webhook backend. Events outside the configured stages and levels
+// are counted as skipped. The webhook must authenticate with the bearer
+// token of K8S_AUDIT_TOKEN; without one configured the endpoint is closed.
+// Batches are forwarded synchronously: a forwarding failure is a 502, so
+// the webhook backend retries the batch.
+func k8sAuditHandler(c *fiber.Ctx) error {
+	if !tokenMatches(bearerToken(c), k8sAudit.Token) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or missing bearer token"})
+	}
+	var list k8sAuditEventList
+	if err := json.Unmarshal(c.Body(), &list); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON: expected audit.k8s.io/v1 EventList"})
+	}
+	if list.Kind != "" && list.Kind != "EventList" {
+		return c.Status(400).JSON(fiber.Map{"error": "Expected kind EventList"})
+	}
+	if len(list.Items) > maxBatchSize {
+		return c.Status(400).JSON(fiber.Map{"error": "Maximum 1000 events per batch"})
+	}
+
+	events := make([]Event, 0, len(list.Items))
+	skipped := 0
+	for _, item := range list.Items {
+		if !k8sAudit.Stages[item.Stage] || !k8sAudit.Levels[item.Level] {
+			skipped++
+			continue
+		}
+		event := k8sAuditToEvent(item, k8sAudit.Tenant)
+		event.Producer = producer(c, "k8s-audit")
+		events = append(events, event)
+	}
+
+	resp, err := deliverBatch(events, time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		log.Printf("Forwarding Kubernetes audit events: %v", err)
+		return c.Status(502).JSON(fiber.Map{"error": "forwarding to Vector failed"})
+	}
+	return c.Status(200).JSON(fiber.Map{
+		"accepted": resp.Accepted,
+		"rejected": resp.Rejected,
+		"skipped":  skipped,
+	})
+}
+
+// k8sAuditToEvent maps user.username, verb, objectRef and responseStatus
+// into actor, action, resource and result
+func k8sAuditToEvent(item k8sAuditEvent, tenant string) Event {
+	event := Event{
+		Actor:    map[string]interface{}{},
+		Action:   map[string]interface{}{},
+		Resource: map[string]interface{}{},
+		Result:   map[string]interface{}{"success": true},
+		Context: map[string]interface{}{
+			"audit_id":    item.AuditID,
+			"stage":       item.Stage,
+			"level":       item.Level,
+			"request_uri": item.RequestURI,
+			"verb":        item.Verb,
+		},
+		TenantID: tenant,
+		// Retried batches keep their event_ids. Each stage of a request is
+		// its own audit event, so the stage is part of the key.
+		IdempotencyKey: item.AuditID + "/" + item.Stage,
+	}
+
+	putString(event.Actor, "id", item.User.Username)
+	if strings.HasPrefix(item.User.Username, "system:serviceaccount:") {
+		event.Actor["type"] = "service_account"
+	} else if strings.HasPrefix(item.User.Username, "system:") {
+		event.Actor["type"] = "system"
+	} else {
+		event.Actor["type"] = "user"
+	}
+	if len(item.User.Groups) > 0 {
+		event.Context["groups"] = item.User.Groups
+	}
+	if item.ImpersonatedUser != nil {
+		event.Context["impersonated_user"] = item.ImpersonatedUser.Username
+	}
+
+	putString(event.Action, "name", "k8s."+item.Verb)
+
+	if ref := item.ObjectRef; ref != nil && ref.Resource != "" {
+		resourceType := ref.Resource
+		if ref.Subresource != "" {
+			resourceType += "/" + ref.Subresource
+		}
+		if ref.APIGroup != "" {
+			resourceType += "." + ref.APIGroup
+		}
+		event.Resource["type"] = resourceType
+
+		id := ref.Name
+		if id == "" {
+			// Collection requests (list, watch, deletecollection) have no name
+			id = "*"
+		}
+		if ref.Namespace != "" {
+			id = ref.Namespace + "/" + id
+		}
+		event.Resource["id"] = id
+		putString(event.Context, "namespace", ref.Namespace)
+		putString(event.Context, "api_version", ref.APIVersion)
+		putString(event.Context, "object_uid", ref.UID)
+	} else {
+		// Non-resource requests such as /healthz or /version
+		event.Resource["type"] = "non_resource_url"
+		putString(event.Resource, "id", item.RequestURI)
+	}
+
+	if status := item.ResponseStatus; status != nil {
+		event.Context["response_code"] = status.Code
+		event.Result["success"] = status.Code > 0 && status.Code < 400
+		message := status.Message
+		if message == "" {
+			message = status.Reason
+		}
+		putString(event.Result, "message", message)
+	}
+
+	if len(item.SourceIPs) > 0 {
+		event.Context["ip"] = item.SourceIPs[0]
+		event.Context["source_ips"] = item.SourceIPs
+	}
+	putString(event.Context, "user_agent", item.UserAgent)
+	if len(item.Annotations) > 0 {
+		event.Context["annotations"] = item.Annotations
+	}
+	if len(item.RequestObject) > 0 {
+		event.Context["request_object"] = item.RequestObject
+	}
+	if len(item.ResponseObject) > 0 {
+		event.Context["response_object"] = item.ResponseObject
+	}
+
+	event.Timestamp = item.StageTimestamp
+	if event.Timestamp == "" {
+		event.Timestamp = item.RequestReceivedTimestamp
+	}
+	return event
+}
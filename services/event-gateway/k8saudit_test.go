@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const k8sAuditSample = `{"kind":"EventList","apiVersion":"audit.k8s.io/v1","items":[
+	{"level":"Metadata","auditID":"a1","stage":"ResponseComplete","requestURI":"/api/v1/namespaces/audit/secrets/db","verb":"get",
+	 "user":{"username":"system:serviceaccount:audit:reader","groups":["system:serviceaccounts"]},
+	 "sourceIPs":["10.244.0.12"],"userAgent":"kubectl/v1.29.0",
+	 "objectRef":{"resource":"secrets","namespace":"audit","name":"db","apiVersion":"v1"},
+	 "responseStatus":{"metadata":{},"status":"Failure","reason":"Forbidden","code":403},
+	 "requestReceivedTimestamp":"2025-12-20T10:00:00.000000Z","stageTimestamp":"2025-12-20T10:00:00.004000Z"},
+	{"level":"Metadata","auditID":"a2","stage":"RequestReceived","verb":"get","user":{"username":"alice"}}
+]}`
+
+func TestK8sAudit_Mapping(t *testing.T) {
+	var list k8sAuditEventList
+	if err := json.Unmarshal([]byte(k8sAuditSample), &list); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	event := k8sAuditToEvent(list.Items[0], "platform")
+	if err := validateEvent(event); err != nil {
+		t.Fatalf("Expected valid event, got %v", err)
+	}
+	if event.Actor["id"] != "system:serviceaccount:audit:reader" || event.Actor["type"] != "service_account" {
+		t.Errorf("Unexpected actor: %v", event.Actor)
+	}
+	if event.Action["name"] != "k8s.get" {
+		t.Errorf("Unexpected action: %v", event.Action)
+	}
+	if event.Resource["type"] != "secrets" || event.Resource["id"] != "audit/db" {
+		t.Errorf("Unexpected resource: %v", event.Resource)
+	}
+	if event.Result["success"] != false || event.Result["message"] != "Forbidden" {
+		t.Errorf("Unexpected result: %v", event.Result)
+	}
+	if event.Context["ip"] != "10.244.0.12" || event.TenantID != "platform" {
+		t.Errorf("Unexpected context: %v", event.Context)
+	}
+	if retry := k8sAuditToEvent(list.Items[0], "platform"); enrichEvent(retry, "").EventID != enrichEvent(event, "").EventID {
+		t.Error("Expected a retried audit event to keep its event_id")
+	}
+}
+
+func TestK8sAudit_StageFilter(t *testing.T) {
+	filter := k8sAuditFilter{Stages: csvSet("ResponseComplete"), Levels: csvSet("Metadata,Request")}
+	if !filter.Stages["ResponseComplete"] || filter.Stages["RequestReceived"] {
+		t.Errorf("Unexpected stage set: %v", filter.Stages)
+	}
+	if filter.Levels["RequestResponse"] {
+		t.Errorf("Unexpected level set: %v", filter.Levels)
+	}
+}
+
+func TestK8sAudit_RequiresTokenAndCapsBatch(t *testing.T) {
+	vector := fakeVector(t)
+	saved := k8sAudit
+	k8sAudit = k8sAuditFilter{Token: "s3cret", Stages: csvSet("ResponseComplete"), Levels: csvSet("Metadata")}
+	defer func() { k8sAudit = saved }()
+
+	app := fiber.New()
+	app.Post("/v1/k8s/audit", k8sAuditHandler)
+	post := func(token, body string) int {
+		req := httptest.NewRequest("POST", "/v1/k8s/audit", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := post("", `{"kind":"EventList","items":[]}`); got != 401 {
+		t.Errorf("No token: status %d, want 401", got)
+	}
+	if got := post("wrong", `{"kind":"EventList","items":[]}`); got != 401 {
+		t.Errorf("Wrong token: status %d, want 401", got)
+	}
+	items := strings.TrimSuffix(strings.Repeat(`{"stage":"RequestReceived"},`, maxBatchSize+1), ",")
+	if got := post("s3cret", `{"kind":"EventList","items":[`+items+`]}`); got != 400 {
+		t.Errorf("Oversized batch: status %d, want 400", got)
+	}
+	if got := post("s3cret", k8sAuditSample); got != 200 || len(vector.received()) != 1 {
+		t.Errorf("Valid token: status %d with %d delivered, want 200 after delivery", got, len(vector.received()))
+	}
+	vector.setStatus(503)
+	if got := post("s3cret", k8sAuditSample); got != 502 {
+		t.Errorf("Vector down: status %d, want 502", got)
+	}
+}
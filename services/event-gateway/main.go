@@ -2,14 +2,21 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/google/uuid"
 )
 
@@ -22,6 +29,11 @@ type Event struct {
 	Result    map[string]interface{} `json:"result,omitempty"`
 	Context   map[string]interface{} `json:"context,omitempty"`
 	TenantID  string                 `json:"tenant_id,omitempty"`
+	// IdempotencyKey makes retried submissions of the same event resolve
+	// to the same event_id, so storage deduplicates them
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Producer is set by the gateway, never read from the body
+	Producer string `json:"-"`
 }
 
 // EnrichedEvent extends Event with generated fields
@@ -29,6 +41,11 @@ type EnrichedEvent struct {
 	Event
 	EventID    string `json:"event_id"`
 	ReceivedAt string `json:"received_at"`
+	// EventDate is the day of the event's timestamp, or of received_at
+	// when it has none. ImportBatchID is only set by historical imports.
+	EventDate     string `json:"event_date,omitempty"`
+	ImportBatchID string `json:"import_batch_id,omitempty"`
+	Producer      string `json:"producer,omitempty"`
 }
 
 // SingleResponse is the response for single event ingestion
@@ -46,6 +63,7 @@ type BatchRequest struct {
 type BatchEventResponse struct {
 	EventID string `json:"event_id"`
 	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
 }
 
 // BatchResponse is the response for batch event ingestion
@@ -55,13 +73,231 @@ type BatchResponse struct {
 	Events   []BatchEventResponse `json:"events"`
 }
 
-var vectorURL string
+// RejectedRecord is raw input that could not be turned into an Event.
+// It is kept on the rejected stream instead of being dropped.
+type RejectedRecord struct {
+	Source     string `json:"source"`
+	Reason     string `json:"reason"`
+	Raw        string `json:"raw"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	TenantID   string `json:"tenant_id,omitempty"`
+	ReceivedAt string `json:"received_at"`
+}
+
+var (
+	vectorURL   string
+	rejectedURL string
+)
 
 func init() {
 	vectorURL = os.Getenv("VECTOR_URL")
 	if vectorURL == "" {
-		vectorURL = "http://vector.vector.svc.cluster.local:8080"
+		vectorURL = "http://vector.vector.svc.cluster.local:8083"
+	}
+	rejectedURL = os.Getenv("REJECTED_URL")
+	if rejectedURL == "" {
+		rejectedURL = "http://vector.vector.svc.cluster.local:8082"
+	}
+}
+
+// getEnv returns the environment variable or a default
+func getEnv(key, defaultVal string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultVal
+}
+
+// csvSet parses a comma-separated list into a set
+func csvSet(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// tokenMatches compares a presented secret in constant time. An empty
+// expected secret never matches, so unconfigured endpoints stay closed.
+func tokenMatches(got, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(c *fiber.Ctx) string {
+	token, _ := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	return token
+}
+
+// validateEvent checks the fields every audit event must carry
+func validateEvent(event Event) error {
+	if event.Actor == nil || event.Actor["id"] == nil {
+		return errors.New("actor.id is required")
+	}
+	if event.Action == nil || event.Action["name"] == nil {
+		return errors.New("action.name is required")
+	}
+	if event.Resource == nil || event.Resource["type"] == nil || event.Resource["id"] == nil {
+		return errors.New("resource.type and resource.id are required")
+	}
+	return nil
+}
+
+// defaultTenant is stored for events that carry no tenant_id
+const defaultTenant = "default_tenant"
+
+// normalizeEvent applies the normalization of the Vector enrich transform,
+// so that the gateway's view of an event matches what is stored
+func normalizeEvent(event Event) Event {
+	if name, ok := event.Action["name"].(string); ok {
+		event.Action = maps.Clone(event.Action)
+		event.Action["name"] = strings.ToLower(strings.TrimSpace(name))
+	}
+	if event.TenantID == "" {
+		event.TenantID = defaultTenant
+	}
+	return event
+}
+
+// enrichEvent assigns the server-generated metadata to a validated event.
+// event_date follows the event's own timestamp when it has one.
+func enrichEvent(event Event, receivedAt string) EnrichedEvent {
+	enriched := EnrichedEvent{
+		Event:      event,
+		EventID:    eventID(event),
+		ReceivedAt: receivedAt,
+		Producer:   event.Producer,
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, event.Timestamp); err == nil {
+		enriched.EventDate = ts.UTC().Format("2006-01-02")
+	} else if len(receivedAt) >= len("2006-01-02") {
+		enriched.EventDate = receivedAt[:len("2006-01-02")]
+	}
+	return enriched
+}
+
+// prepareEvent runs the whole pipeline short of forwarding: validation,
+// normalization and enrichment
+func prepareEvent(event Event, receivedAt string) (EnrichedEvent, error) {
+	if err := validateEvent(event); err != nil {
+		return EnrichedEvent{}, err
+	}
+	return enrichEvent(normalizeEvent(event), receivedAt), nil
+}
+
+// idempotencyNamespace scopes the name-based UUIDs derived from idempotency keys
+var idempotencyNamespace = uuid.MustParse("6f1d7c1e-3b0a-5c8e-9a4f-2d7e8b1c0a53")
+
+// eventID derives the event_id from the tenant and idempotency key when one
+// is given, and generates a fresh ID otherwise
+func eventID(event Event) string {
+	if event.IdempotencyKey == "" {
+		return generateUUIDv7()
+	}
+	return uuid.NewSHA1(idempotencyNamespace, []byte(event.TenantID+"/"+event.IdempotencyKey)).String()
+}
+
+// maxBatchSize is the largest number of events accepted in one batch
+const maxBatchSize = 1000
+
+// ingestEvent validates, enriches and forwards a single event. Every
+// ingestion path (HTTP, OTLP, syslog, gRPC) goes through here.
+func ingestEvent(event Event, receivedAt string) (EnrichedEvent, error) {
+	enriched, err := prepareEvent(event, receivedAt)
+	if err != nil {
+		return EnrichedEvent{}, err
+	}
+	forwardToVector(enriched)
+	return enriched, nil
+}
+
+// producer names who submitted a request: the APISIX consumer, or source
+// when it did not come through APISIX. The header is copied because
+// events outlive the request.
+func producer(c *fiber.Ctx, source string) string {
+	if consumer := c.Get("X-Consumer-Name"); consumer != "" {
+		return utils.CopyString(consumer)
+	}
+	return source
+}
+
+// batchHandler accepts an array or {events: [...]} and forwards it
+// synchronously: a 202 means Vector has the accepted events, so callers
+// that checkpoint (the outbox relay) may mark them delivered. A forwarding
+// failure is a 502 and the whole batch should be retried; event_ids are
+// stable for events with an idempotency_key.
+func batchHandler(c *fiber.Ctx) error {
+	var events []Event
+
+	// Try parsing as array first
+	if err := c.BodyParser(&events); err != nil {
+		// Try parsing as BatchRequest
+		var batchReq BatchRequest
+		if err := json.Unmarshal(c.Body(), &batchReq); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON: expected array or {events: [...]}"})
+		}
+		events = batchReq.Events
+	}
+
+	if len(events) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "No events provided"})
+	}
+
+	if len(events) > maxBatchSize {
+		return c.Status(400).JSON(fiber.Map{"error": "Maximum 1000 events per batch"})
+	}
+	for i := range events {
+		events[i].Producer = producer(c, "")
+	}
+
+	receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	resp, err := deliverBatch(events, receivedAt)
+	if err != nil {
+		log.Printf("Forwarding batch: %v", err)
+		return c.Status(502).JSON(fiber.Map{"error": "forwarding to Vector failed"})
+	}
+	return c.Status(202).JSON(resp)
+}
+
+// deliverBatch ingests each event of a batch independently, so that invalid
+// events are rejected without failing the whole batch, and forwards the
+// accepted ones synchronously: it returns once Vector has taken them, or
+// with an error when it has not. Sources that checkpoint or that can be
+// asked to redeliver advance or acknowledge only after it succeeds.
+func deliverBatch(events []Event, receivedAt string) (BatchResponse, error) {
+	resp, accepted := prepareBatch(events, receivedAt)
+	if len(accepted) == 0 {
+		return resp, nil
+	}
+	return resp, sendJSON(vectorURL, accepted)
+}
+
+// prepareBatch prepares each event, returning the per-event results and
+// the accepted events in order
+func prepareBatch(events []Event, receivedAt string) (BatchResponse, []EnrichedEvent) {
+	resp := BatchResponse{Events: make([]BatchEventResponse, 0, len(events))}
+	accepted := make([]EnrichedEvent, 0, len(events))
+	for _, event := range events {
+		enriched, err := prepareEvent(event, receivedAt)
+		if err != nil {
+			resp.Rejected++
+			resp.Events = append(resp.Events, BatchEventResponse{
+				EventID: "",
+				Status:  "rejected",
+				Error:   err.Error(),
+			})
+			continue
+		}
+		resp.Accepted++
+		resp.Events = append(resp.Events, BatchEventResponse{
+			EventID: enriched.EventID,
+			Status:  "accepted",
+		})
+		accepted = append(accepted, enriched)
 	}
+	return resp, accepted
 }
 
 // generateUUIDv7 generates a time-ordered UUID (v7-like using v4 for simplicity)
@@ -71,27 +307,47 @@ func generateUUIDv7() string {
 
 // forwardToVector sends event to Vector asynchronously
 func forwardToVector(event EnrichedEvent) {
-	go func() {
-		data, err := json.Marshal(event)
-		if err != nil {
-			log.Printf("Error marshaling event: %v", err)
-			return
-		}
-		resp, err := http.Post(vectorURL, "application/json", bytes.NewReader(data))
-		if err != nil {
-			log.Printf("Error forwarding to Vector: %v", err)
-			return
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode >= 400 {
-			log.Printf("Vector returned error: %d", resp.StatusCode)
-		}
-	}()
+	go postJSON(vectorURL, event)
+}
+
+// forwardRejected sends a rejected record to the rejected stream asynchronously
+func forwardRejected(record RejectedRecord) {
+	go postJSON(rejectedURL, record)
+}
+
+func postJSON(url string, payload interface{}) {
+	if err := sendJSON(url, payload); err != nil {
+		log.Printf("Error forwarding to Vector: %v", err)
+	}
+}
+
+// sendJSON posts payload to url and reports delivery failures
+func sendJSON(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshaling event: %w", err)
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Vector returned error: %d", resp.StatusCode)
+	}
+	return nil
 }
 
 func main() {
+	// Imports upload whole files, so the limit is configurable (Fiber's default is 4 MB)
+	bodyLimitMB, err := strconv.Atoi(getEnv("BODY_LIMIT_MB", "4"))
+	if err != nil || bodyLimitMB <= 0 {
+		log.Fatalf("Invalid BODY_LIMIT_MB: %q", os.Getenv("BODY_LIMIT_MB"))
+	}
+
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
+		BodyLimit:             bodyLimitMB << 20,
 	})
 
 	app.Use(logger.New())
@@ -107,100 +363,57 @@ func main() {
 		if err := c.BodyParser(&event); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
 		}
+		event.Producer = producer(c, "")
 
-		// Validate required fields
-		if event.Actor == nil || event.Actor["id"] == nil {
-			return c.Status(400).JSON(fiber.Map{"error": "actor.id is required"})
-		}
-		if event.Action == nil || event.Action["name"] == nil {
-			return c.Status(400).JSON(fiber.Map{"error": "action.name is required"})
-		}
-		if event.Resource == nil || event.Resource["type"] == nil || event.Resource["id"] == nil {
-			return c.Status(400).JSON(fiber.Map{"error": "resource.type and resource.id are required"})
-		}
-
-		// Generate metadata
-		eventID := generateUUIDv7()
-		receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
-
-		// Create enriched event
-		enriched := EnrichedEvent{
-			Event:      event,
-			EventID:    eventID,
-			ReceivedAt: receivedAt,
+		// Validate, enrich and forward to Vector asynchronously
+		enriched, err := ingestEvent(event, time.Now().UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		// Forward to Vector asynchronously
-		forwardToVector(enriched)
-
 		// Return 202 immediately
 		return c.Status(202).JSON(SingleResponse{
-			EventID:    eventID,
-			ReceivedAt: receivedAt,
+			EventID:    enriched.EventID,
+			ReceivedAt: enriched.ReceivedAt,
 		})
 	})
 
 	// Batch event endpoint
-	app.Post("/v1/events/batch", func(c *fiber.Ctx) error {
-		var events []Event
-
-		// Try parsing as array first
-		if err := c.BodyParser(&events); err != nil {
-			// Try parsing as BatchRequest
-			var batchReq BatchRequest
-			if err := json.Unmarshal(c.Body(), &batchReq); err != nil {
-				return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON: expected array or {events: [...]}"})
-			}
-			events = batchReq.Events
-		}
+	app.Post("/v1/events/batch", batchHandler)
 
-		if len(events) == 0 {
-			return c.Status(400).JSON(fiber.Map{"error": "No events provided"})
-		}
+	// Dry run: the events that would be stored, without forwarding
+	app.Post("/v1/events/validate", validateHandler)
 
-		if len(events) > 1000 {
-			return c.Status(400).JSON(fiber.Map{"error": "Maximum 1000 events per batch"})
-		}
+	// Historical backfill import (NDJSON or CSV)
+	app.Post("/v1/import", importHandler)
 
-		var results []BatchEventResponse
-		accepted := 0
-		rejected := 0
-		receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
-
-		for _, event := range events {
-			// Validate required fields
-			if event.Actor == nil || event.Actor["id"] == nil ||
-				event.Action == nil || event.Action["name"] == nil ||
-				event.Resource == nil || event.Resource["type"] == nil || event.Resource["id"] == nil {
-				rejected++
-				results = append(results, BatchEventResponse{
-					EventID: "",
-					Status:  "rejected",
-				})
-				continue
-			}
-
-			eventID := generateUUIDv7()
-			enriched := EnrichedEvent{
-				Event:      event,
-				EventID:    eventID,
-				ReceivedAt: receivedAt,
-			}
-
-			forwardToVector(enriched)
-			accepted++
-			results = append(results, BatchEventResponse{
-				EventID: eventID,
-				Status:  "accepted",
-			})
-		}
+	// OpenTelemetry logs receiver (OTLP/HTTP)
+	app.Post("/v1/logs", otlpLogsHandler)
 
-		return c.Status(202).JSON(BatchResponse{
-			Accepted: accepted,
-			Rejected: rejected,
-			Events:   results,
-		})
-	})
+	// Kubernetes audit webhook backend (audit.k8s.io/v1 EventList)
+	app.Post("/v1/k8s/audit", k8sAuditHandler)
+
+	// Source-control webhooks, one URL per tenant
+	app.Post("/v1/webhooks/github/:tenant", githubWebhookHandler)
+	app.Post("/v1/webhooks/gitlab/:tenant", gitlabWebhookHandler)
+
+	// Identity provider adapters
+	app.Post("/v1/idp/keycloak/:tenant", keycloakHandler)
+	app.Post("/v1/idp/okta/:tenant", oktaHandler)
+	app.Get("/v1/idp/okta/:tenant", oktaVerifyHandler)
+	startOktaPoller()
+
+	// CloudTrail log file importer
+	startCloudTrailImporter()
+
+	// PostgreSQL logical replication source
+	startCDCSource()
+
+	// Syslog listeners run next to the HTTP server when configured
+	startSyslogListeners()
+
+	// gRPC ingestion API runs next to Fiber
+	startGRPCServer()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// vectorRecorder collects the events posted to a fake Vector
+type vectorRecorder struct {
+	mu     sync.Mutex
+	status int
+	events []EnrichedEvent
+}
+
+func (v *vectorRecorder) setStatus(status int) {
+	v.mu.Lock()
+	v.status = status
+	v.mu.Unlock()
+}
+
+func (v *vectorRecorder) received() []EnrichedEvent {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]EnrichedEvent(nil), v.events...)
+}
+
+// fakeVector points vectorURL at a test server for the rest of the test
+func fakeVector(t *testing.T) *vectorRecorder {
+	t.Helper()
+	rec := &vectorRecorder{status: 200}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if rec.status >= 300 {
+			w.WriteHeader(rec.status)
+			return
+		}
+		var batch []EnrichedEvent
+		if err := json.NewDecoder(r.Body).Decode(&batch); err == nil {
+			rec.events = append(rec.events, batch...)
+		}
+		w.WriteHeader(rec.status)
+	}))
+	saved := vectorURL
+	vectorURL = server.URL
+	t.Cleanup(func() {
+		vectorURL = saved
+		server.Close()
+	})
+	return rec
+}
+
+func TestEnrich_IdempotencyKeyIsStable(t *testing.T) {
+	event := Event{TenantID: "shop", IdempotencyKey: "row-1"}
+	first := enrichEvent(event, "")
+	second := enrichEvent(event, "")
+	if first.EventID != second.EventID {
+		t.Errorf("Expected the same event_id for the same key, got %s and %s", first.EventID, second.EventID)
+	}
+
+	other := enrichEvent(Event{TenantID: "bank", IdempotencyKey: "row-1"}, "")
+	if other.EventID == first.EventID {
+		t.Error("Expected idempotency keys to be scoped by tenant")
+	}
+	if a, b := enrichEvent(Event{}, ""), enrichEvent(Event{}, ""); a.EventID == b.EventID {
+		t.Error("Expected fresh event_ids without a key")
+	}
+}
+
+func TestDeliverBatch_ReportsForwardingFailure(t *testing.T) {
+	vector := fakeVector(t)
+	valid := Event{
+		Actor:    map[string]interface{}{"id": "u1"},
+		Action:   map[string]interface{}{"name": "user.login"},
+		Resource: map[string]interface{}{"type": "session", "id": "s1"},
+	}
+
+	resp, err := deliverBatch([]Event{valid, {}}, "2025-01-01T00:00:00Z")
+	if err != nil || resp.Accepted != 1 || resp.Rejected != 1 || len(vector.received()) != 1 {
+		t.Fatalf("Got %+v, %v with %d delivered", resp, err, len(vector.received()))
+	}
+
+	vector.setStatus(503)
+	if _, err := deliverBatch([]Event{valid}, "2025-01-01T00:00:00Z"); err == nil {
+		t.Error("Expected an error when Vector refuses the batch")
+	}
+}
+
+func TestBatchHandler_AcceptsOnlyDeliveredBatches(t *testing.T) {
+	vector := fakeVector(t)
+	app := fiber.New()
+	app.Post("/v1/events/batch", batchHandler)
+	body := `{"events":[{"actor":{"id":"u1"},"action":{"name":"user.login"},"resource":{"type":"session","id":"s1"},"idempotency_key":"row-1","producer":"spoofed"}]}`
+	post := func() int {
+		req := httptest.NewRequest("POST", "/v1/events/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Consumer-Name", "billing")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := post(); got != 202 || len(vector.received()) != 1 {
+		t.Fatalf("Status %d with %d delivered, want 202 after delivery", got, len(vector.received()))
+	}
+	if p := vector.received()[0].Producer; p != "billing" {
+		t.Errorf("Producer = %q, want the consumer", p)
+	}
+	vector.setStatus(503)
+	if got := post(); got != 502 {
+		t.Errorf("Vector down: status %d, want 502", got)
+	}
+}
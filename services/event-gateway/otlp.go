@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+
+	// OTLP severity numbers at or above ERROR mark a failed result
+	otlpSeverityError = 17
+)
+
+// otlpLogRecord is a log record flattened out of an OTLP export request,
+// independent of the wire encoding it arrived in
+type otlpLogRecord struct {
+	TimeUnixNano         uint64
+	ObservedTimeUnixNano uint64
+	SeverityNumber       int32
+	SeverityText         string
+	Body                 interface{}
+	Attributes           map[string]interface{}
+	Resource             map[string]interface{}
+	Scope                string
+	TraceID              string
+	SpanID               string
+}
+
+// otlpAuditAttributes select a log record as an audit event on their own,
+// next to any audit.* attribute
+var otlpAuditAttributes = []string{"enduser.id"}
+
+// otlpLogsHandler implements the OTLP/HTTP logs endpoint. Only records
+// carrying audit attributes are turned into audit events; everything
+// else is acknowledged and ignored.
+func otlpLogsHandler(c *fiber.Ctx) error {
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(c.Get(fiber.HeaderContentType), ";")[0]))
+
+	var records []otlpLogRecord
+	var err error
+	switch contentType {
+	case contentTypeProtobuf:
+		records, err = decodeOTLPProtobuf(c.Body())
+	case contentTypeJSON:
+		records, err = decodeOTLPJSON(c.Body())
+	default:
+		return c.Status(415).JSON(fiber.Map{"error": "Content-Type must be application/x-protobuf or application/json"})
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid OTLP payload: %v", err)})
+	}
+
+	var rejected int64
+	var lastErr string
+	receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+	for _, rec := range records {
+		event, ok := logRecordToEvent(rec)
+		if !ok {
+			continue
+		}
+		event.Producer = producer(c, "otlp")
+		if _, err := ingestEvent(event, receivedAt); err != nil {
+			rejected++
+			lastErr = err.Error()
+		}
+	}
+
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: rejected,
+			ErrorMessage:       lastErr,
+		}
+	}
+
+	var body []byte
+	if contentType == contentTypeProtobuf {
+		body, err = proto.Marshal(resp)
+	} else {
+		body, err = protojson.Marshal(resp)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Status(200).Send(body)
+}
+
+// logRecordToEvent maps an OTLP log record to an audit event using the
+// OpenTelemetry semantic conventions. The second return value is false when
+// the record carries no audit attributes and should be skipped.
+func logRecordToEvent(rec otlpLogRecord) (Event, bool) {
+	if !isAuditRecord(rec.Attributes) {
+		return Event{}, false
+	}
+
+	attrs := rec.Attributes
+	event := Event{
+		Actor:    map[string]interface{}{},
+		Action:   map[string]interface{}{},
+		Resource: map[string]interface{}{},
+		Result:   map[string]interface{}{},
+		Context:  map[string]interface{}{},
+	}
+
+	setFirst(event.Action, "name", attrs, "audit.action")
+	if setFirst(event.Actor, "id", attrs, "audit.actor.id", "enduser.id", "user.id") {
+		event.Actor["type"] = "user"
+	}
+	setFirst(event.Actor, "type", attrs, "audit.actor.type")
+	setFirst(event.Actor, "email", attrs, "audit.actor.email", "user.email")
+	setFirst(event.Actor, "role", attrs, "enduser.role")
+	setFirst(event.Resource, "type", attrs, "audit.resource.type")
+	setFirst(event.Resource, "id", attrs, "audit.resource.id")
+	setFirst(event.Result, "message", attrs, "audit.result.message")
+
+	switch v := attrs["audit.result.success"].(type) {
+	case bool:
+		event.Result["success"] = v
+	case string:
+		event.Result["success"] = v == "true"
+	default:
+		event.Result["success"] = rec.SeverityNumber < otlpSeverityError
+	}
+
+	if tenant, ok := attrs["audit.tenant_id"].(string); ok {
+		event.TenantID = tenant
+	} else if tenant, ok := rec.Resource["tenant.id"].(string); ok {
+		event.TenantID = tenant
+	}
+
+	ts := rec.TimeUnixNano
+	if ts == 0 {
+		ts = rec.ObservedTimeUnixNano
+	}
+	if ts != 0 {
+		event.Timestamp = time.Unix(0, int64(ts)).UTC().Format(time.RFC3339Nano)
+	}
+
+	setFirst(event.Context, "ip", attrs, "client.address", "source.address")
+	setFirst(event.Context, "user_agent", attrs, "user_agent.original")
+	if rec.TraceID != "" {
+		event.Context["trace_id"] = rec.TraceID
+	}
+	if rec.SpanID != "" {
+		event.Context["span_id"] = rec.SpanID
+	}
+	if len(rec.Resource) > 0 {
+		event.Context["resource"] = rec.Resource
+	}
+	if rec.Scope != "" {
+		event.Context["scope"] = rec.Scope
+	}
+	if rec.Body != nil {
+		event.Context["body"] = rec.Body
+	}
+
+	// Remaining attributes are kept so nothing the producer sent is lost
+	extra := map[string]interface{}{}
+	for key, val := range attrs {
+		if strings.HasPrefix(key, "audit.context.") {
+			event.Context[strings.TrimPrefix(key, "audit.context.")] = val
+		} else if !strings.HasPrefix(key, "audit.") {
+			extra[key] = val
+		}
+	}
+	if len(extra) > 0 {
+		event.Context["attributes"] = extra
+	}
+
+	return event, true
+}
+
+// isAuditRecord reports whether attrs carry an audit.* attribute or one
+// of otlpAuditAttributes
+func isAuditRecord(attrs map[string]interface{}) bool {
+	for key := range attrs {
+		if strings.HasPrefix(key, "audit.") {
+			return true
+		}
+	}
+	for _, key := range otlpAuditAttributes {
+		if val, ok := attrs[key]; ok && val != nil && val != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// setFirst copies the first present attribute of keys into dst[field]
+func setFirst(dst map[string]interface{}, field string, attrs map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if val, ok := attrs[key]; ok && val != nil && val != "" {
+			dst[field] = val
+			return true
+		}
+	}
+	return false
+}
+
+// decodeOTLPProtobuf decodes a binary ExportLogsServiceRequest
+func decodeOTLPProtobuf(body []byte) ([]otlpLogRecord, error) {
+	var req collogspb.ExportLogsServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	var records []otlpLogRecord
+	for _, rl := range req.GetResourceLogs() {
+		resource := pbAttributes(rl.GetResource().GetAttributes())
+		for _, sl := range rl.GetScopeLogs() {
+			for _, lr := range sl.GetLogRecords() {
+				records = append(records, pbLogRecord(lr, resource, sl.GetScope().GetName()))
+			}
+		}
+	}
+	return records, nil
+}
+
+func pbLogRecord(lr *logspb.LogRecord, resource map[string]interface{}, scope string) otlpLogRecord {
+	rec := otlpLogRecord{
+		TimeUnixNano:         lr.GetTimeUnixNano(),
+		ObservedTimeUnixNano: lr.GetObservedTimeUnixNano(),
+		SeverityNumber:       int32(lr.GetSeverityNumber()),
+		SeverityText:         lr.GetSeverityText(),
+		Attributes:           pbAttributes(lr.GetAttributes()),
+		Resource:             resource,
+		Scope:                scope,
+	}
+	if lr.GetBody() != nil {
+		rec.Body = pbAnyValue(lr.GetBody())
+	}
+	if id := lr.GetTraceId(); len(id) > 0 {
+		rec.TraceID = hex.EncodeToString(id)
+	}
+	if id := lr.GetSpanId(); len(id) > 0 {
+		rec.SpanID = hex.EncodeToString(id)
+	}
+	return rec
+}
+
+func pbAttributes(kvs []*commonpb.KeyValue) map[string]interface{} {
+	attrs := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.GetKey()] = pbAnyValue(kv.GetValue())
+	}
+	return attrs
+}
+
+func pbAnyValue(v *commonpb.AnyValue) interface{} {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return val.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return val.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return val.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		// Base64 like OTLP/JSON, so both encodings give the same event
+		return base64.StdEncoding.EncodeToString(val.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		list := make([]interface{}, 0, len(val.ArrayValue.GetValues()))
+		for _, item := range val.ArrayValue.GetValues() {
+			list = append(list, pbAnyValue(item))
+		}
+		return list
+	case *commonpb.AnyValue_KvlistValue:
+		return pbAttributes(val.KvlistValue.GetValues())
+	}
+	return nil
+}
+
+// OTLP/JSON differs from the canonical protobuf JSON mapping (trace and span
+// IDs are hex, not base64), so it is decoded with its own wire types.
+type otlpJSONRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpJSONKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			LogRecords []otlpJSONLogRecord `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+type otlpJSONLogRecord struct {
+	TimeUnixNano         json.Number        `json:"timeUnixNano"`
+	ObservedTimeUnixNano json.Number        `json:"observedTimeUnixNano"`
+	SeverityNumber       int32              `json:"severityNumber"`
+	SeverityText         string             `json:"severityText"`
+	Body                 *otlpJSONAnyValue  `json:"body"`
+	Attributes           []otlpJSONKeyValue `json:"attributes"`
+	TraceID              string             `json:"traceId"`
+	SpanID               string             `json:"spanId"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJSONAnyValue `json:"value"`
+}
+
+type otlpJSONAnyValue struct {
+	StringValue *string     `json:"stringValue"`
+	BoolValue   *bool       `json:"boolValue"`
+	IntValue    json.Number `json:"intValue"`
+	DoubleValue *float64    `json:"doubleValue"`
+	BytesValue  *string     `json:"bytesValue"`
+	ArrayValue  *struct {
+		Values []otlpJSONAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpJSONKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+}
+
+// decodeOTLPJSON decodes a JSON-encoded ExportLogsServiceRequest
+func decodeOTLPJSON(body []byte) ([]otlpLogRecord, error) {
+	var req otlpJSONRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	var records []otlpLogRecord
+	for _, rl := range req.ResourceLogs {
+		resource := jsonAttributes(rl.Resource.Attributes)
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				rec := otlpLogRecord{
+					TimeUnixNano:         parseUnixNano(lr.TimeUnixNano),
+					ObservedTimeUnixNano: parseUnixNano(lr.ObservedTimeUnixNano),
+					SeverityNumber:       lr.SeverityNumber,
+					SeverityText:         lr.SeverityText,
+					Attributes:           jsonAttributes(lr.Attributes),
+					Resource:             resource,
+					Scope:                sl.Scope.Name,
+					TraceID:              strings.ToLower(lr.TraceID),
+					SpanID:               strings.ToLower(lr.SpanID),
+				}
+				if lr.Body != nil {
+					rec.Body = jsonAnyValue(*lr.Body)
+				}
+				records = append(records, rec)
+			}
+		}
+	}
+	return records, nil
+}
+
+func jsonAttributes(kvs []otlpJSONKeyValue) map[string]interface{} {
+	attrs := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.Key] = jsonAnyValue(kv.Value)
+	}
+	return attrs
+}
+
+func jsonAnyValue(v otlpJSONAnyValue) interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != "":
+		if n, err := strconv.ParseInt(v.IntValue.String(), 10, 64); err == nil {
+			return n
+		}
+		return v.IntValue.String()
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BytesValue != nil:
+		return *v.BytesValue
+	case v.ArrayValue != nil:
+		list := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			list = append(list, jsonAnyValue(item))
+		}
+		return list
+	case v.KvlistValue != nil:
+		return jsonAttributes(v.KvlistValue.Values)
+	}
+	return nil
+}
+
+// parseUnixNano accepts the nanosecond timestamps as either JSON strings or numbers
+func parseUnixNano(n json.Number) uint64 {
+	if n == "" {
+		return 0
+	}
+	v, err := strconv.ParseUint(n.String(), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func strAttr(key, val string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: val}}}
+}
+
+func TestOTLP_ProtobufMapping(t *testing.T) {
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{strAttr("service.name", "billing"), strAttr("tenant.id", "acme")}},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				LogRecords: []*logspb.LogRecord{
+					{
+						TimeUnixNano: 1700000000000000000,
+						Attributes: []*commonpb.KeyValue{
+							strAttr("audit.action", "invoice.paid"),
+							strAttr("enduser.id", "alice"),
+							strAttr("audit.resource.type", "invoice"),
+							strAttr("audit.resource.id", "inv-1"),
+						},
+						TraceId: []byte{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+						SpanId:  []byte{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+					},
+					{Attributes: []*commonpb.KeyValue{strAttr("http.route", "/health")}},
+				},
+			}},
+		}},
+	}
+	body, _ := proto.Marshal(req)
+
+	records, err := decodeOTLPProtobuf(body)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	event, ok := logRecordToEvent(records[0])
+	if !ok {
+		t.Fatal("Expected audit record to be selected")
+	}
+	if err := validateEvent(event); err != nil {
+		t.Errorf("Expected valid event, got %v", err)
+	}
+	if event.Actor["id"] != "alice" || event.Action["name"] != "invoice.paid" || event.TenantID != "acme" {
+		t.Errorf("Unexpected mapping: %+v", event)
+	}
+	if event.Context["trace_id"] != "0af7651916cd43dd8448eb211c80319c" || event.Context["span_id"] != "b7ad6b7169203331" {
+		t.Errorf("Unexpected trace context: %v", event.Context)
+	}
+	if event.Timestamp != "2023-11-14T22:13:20Z" {
+		t.Errorf("Unexpected timestamp: %s", event.Timestamp)
+	}
+
+	if _, ok := logRecordToEvent(records[1]); ok {
+		t.Error("Expected non-audit record to be skipped")
+	}
+}
+
+func TestOTLP_JSONMapping(t *testing.T) {
+	body := []byte(`{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"iam"}}]},
+		"scopeLogs":[{"scope":{"name":"audit"},"logRecords":[{
+			"timeUnixNano":"1700000000000000000","severityNumber":17,
+			"traceId":"5B8EFFF798038103D269B633813FC60C","spanId":"EEE19B7EC3C1B174",
+			"body":{"stringValue":"login failed"},
+			"attributes":[
+				{"key":"audit.action","value":{"stringValue":"auth.login"}},
+				{"key":"user.id","value":{"stringValue":"bob"}},
+				{"key":"audit.resource.type","value":{"stringValue":"session"}},
+				{"key":"audit.resource.id","value":{"stringValue":"s-1"}},
+				{"key":"client.address","value":{"stringValue":"10.0.0.7"}},
+				{"key":"http.status_code","value":{"intValue":"401"}}
+			]}]}]}]}`)
+
+	records, err := decodeOTLPJSON(body)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	event, ok := logRecordToEvent(records[0])
+	if !ok {
+		t.Fatal("Expected audit record to be selected")
+	}
+	if event.Result["success"] != false {
+		t.Errorf("Expected ERROR severity to map to failure, got %v", event.Result["success"])
+	}
+	if event.Context["ip"] != "10.0.0.7" || event.Context["trace_id"] != "5b8efff798038103d269b633813fc60c" {
+		t.Errorf("Unexpected context: %v", event.Context)
+	}
+	if extra, _ := event.Context["attributes"].(map[string]interface{}); extra["http.status_code"] != int64(401) {
+		t.Errorf("Expected remaining attributes in context, got %v", event.Context["attributes"])
+	}
+}
+
+func TestOTLP_EnduserIDSelectsRecord(t *testing.T) {
+	rec := otlpLogRecord{Attributes: map[string]interface{}{"enduser.id": "carol", "http.route": "/admin"}}
+	event, ok := logRecordToEvent(rec)
+	if !ok || event.Actor["id"] != "carol" {
+		t.Errorf("Expected enduser.id to select the record, got %v %+v", ok, event)
+	}
+}
+
+func TestOTLP_BytesEncodeAlike(t *testing.T) {
+	req := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{Attributes: []*commonpb.KeyValue{
+			{Key: "audit.digest", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: []byte{0xde, 0xad, 0xbe, 0xef}}}},
+		}}}}},
+	}}}
+	body, _ := proto.Marshal(req)
+	pb, err := decodeOTLPProtobuf(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := decodeOTLPJSON([]byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"attributes":[
+		{"key":"audit.digest","value":{"bytesValue":"3q2+7w=="}}]}]}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pb[0].Attributes["audit.digest"] != js[0].Attributes["audit.digest"] {
+		t.Errorf("protobuf %v, JSON %v", pb[0].Attributes["audit.digest"], js[0].Attributes["audit.digest"])
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// scmTenantConfig holds one tenant's webhook secrets and action mapping
+type scmTenantConfig struct {
+	GitHubSecret string `json:"github_secret"`
+	GitLabToken  string `json:"gitlab_token"`
+	// Actions maps "github:<event>.<action>" or "gitlab:<event>.<action>"
+	// (or the same keys without the action suffix) to an action name
+	Actions map[string]string `json:"actions"`
+}
+
+// scmWebhookConfig is loaded from the JSON file named by SCM_WEBHOOK_CONFIG
+type scmWebhookConfig struct {
+	Tenants map[string]scmTenantConfig `json:"tenants"`
+}
+
+var scmWebhooks scmWebhookConfig
+
+func init() {
+	path := os.Getenv("SCM_WEBHOOK_CONFIG")
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading SCM_WEBHOOK_CONFIG: %v", err)
+	}
+	if err := json.Unmarshal(data, &scmWebhooks); err != nil {
+		log.Fatalf("Error parsing SCM_WEBHOOK_CONFIG: %v", err)
+	}
+}
+
+// actionName resolves the configured action for an event type, falling
+// back to "<provider>.<event>.<action>"
+func (cfg scmTenantConfig) actionName(provider, eventType, action string) string {
+	key := provider + ":" + eventType
+	if action != "" {
+		if name, ok := cfg.Actions[key+"."+action]; ok {
+			return name
+		}
+	}
+	if name, ok := cfg.Actions[key]; ok {
+		return name
+	}
+	if action != "" {
+		return provider + "." + eventType + "." + action
+	}
+	return provider + "." + eventType
+}
+
+// verifyGitHubSignature checks X-Hub-Signature-256 against the raw body
+func verifyGitHubSignature(secret string, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok || secret == "" {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// githubWebhookHandler receives GitHub webhook deliveries for a tenant. An
+// unknown tenant has no secret and fails the signature check like a wrong
+// one, so responses do not reveal which tenants exist.
+func githubWebhookHandler(c *fiber.Ctx) error {
+	tenant := c.Params("tenant")
+	cfg := scmWebhooks.Tenants[tenant]
+	if !verifyGitHubSignature(cfg.GitHubSecret, c.Body(), c.Get("X-Hub-Signature-256")) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid X-Hub-Signature-256"})
+	}
+
+	eventType := c.Get("X-GitHub-Event")
+	if eventType == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "X-GitHub-Event header is required"})
+	}
+	if eventType == "ping" {
+		return c.Status(200).JSON(fiber.Map{"status": "pong"})
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(c.Body(), &payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	}
+
+	event := githubToEvent(cfg, eventType, c.Get("X-GitHub-Delivery"), payload)
+	event.TenantID = tenant
+	event.Producer = producer(c, "github")
+	return respondWebhook(c, event)
+}
+
+// githubToEvent normalizes a GitHub payload into an audit event
+func githubToEvent(cfg scmTenantConfig, eventType, deliveryID string, payload map[string]interface{}) Event {
+	action, _ := payload["action"].(string)
+	event := Event{
+		Actor:    map[string]interface{}{},
+		Action:   map[string]interface{}{"name": cfg.actionName("github", eventType, action)},
+		Resource: map[string]interface{}{},
+		Result:   map[string]interface{}{"success": true},
+		Context: map[string]interface{}{
+			"source":      "github",
+			"event_type":  eventType,
+			"delivery_id": deliveryID,
+			"payload":     payload,
+		},
+		// Redeliveries keep their X-GitHub-Delivery and so their event_id
+		IdempotencyKey: deliveryID,
+	}
+
+	if sender, ok := payload["sender"].(map[string]interface{}); ok {
+		putString(event.Actor, "id", stringField(sender, "login"))
+		if stringField(sender, "type") == "Bot" {
+			event.Actor["type"] = "bot"
+		} else {
+			event.Actor["type"] = "user"
+		}
+	}
+
+	if repo, ok := payload["repository"].(map[string]interface{}); ok {
+		event.Resource["type"] = "repository"
+		putString(event.Resource, "id", stringField(repo, "full_name"))
+	} else if org, ok := payload["organization"].(map[string]interface{}); ok {
+		event.Resource["type"] = "organization"
+		putString(event.Resource, "id", stringField(org, "login"))
+	} else if hook, ok := payload["hook"].(map[string]interface{}); ok {
+		event.Resource["type"] = "webhook"
+		event.Resource["id"] = fmt.Sprintf("%v", hook["id"])
+	}
+
+	// Name the changed object so it is searchable without parsing the payload
+	for _, key := range []string{"member", "key", "rule", "team", "ref"} {
+		switch val := payload[key].(type) {
+		case map[string]interface{}:
+			for _, name := range []string{"login", "title", "name"} {
+				if s := stringField(val, name); s != "" {
+					event.Context[key] = s
+					break
+				}
+			}
+		case string:
+			event.Context[key] = val
+		}
+	}
+	return event
+}
+
+// gitlabWebhookHandler receives GitLab project, group and system hooks for
+// a tenant. As for GitHub, an unknown tenant is an invalid token.
+func gitlabWebhookHandler(c *fiber.Ctx) error {
+	tenant := c.Params("tenant")
+	cfg := scmWebhooks.Tenants[tenant]
+	token := c.Get("X-Gitlab-Token")
+	if !tokenMatches(token, cfg.GitLabToken) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid X-Gitlab-Token"})
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(c.Body(), &payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	}
+
+	event := gitlabToEvent(cfg, c.Get("X-Gitlab-Event"), c.Get("X-Gitlab-Event-UUID"), payload)
+	event.TenantID = tenant
+	event.Producer = producer(c, "gitlab")
+	return respondWebhook(c, event)
+}
+
+// gitlabToEvent normalizes a GitLab payload into an audit event. System
+// hooks carry event_name; project and group hooks carry object_kind.
+func gitlabToEvent(cfg scmTenantConfig, hookName, deliveryID string, payload map[string]interface{}) Event {
+	eventType := stringField(payload, "event_name")
+	if eventType == "" {
+		eventType = stringField(payload, "object_kind")
+	}
+	attrs, _ := payload["object_attributes"].(map[string]interface{})
+	action := stringField(attrs, "action")
+
+	event := Event{
+		Actor:    map[string]interface{}{"type": "user"},
+		Action:   map[string]interface{}{"name": cfg.actionName("gitlab", eventType, action)},
+		Resource: map[string]interface{}{},
+		Result:   map[string]interface{}{"success": true},
+		Context: map[string]interface{}{
+			"source":     "gitlab",
+			"event_type": eventType,
+			"hook":       hookName,
+			"payload":    payload,
+		},
+		// Retried hooks keep their X-Gitlab-Event-UUID and so their event_id
+		IdempotencyKey: deliveryID,
+	}
+	putString(event.Context, "delivery_id", deliveryID)
+
+	if user, ok := payload["user"].(map[string]interface{}); ok {
+		putString(event.Actor, "id", stringField(user, "username"))
+		putString(event.Actor, "email", stringField(user, "email"))
+	}
+	if _, ok := event.Actor["id"]; !ok {
+		putString(event.Actor, "id", stringField(payload, "user_username"))
+	}
+	if _, ok := event.Actor["email"]; !ok {
+		putString(event.Actor, "email", stringField(payload, "user_email"))
+	}
+
+	if project, ok := payload["project"].(map[string]interface{}); ok {
+		event.Resource["type"] = "project"
+		putString(event.Resource, "id", stringField(project, "path_with_namespace"))
+	} else if path := stringField(payload, "path_with_namespace"); path != "" {
+		event.Resource["type"] = "project"
+		event.Resource["id"] = path
+	} else if group := stringField(payload, "group_path"); group != "" {
+		event.Resource["type"] = "group"
+		event.Resource["id"] = group
+	} else if key := stringField(payload, "key"); key != "" && payload["id"] != nil {
+		event.Resource["type"] = "ssh_key"
+		event.Resource["id"] = fmt.Sprintf("%v", payload["id"])
+	}
+
+	if ts := stringField(payload, "created_at"); ts != "" {
+		if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+			event.Timestamp = parsed.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return event
+}
+
+// respondWebhook ingests a normalized webhook event. It is forwarded
+// synchronously so that a failed delivery is a 502, which GitHub and
+// GitLab retry.
+func respondWebhook(c *fiber.Ctx, event Event) error {
+	receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	resp, err := deliverBatch([]Event{event}, receivedAt)
+	if err != nil {
+		log.Printf("Forwarding webhook event: %v", err)
+		return c.Status(502).JSON(fiber.Map{"error": "forwarding to Vector failed"})
+	}
+	if resp.Rejected > 0 {
+		return c.Status(422).JSON(fiber.Map{"error": resp.Events[0].Error})
+	}
+	return c.Status(202).JSON(SingleResponse{
+		EventID:    resp.Events[0].EventID,
+		ReceivedAt: receivedAt,
+	})
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSCM_GitHubSignature(t *testing.T) {
+	body := []byte(`{"action":"created"}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	header := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !verifyGitHubSignature("s3cret", body, header) {
+		t.Error("Expected valid signature to verify")
+	}
+	if verifyGitHubSignature("other", body, header) {
+		t.Error("Expected signature with wrong secret to fail")
+	}
+	if verifyGitHubSignature("", body, header) {
+		t.Error("Expected empty secret to fail closed")
+	}
+}
+
+func TestSCM_GitHubDeployKeyMapping(t *testing.T) {
+	var payload map[string]interface{}
+	json.Unmarshal([]byte(`{"action":"created","key":{"id":1,"title":"ci-deploy"},
+		"repository":{"full_name":"acme/api"},"sender":{"login":"octocat","type":"User"}}`), &payload)
+
+	cfg := scmTenantConfig{Actions: map[string]string{"github:deploy_key.created": "repo.deploy_key.added"}}
+	event := githubToEvent(cfg, "deploy_key", "d-1", payload)
+	if err := validateEvent(event); err != nil {
+		t.Fatalf("Expected valid event, got %v", err)
+	}
+	if event.Action["name"] != "repo.deploy_key.added" {
+		t.Errorf("Expected configured action, got %v", event.Action["name"])
+	}
+	if event.Actor["id"] != "octocat" || event.Resource["id"] != "acme/api" || event.Context["key"] != "ci-deploy" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+
+	event = githubToEvent(scmTenantConfig{}, "branch_protection_rule", "d-2", payload)
+	if event.Action["name"] != "github.branch_protection_rule.created" {
+		t.Errorf("Expected default action, got %v", event.Action["name"])
+	}
+}
+
+func TestSCM_GitLabSystemHookMapping(t *testing.T) {
+	var payload map[string]interface{}
+	json.Unmarshal([]byte(`{"event_name":"user_add_to_team","created_at":"2025-12-20T10:00:00Z",
+		"path_with_namespace":"acme/web","user_username":"jdoe","user_email":"jdoe@example.com","access_level":"Maintainer"}`), &payload)
+
+	event := gitlabToEvent(scmTenantConfig{}, "System Hook", "uuid-1", payload)
+	if err := validateEvent(event); err != nil {
+		t.Fatalf("Expected valid event, got %v", err)
+	}
+	if event.Action["name"] != "gitlab.user_add_to_team" || event.Actor["id"] != "jdoe" || event.Resource["id"] != "acme/web" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Context["payload"] == nil {
+		t.Error("Expected original payload in context")
+	}
+	if event.IdempotencyKey != "uuid-1" || event.Context["delivery_id"] != "uuid-1" {
+		t.Errorf("Expected the event UUID as idempotency key, got %q", event.IdempotencyKey)
+	}
+}
+
+func TestSCM_GitHubDeliveries(t *testing.T) {
+	vector := fakeVector(t)
+	saved := scmWebhooks
+	scmWebhooks = scmWebhookConfig{Tenants: map[string]scmTenantConfig{"acme": {GitHubSecret: "s3cret"}}}
+	defer func() { scmWebhooks = saved }()
+
+	app := fiber.New()
+	app.Post("/v1/webhooks/github/:tenant", githubWebhookHandler)
+	body := `{"action":"created","repository":{"full_name":"acme/api"},"sender":{"login":"octocat"}}`
+	post := func(tenant, secret string) int {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req := httptest.NewRequest("POST", "/v1/webhooks/github/"+tenant, strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		req.Header.Set("X-GitHub-Event", "deploy_key")
+		req.Header.Set("X-GitHub-Delivery", "d-1")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := post("other", "s3cret"); got != 401 {
+		t.Errorf("Unknown tenant: status %d, want 401 like a bad signature", got)
+	}
+	if got := post("acme", "wrong"); got != 401 {
+		t.Errorf("Bad signature: status %d, want 401", got)
+	}
+	if post("acme", "s3cret") != 202 || post("acme", "s3cret") != 202 {
+		t.Fatal("Expected deliveries to be accepted")
+	}
+	if got := vector.received(); len(got) != 2 || got[0].EventID != got[1].EventID {
+		t.Errorf("Expected a redelivery to keep its event_id, got %+v", got)
+	}
+	vector.setStatus(503)
+	if got := post("acme", "s3cret"); got != 502 {
+		t.Errorf("Vector down: status %d, want 502", got)
+	}
+}
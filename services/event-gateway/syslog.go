@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const syslogMaxMessageSize = 64 * 1024
+
+// syslogConfig holds the syslog listener configuration
+type syslogConfig struct {
+	UDPAddr     string
+	TCPAddr     string
+	TLSCertFile string
+	TLSKeyFile  string
+	UDPTenant   string
+	TCPTenant   string
+	TenantCIDRs []tenantCIDR
+}
+
+// tenantCIDR binds every sender inside a network to a tenant
+type tenantCIDR struct {
+	Network *net.IPNet
+	Tenant  string
+}
+
+// syslogMessage is a parsed RFC 5424 or RFC 3164 message
+type syslogMessage struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+var (
+	sshdAccepted = regexp.MustCompile(`^Accepted (\S+) for (\S+) from (\S+) port (\d+)`)
+	sshdFailed   = regexp.MustCompile(`^Failed (\S+) for (?:invalid user )?(\S+) from (\S+) port (\d+)`)
+	sshdInvalid  = regexp.MustCompile(`^Invalid user (\S*) from (\S+)`)
+	sudoCommand  = regexp.MustCompile(`^\s*(\S+) : (.*?)COMMAND=(.*)$`)
+)
+
+// loadSyslogConfig reads the listener configuration from the environment.
+// SYSLOG_TENANT_CIDRS takes a comma-separated list of cidr=tenant pairs.
+func loadSyslogConfig() (syslogConfig, error) {
+	cfg := syslogConfig{
+		UDPAddr:     os.Getenv("SYSLOG_UDP_ADDR"),
+		TCPAddr:     os.Getenv("SYSLOG_TCP_ADDR"),
+		TLSCertFile: os.Getenv("SYSLOG_TLS_CERT_FILE"),
+		TLSKeyFile:  os.Getenv("SYSLOG_TLS_KEY_FILE"),
+		UDPTenant:   os.Getenv("SYSLOG_UDP_TENANT"),
+		TCPTenant:   os.Getenv("SYSLOG_TCP_TENANT"),
+	}
+
+	for _, pair := range strings.Split(os.Getenv("SYSLOG_TENANT_CIDRS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		cidr, tenant, ok := strings.Cut(pair, "=")
+		if !ok {
+			return cfg, fmt.Errorf("invalid SYSLOG_TENANT_CIDRS entry %q", pair)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SYSLOG_TENANT_CIDRS entry %q: %v", pair, err)
+		}
+		cfg.TenantCIDRs = append(cfg.TenantCIDRs, tenantCIDR{Network: network, Tenant: strings.TrimSpace(tenant)})
+	}
+	return cfg, nil
+}
+
+// tenantFor resolves the tenant for a sender. The most specific matching
+// CIDR wins; otherwise the listener's tenant applies.
+func (cfg syslogConfig) tenantFor(remote net.Addr, listenerTenant string) string {
+	var ip net.IP
+	switch addr := remote.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+
+	tenant := listenerTenant
+	bestPrefix := -1
+	if ip != nil {
+		for _, tc := range cfg.TenantCIDRs {
+			if ones, _ := tc.Network.Mask.Size(); tc.Network.Contains(ip) && ones > bestPrefix {
+				tenant = tc.Tenant
+				bestPrefix = ones
+			}
+		}
+	}
+	return tenant
+}
+
+// startSyslogListeners starts the UDP and TCP/TLS listeners that are configured
+func startSyslogListeners() {
+	cfg, err := loadSyslogConfig()
+	if err != nil {
+		log.Fatalf("Syslog configuration error: %v", err)
+	}
+
+	if cfg.UDPAddr != "" {
+		pc, err := net.ListenPacket("udp", cfg.UDPAddr)
+		if err != nil {
+			log.Fatalf("Syslog UDP listener failed: %v", err)
+		}
+		log.Printf("Syslog UDP listener on %s", cfg.UDPAddr)
+		go serveSyslogUDP(pc, cfg)
+	}
+
+	if cfg.TCPAddr != "" {
+		var ln net.Listener
+		if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+			if err != nil {
+				log.Fatalf("Syslog TLS certificate error: %v", err)
+			}
+			ln, err = tls.Listen("tcp", cfg.TCPAddr, &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   tls.VersionTLS12,
+			})
+			if err != nil {
+				log.Fatalf("Syslog TLS listener failed: %v", err)
+			}
+			log.Printf("Syslog TLS listener on %s", cfg.TCPAddr)
+		} else {
+			ln, err = net.Listen("tcp", cfg.TCPAddr)
+			if err != nil {
+				log.Fatalf("Syslog TCP listener failed: %v", err)
+			}
+			log.Printf("Syslog TCP listener on %s", cfg.TCPAddr)
+		}
+		go serveSyslogTCP(ln, cfg)
+	}
+}
+
+func serveSyslogUDP(pc net.PacketConn, cfg syslogConfig) {
+	buf := make([]byte, syslogMaxMessageSize)
+	for {
+		n, remote, err := pc.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Syslog UDP read error: %v", err)
+			continue
+		}
+		line := strings.TrimRight(string(buf[:n]), "\r\n\x00")
+		processSyslogLine(line, remote, cfg.tenantFor(remote, cfg.UDPTenant), "syslog-udp")
+	}
+}
+
+func serveSyslogTCP(ln net.Listener, cfg syslogConfig) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Syslog TCP accept error: %v", err)
+			continue
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			remote := conn.RemoteAddr()
+			tenant := cfg.tenantFor(remote, cfg.TCPTenant)
+			reader := bufio.NewReaderSize(conn, syslogMaxMessageSize)
+			for {
+				line, err := readSyslogFrame(reader)
+				if line != "" {
+					processSyslogLine(line, remote, tenant, "syslog-tcp")
+				}
+				if err != nil {
+					if !errors.Is(err, io.EOF) {
+						log.Printf("Syslog TCP read error from %s: %v", remote, err)
+					}
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+// readSyslogFrame reads one message from a stream using either octet
+// counting or newline-delimited framing (RFC 6587)
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		lenStr, err := r.ReadString(' ')
+		if err != nil {
+			return "", err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+		if err != nil || n <= 0 || n > syslogMaxMessageSize {
+			return "", fmt.Errorf("invalid octet count %q", lenStr)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	}
+
+	// Newline framing is bounded by the same maximum as octet counting
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > syslogMaxMessageSize {
+			return "", fmt.Errorf("message longer than %d bytes", syslogMaxMessageSize)
+		}
+		line = append(line, chunk...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return strings.TrimRight(string(line), "\r\n"), err
+		}
+	}
+}
+
+// processSyslogLine parses a line and forwards either the event or a rejected record
+func processSyslogLine(line string, remote net.Addr, tenant, source string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	now := time.Now().UTC()
+	receivedAt := now.Format(time.RFC3339Nano)
+
+	reject := func(reason string) {
+		forwardRejected(RejectedRecord{
+			Source:     source,
+			Reason:     reason,
+			Raw:        line,
+			RemoteAddr: remote.String(),
+			TenantID:   tenant,
+			ReceivedAt: receivedAt,
+		})
+	}
+
+	msg, err := parseSyslog(line, now)
+	if err != nil {
+		reject(err.Error())
+		return
+	}
+
+	event, ok := syslogToEvent(msg)
+	if !ok {
+		reject("no audit mapping for message")
+		return
+	}
+	event.TenantID = tenant
+	event.Producer = "syslog"
+	event.Context["remote_addr"] = remote.String()
+
+	if _, err := ingestEvent(event, receivedAt); err != nil {
+		reject(err.Error())
+	}
+}
+
+// parseSyslog parses an RFC 5424 message, falling back to RFC 3164
+func parseSyslog(line string, now time.Time) (syslogMessage, error) {
+	var msg syslogMessage
+	if !strings.HasPrefix(line, "<") {
+		return msg, errors.New("missing PRI")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return msg, errors.New("invalid PRI")
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return msg, errors.New("invalid PRI")
+	}
+	msg.Facility = pri / 8
+	msg.Severity = pri % 8
+	rest := line[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		return parseSyslog5424(msg, rest[2:])
+	}
+	return parseSyslog3164(msg, rest, now)
+}
+
+func parseSyslog5424(msg syslogMessage, rest string) (syslogMessage, error) {
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		return msg, errors.New("truncated RFC 5424 header")
+	}
+
+	if fields[0] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return msg, fmt.Errorf("invalid timestamp %q", fields[0])
+		}
+		msg.Timestamp = ts.UTC()
+	}
+	msg.Hostname = nilValue(fields[1])
+	msg.AppName = nilValue(fields[2])
+	msg.ProcID = nilValue(fields[3])
+	msg.MsgID = nilValue(fields[4])
+
+	sd, text, err := parseStructuredData(fields[5])
+	if err != nil {
+		return msg, err
+	}
+	msg.StructuredData = sd
+	msg.Message = strings.TrimPrefix(text, "\ufeff")
+	return msg, nil
+}
+
+func parseSyslog3164(msg syslogMessage, rest string, now time.Time) (syslogMessage, error) {
+	// Mmm dd hh:mm:ss has a fixed width of 15 characters
+	if len(rest) < 16 {
+		return msg, errors.New("truncated RFC 3164 header")
+	}
+	ts, err := time.Parse(time.Stamp, rest[:15])
+	if err != nil {
+		return msg, fmt.Errorf("invalid timestamp %q", rest[:15])
+	}
+	// RFC 3164 carries no year; assume the current one unless that puts the message in the future
+	ts = time.Date(now.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, time.UTC)
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	msg.Timestamp = ts
+
+	host, text, ok := strings.Cut(strings.TrimLeft(rest[15:], " "), " ")
+	if !ok {
+		return msg, errors.New("missing hostname")
+	}
+	msg.Hostname = host
+
+	// TAG[PID]: MSG
+	if tag, body, ok := strings.Cut(text, ": "); ok && !strings.ContainsAny(tag, " ") {
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			msg.AppName = tag[:open]
+			msg.ProcID = tag[open+1 : len(tag)-1]
+		} else {
+			msg.AppName = tag
+		}
+		text = body
+	}
+	msg.Message = text
+	return msg, nil
+}
+
+// parseStructuredData parses the SD section of an RFC 5424 message and
+// returns the remaining free-form message
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	sd := map[string]map[string]string{}
+	if strings.HasPrefix(s, "-") {
+		return sd, strings.TrimPrefix(strings.TrimPrefix(s, "-"), " "), nil
+	}
+
+	for strings.HasPrefix(s, "[") {
+		end := strings.IndexAny(s, " ]")
+		if end < 0 {
+			return nil, "", errors.New("unterminated structured data")
+		}
+		id := s[1:end]
+		params := map[string]string{}
+		s = s[end:]
+
+		for {
+			s = strings.TrimLeft(s, " ")
+			if strings.HasPrefix(s, "]") {
+				s = s[1:]
+				break
+			}
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return nil, "", fmt.Errorf("invalid structured data param in %q", id)
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if s[i] == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, "", fmt.Errorf("unterminated value for %s in %q", name, id)
+			}
+			params[name] = value.String()
+		}
+		sd[id] = params
+	}
+
+	return sd, strings.TrimPrefix(s, " "), nil
+}
+
+// syslogToEvent maps a message to an audit event. Structured data under an
+// "audit" SD-ID wins; otherwise sshd and sudo messages are recognised.
+func syslogToEvent(msg syslogMessage) (Event, bool) {
+	event := Event{
+		Actor:    map[string]interface{}{},
+		Action:   map[string]interface{}{},
+		Resource: map[string]interface{}{"type": "host", "id": msg.Hostname},
+		Result:   map[string]interface{}{"success": true},
+		Context: map[string]interface{}{
+			"hostname": msg.Hostname,
+			"app_name": msg.AppName,
+			"facility": msg.Facility,
+			"severity": msg.Severity,
+			"message":  msg.Message,
+		},
+	}
+	if !msg.Timestamp.IsZero() {
+		event.Timestamp = msg.Timestamp.Format(time.RFC3339Nano)
+	}
+	if msg.ProcID != "" {
+		event.Context["proc_id"] = msg.ProcID
+	}
+	if msg.MsgID != "" {
+		event.Context["msg_id"] = msg.MsgID
+	}
+	if len(msg.StructuredData) > 0 {
+		event.Context["structured_data"] = msg.StructuredData
+	}
+
+	for id, params := range msg.StructuredData {
+		if id != "audit" && !strings.HasPrefix(id, "audit@") {
+			continue
+		}
+		for key, field := range map[string][2]string{
+			"actor":         {"actor", "id"},
+			"actor_type":    {"actor", "type"},
+			"actor_email":   {"actor", "email"},
+			"action":        {"action", "name"},
+			"resource_type": {"resource", "type"},
+			"resource_id":   {"resource", "id"},
+			"message":       {"result", "message"},
+		} {
+			if val, ok := params[key]; ok {
+				eventSection(&event, field[0])[field[1]] = val
+			}
+		}
+		if val, ok := params["success"]; ok {
+			event.Result["success"] = val == "true"
+		}
+		return event, true
+	}
+
+	switch msg.AppName {
+	case "sshd":
+		if m := sshdAccepted.FindStringSubmatch(msg.Message); m != nil {
+			event.Actor["id"], event.Actor["type"] = m[2], "user"
+			event.Action["name"] = "auth.login"
+			event.Context["method"], event.Context["ip"], event.Context["port"] = m[1], m[3], m[4]
+			return event, true
+		}
+		if m := sshdFailed.FindStringSubmatch(msg.Message); m != nil {
+			event.Actor["id"], event.Actor["type"] = m[2], "user"
+			event.Action["name"] = "auth.login"
+			event.Result["success"] = false
+			event.Result["message"] = "Failed " + m[1]
+			event.Context["method"], event.Context["ip"], event.Context["port"] = m[1], m[3], m[4]
+			return event, true
+		}
+		if m := sshdInvalid.FindStringSubmatch(msg.Message); m != nil {
+			event.Actor["id"], event.Actor["type"] = m[1], "user"
+			event.Action["name"] = "auth.login"
+			event.Result["success"] = false
+			event.Result["message"] = "Invalid user"
+			event.Context["ip"] = m[2]
+			return event, true
+		}
+	case "sudo":
+		if m := sudoCommand.FindStringSubmatch(msg.Message); m != nil {
+			event.Actor["id"], event.Actor["type"] = m[1], "user"
+			event.Action["name"] = "sudo.command"
+			event.Context["command"] = strings.TrimSpace(m[3])
+			for _, part := range strings.Split(m[2], ";") {
+				part = strings.TrimSpace(part)
+				if key, val, ok := strings.Cut(part, "="); ok {
+					switch key {
+					case "TTY":
+						event.Context["tty"] = val
+					case "PWD":
+						event.Context["pwd"] = val
+					case "USER":
+						event.Context["run_as"] = val
+					}
+				} else if part != "" {
+					// sudo prefixes denials with the reason, e.g. "command not allowed"
+					event.Result["success"] = false
+					event.Result["message"] = part
+				}
+			}
+			return event, true
+		}
+	}
+
+	return event, false
+}
+
+func eventSection(event *Event, name string) map[string]interface{} {
+	switch name {
+	case "actor":
+		return event.Actor
+	case "action":
+		return event.Action
+	case "resource":
+		return event.Resource
+	}
+	return event.Result
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
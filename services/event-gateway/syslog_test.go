@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var syslogNow = time.Date(2025, 12, 20, 12, 0, 0, 0, time.UTC)
+
+func TestSyslog_RFC3164Sshd(t *testing.T) {
+	msg, err := parseSyslog("<38>Dec 20 10:15:02 bastion-1 sshd[4242]: Failed password for invalid user admin from 203.0.113.9 port 51422 ssh2", syslogNow)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if msg.Hostname != "bastion-1" || msg.AppName != "sshd" || msg.ProcID != "4242" {
+		t.Errorf("Unexpected header: %+v", msg)
+	}
+
+	event, ok := syslogToEvent(msg)
+	if !ok {
+		t.Fatal("Expected sshd message to be mapped")
+	}
+	if event.Actor["id"] != "admin" || event.Action["name"] != "auth.login" || event.Result["success"] != false {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Context["ip"] != "203.0.113.9" {
+		t.Errorf("Expected source ip in context, got %v", event.Context["ip"])
+	}
+}
+
+func TestSyslog_RFC3164SudoDenied(t *testing.T) {
+	msg, err := parseSyslog("<85>Dec 20 10:16:00 web-2 sudo:      bob : command not allowed ; TTY=pts/1 ; PWD=/home/bob ; USER=root ; COMMAND=/bin/cat /etc/shadow", syslogNow)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	event, ok := syslogToEvent(msg)
+	if !ok {
+		t.Fatal("Expected sudo message to be mapped")
+	}
+	if event.Actor["id"] != "bob" || event.Action["name"] != "sudo.command" || event.Result["success"] != false {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Context["command"] != "/bin/cat /etc/shadow" || event.Context["run_as"] != "root" {
+		t.Errorf("Unexpected context: %v", event.Context)
+	}
+}
+
+func TestSyslog_RFC5424StructuredData(t *testing.T) {
+	line := `<134>1 2025-12-20T10:20:00.123Z fw-01 panos - CONFIG [audit@32473 actor="netops" action="firewall.rule.updated" resource_type="rule" resource_id="r-\"7\"" success="true"] rule changed`
+	msg, err := parseSyslog(line, syslogNow)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if msg.MsgID != "CONFIG" || msg.Message != "rule changed" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+	event, ok := syslogToEvent(msg)
+	if !ok {
+		t.Fatal("Expected audit structured data to be mapped")
+	}
+	if err := validateEvent(event); err != nil {
+		t.Errorf("Expected valid event, got %v", err)
+	}
+	if event.Resource["id"] != `r-"7"` || event.Timestamp != "2025-12-20T10:20:00.123Z" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestSyslog_Unparseable(t *testing.T) {
+	if _, err := parseSyslog("not a syslog line", syslogNow); err == nil {
+		t.Error("Expected error for line without PRI")
+	}
+	msg, err := parseSyslog("<13>Dec 20 10:00:00 host cron[1]: job started", syslogNow)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if _, ok := syslogToEvent(msg); ok {
+		t.Error("Expected unrecognised message to have no audit mapping")
+	}
+}
+
+func TestSyslog_FramingAndTenant(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 <13>1 - - -<13>second line\n"))
+	first, err := readSyslogFrame(r)
+	if err != nil || first != "<13>1 - - -" {
+		t.Errorf("Unexpected octet-counted frame %q (%v)", first, err)
+	}
+	second, err := readSyslogFrame(r)
+	if err != nil || second != "<13>second line" {
+		t.Errorf("Unexpected newline frame %q (%v)", second, err)
+	}
+
+	_, wide, _ := net.ParseCIDR("10.0.0.0/8")
+	_, narrow, _ := net.ParseCIDR("10.1.0.0/16")
+	cfg := syslogConfig{TenantCIDRs: []tenantCIDR{{Network: wide, Tenant: "corp"}, {Network: narrow, Tenant: "lab"}}}
+	if got := cfg.tenantFor(&net.UDPAddr{IP: net.ParseIP("10.1.2.3")}, "default"); got != "lab" {
+		t.Errorf("Expected most specific CIDR tenant, got %s", got)
+	}
+	if got := cfg.tenantFor(&net.UDPAddr{IP: net.ParseIP("192.168.1.1")}, "default"); got != "default" {
+		t.Errorf("Expected listener tenant, got %s", got)
+	}
+}
+
+func TestSyslog_NewlineFrameIsBounded(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(strings.Repeat("x", syslogMaxMessageSize+1) + "\n"))
+	if _, err := readSyslogFrame(r); err == nil {
+		t.Error("Expected an error for a line over the maximum size")
+	}
+}
+
+func TestSyslog_UDPStopsWhenClosed(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		serveSyslogUDP(pc, syslogConfig{})
+		close(done)
+	}()
+	pc.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("UDP loop kept running after close")
+	}
+}
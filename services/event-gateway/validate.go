@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// storageTTL mirrors the audit.events TTL; older events expire on arrival
+const storageTTL = 90 * 24 * time.Hour
+
+// knownEventFields are the top-level fields an Event carries
+var knownEventFields = map[string]bool{
+	"actor": true, "action": true, "resource": true, "timestamp": true,
+	"result": true, "context": true, "tenant_id": true, "idempotency_key": true,
+}
+
+// ValidationResult is the dry-run outcome for one event
+type ValidationResult struct {
+	Index    int            `json:"index"`
+	Valid    bool           `json:"valid"`
+	Error    string         `json:"error,omitempty"`
+	Event    *EnrichedEvent `json:"event,omitempty"`
+	Warnings []string       `json:"warnings"`
+}
+
+// ValidationResponse is the dry-run outcome for a batch
+type ValidationResponse struct {
+	Valid   int                `json:"valid"`
+	Invalid int                `json:"invalid"`
+	Results []ValidationResult `json:"results"`
+}
+
+// validateHandler runs the ingestion pipeline without forwarding. A single
+// event gets its ValidationResult; an array or {events: [...]} gets a
+// ValidationResponse. The event_id is only stable across calls when the
+// event carries an idempotency_key.
+func validateHandler(c *fiber.Ctx) error {
+	body := bytes.TrimSpace(c.Body())
+	receivedAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var raws []json.RawMessage
+	single := false
+	switch {
+	case bytes.HasPrefix(body, []byte("[")):
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+	default:
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if events, ok := envelope["events"]; ok {
+			if err := json.Unmarshal(events, &raws); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON: events must be an array"})
+			}
+		} else {
+			raws, single = []json.RawMessage{body}, true
+		}
+	}
+
+	if !single && len(raws) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "No events provided"})
+	}
+	if len(raws) > maxBatchSize {
+		return c.Status(400).JSON(fiber.Map{"error": "Maximum 1000 events per batch"})
+	}
+
+	resp := ValidationResponse{Results: make([]ValidationResult, 0, len(raws))}
+	for i, raw := range raws {
+		result := dryRunEvent(raw, receivedAt)
+		result.Index = i
+		if result.Valid {
+			resp.Valid++
+		} else {
+			resp.Invalid++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	if single {
+		status := 200
+		if !resp.Results[0].Valid {
+			status = 422
+		}
+		return c.Status(status).JSON(resp.Results[0])
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// dryRunEvent decodes and prepares one event and collects warnings about
+// what the pipeline would change or drop
+func dryRunEvent(raw json.RawMessage, receivedAt string) ValidationResult {
+	result := ValidationResult{Warnings: []string{}}
+
+	var fields map[string]json.RawMessage
+	var event Event
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		result.Error = "Invalid JSON: expected an object"
+		return result
+	}
+	if err := json.Unmarshal(raw, &event); err != nil {
+		result.Error = "Invalid JSON: " + err.Error()
+		return result
+	}
+	for name := range fields {
+		if !knownEventFields[name] {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("unknown field %q is ignored", name))
+		}
+	}
+
+	enriched, err := prepareEvent(event, receivedAt)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Valid = true
+	result.Event = &enriched
+	result.Warnings = append(result.Warnings, eventWarnings(event, enriched, receivedAt)...)
+	return result
+}
+
+// eventWarnings reports accepted input that is probably not what the
+// producer meant
+func eventWarnings(event Event, enriched EnrichedEvent, receivedAt string) []string {
+	var warnings []string
+	if name, ok := event.Action["name"].(string); !ok {
+		warnings = append(warnings, "action.name is not a string")
+	} else if name != enriched.Action["name"] {
+		warnings = append(warnings, fmt.Sprintf("action.name is normalized to %q", enriched.Action["name"]))
+	}
+	if event.TenantID == "" {
+		warnings = append(warnings, fmt.Sprintf("tenant_id is missing; %q is used", defaultTenant))
+	}
+	if _, ok := event.Actor["type"]; !ok {
+		warnings = append(warnings, "actor.type is missing")
+	}
+	if event.Result == nil || event.Result["success"] == nil {
+		warnings = append(warnings, "result.success is missing; the event is stored as successful")
+	} else if _, ok := event.Result["success"].(bool); !ok {
+		warnings = append(warnings, "result.success is not a boolean")
+	}
+
+	received, _ := time.Parse(time.RFC3339Nano, receivedAt)
+	if event.Timestamp == "" {
+		warnings = append(warnings, "timestamp is missing; received_at determines event_date")
+	} else if ts, err := time.Parse(time.RFC3339Nano, event.Timestamp); err != nil {
+		warnings = append(warnings, "timestamp is not RFC 3339; received_at determines event_date")
+	} else if ts.After(received.Add(5 * time.Minute)) {
+		warnings = append(warnings, "timestamp is in the future")
+	} else if ts.Before(received.Add(-storageTTL)) {
+		warnings = append(warnings, "timestamp is older than the 90 day retention; the event would expire immediately")
+	}
+
+	if ip, ok := event.Context["ip"].(string); ok && net.ParseIP(strings.TrimSpace(ip)) == nil {
+		warnings = append(warnings, "context.ip is not an IP address")
+	}
+	return warnings
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func postValidate(t *testing.T, body string) (int, []byte) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/v1/events/validate", validateHandler)
+	req := httptest.NewRequest("POST", "/v1/events/validate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func TestValidate_SingleNormalizedWithWarnings(t *testing.T) {
+	status, data := postValidate(t, `{"actor":{"id":"alice"},"action":{"name":"User.Login"},"resource":{"type":"app","id":"crm"},"timestamp":"2025-01-02T03:04:05Z","colour":"red","idempotency_key":"k1"}`)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, data)
+	}
+	var result ValidationResult
+	json.Unmarshal(data, &result)
+
+	if !result.Valid || result.Event == nil {
+		t.Fatalf("Expected valid event, got %s", data)
+	}
+	if result.Event.Action["name"] != "user.login" || result.Event.TenantID != defaultTenant || result.Event.EventDate != "2025-01-02" {
+		t.Errorf("Unexpected normalized event: %+v", result.Event)
+	}
+	for _, want := range []string{`unknown field "colour"`, "normalized", "tenant_id is missing", "actor.type", "result.success"} {
+		if !strings.Contains(strings.Join(result.Warnings, "\n"), want) {
+			t.Errorf("Expected warning containing %q, got %v", want, result.Warnings)
+		}
+	}
+}
+
+func TestValidate_Batch(t *testing.T) {
+	status, data := postValidate(t, `{"events":[
+		{"actor":{"id":"a","type":"user"},"action":{"name":"x"},"resource":{"type":"t","id":"1"},"result":{"success":true},"tenant_id":"acme"},
+		{"action":{"name":"x"}}]}`)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	var resp ValidationResponse
+	json.Unmarshal(data, &resp)
+	if resp.Valid != 1 || resp.Invalid != 1 || resp.Results[1].Error != "actor.id is required" || resp.Results[1].Index != 1 {
+		t.Errorf("Unexpected response: %s", data)
+	}
+	// Only the missing timestamp remains
+	if len(resp.Results[0].Warnings) != 1 {
+		t.Errorf("Unexpected warnings: %v", resp.Results[0].Warnings)
+	}
+}
+
+func TestValidate_SingleInvalid(t *testing.T) {
+	status, _ := postValidate(t, `{"actor":{"id":"a"}}`)
+	if status != 422 {
+		t.Errorf("Expected 422, got %d", status)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// relayConfig is read from the environment
+type relayConfig struct {
+	DatabaseURL   string
+	GatewayURL    string
+	APIKey        string
+	Producer      string
+	BatchSize     int
+	MaxAttempts   int
+	PollInterval  time.Duration
+	SubmitTimeout time.Duration
+}
+
+func getEnv(key, defaultVal string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultVal
+}
+
+func getEnvInt(key string, defaultVal int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return defaultVal
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return defaultVal
+}
+
+func loadConfig() relayConfig {
+	return relayConfig{
+		DatabaseURL:   os.Getenv("OUTBOX_DATABASE_URL"),
+		GatewayURL:    getEnv("GATEWAY_URL", "http://event-gateway.apisix.svc.cluster.local:8080"),
+		APIKey:        os.Getenv("GATEWAY_API_KEY"),
+		Producer:      getEnv("OUTBOX_PRODUCER", "outbox-relay"),
+		BatchSize:     getEnvInt("OUTBOX_BATCH_SIZE", 100),
+		MaxAttempts:   getEnvInt("OUTBOX_MAX_ATTEMPTS", 10),
+		PollInterval:  getEnvDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		SubmitTimeout: getEnvDuration("OUTBOX_SUBMIT_TIMEOUT", 30*time.Second),
+	}
+}
+
+func main() {
+	cfg := loadConfig()
+	if cfg.DatabaseURL == "" {
+		log.Fatal("OUTBOX_DATABASE_URL is required")
+	}
+	// The gateway accepts at most 1000 events per batch
+	if cfg.BatchSize > 1000 {
+		cfg.BatchSize = 1000
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer pool.Close()
+
+	r := &relay{
+		pool:    pool,
+		gateway: gatewayClient{URL: cfg.GatewayURL, APIKey: cfg.APIKey, Producer: cfg.Producer, HTTP: &http.Client{}},
+		cfg:     cfg,
+	}
+
+	log.Printf("Outbox relay started (gateway %s, batch %d, max attempts %d)", cfg.GatewayURL, cfg.BatchSize, cfg.MaxAttempts)
+	for ctx.Err() == nil {
+		n, err := r.relayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Outbox relay error: %v", err)
+		}
+		// Keep draining while batches come back full
+		if err == nil && n == cfg.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+	log.Println("Outbox relay stopped")
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// outboxRow is one pending audit event claimed from audit_outbox
+type outboxRow struct {
+	ID       string
+	TenantID string
+	Payload  []byte
+	Attempts int
+}
+
+// rowResult is the gateway's verdict for one submitted row
+type rowResult struct {
+	EventID string
+	Err     error
+	// Permanent errors (validation rejections) will not succeed on retry
+	Permanent bool
+}
+
+// batchResponse mirrors the event gateway's /v1/events/batch response
+type batchResponse struct {
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+	Events   []struct {
+		EventID string `json:"event_id"`
+		Status  string `json:"status"`
+		Error   string `json:"error"`
+	} `json:"events"`
+}
+
+// gatewayClient submits batches to the event gateway
+type gatewayClient struct {
+	URL    string
+	APIKey string
+	// Producer is recorded as the events' producer. The relay posts to the
+	// gateway directly, so it names itself the way APISIX names consumers.
+	Producer string
+	HTTP     *http.Client
+}
+
+// submit posts rows to /v1/events/batch with each row ID as the event's
+// idempotency key. The gateway forwards the batch synchronously, so an
+// accepted row is in Vector. The returned results are in row order. A
+// transport or server error (including a failed forward) fails every row
+// of the batch.
+func (g gatewayClient) submit(ctx context.Context, rows []outboxRow) []rowResult {
+	results := make([]rowResult, len(rows))
+	fail := func(err error) []rowResult {
+		for i := range results {
+			results[i] = rowResult{Err: err}
+		}
+		return results
+	}
+
+	events := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		var event map[string]interface{}
+		if err := json.Unmarshal(row.Payload, &event); err != nil || event == nil {
+			// Leave a placeholder so the gateway rejects it and indexes stay aligned
+			event = map[string]interface{}{}
+		}
+		event["idempotency_key"] = row.ID
+		if _, ok := event["tenant_id"]; !ok && row.TenantID != "" {
+			event["tenant_id"] = row.TenantID
+		}
+		events[i] = event
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return fail(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL+"/v1/events/batch", bytes.NewReader(body))
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.APIKey != "" {
+		req.Header.Set("X-API-Key", g.APIKey)
+	}
+	if g.Producer != "" {
+		req.Header.Set("X-Consumer-Name", g.Producer)
+	}
+
+	resp, err := g.HTTP.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if resp.StatusCode >= 300 {
+		return fail(fmt.Errorf("gateway returned %d: %s", resp.StatusCode, bytes.TrimSpace(data)))
+	}
+
+	var batch batchResponse
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return fail(fmt.Errorf("invalid gateway response: %w", err))
+	}
+	if len(batch.Events) != len(rows) {
+		return fail(fmt.Errorf("gateway returned %d results for %d events", len(batch.Events), len(rows)))
+	}
+	for i, ev := range batch.Events {
+		if ev.Status == "accepted" {
+			results[i] = rowResult{EventID: ev.EventID}
+		} else {
+			results[i] = rowResult{Err: fmt.Errorf("rejected: %s", ev.Error), Permanent: true}
+		}
+	}
+	return results
+}
+
+// relay moves rows from audit_outbox to the event gateway
+type relay struct {
+	pool    *pgxpool.Pool
+	gateway gatewayClient
+	cfg     relayConfig
+}
+
+const claimQuery = `
+SELECT id::text, COALESCE(tenant_id, ''), payload::text, attempts
+FROM audit_outbox
+WHERE delivered_at IS NULL AND parked_at IS NULL AND next_attempt_at <= NOW()
+ORDER BY created_at
+LIMIT $1
+FOR UPDATE SKIP LOCKED`
+
+// relayOnce claims one batch, submits it and records the outcome in the
+// same transaction. Rows stay locked while in flight, so several relay
+// replicas can run side by side. It returns the number of rows claimed.
+func (r *relay) relayOnce(ctx context.Context) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, claimQuery, r.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var claimed []outboxRow
+	for rows.Next() {
+		var row outboxRow
+		var payload string
+		if err := rows.Scan(&row.ID, &row.TenantID, &payload, &row.Attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		row.Payload = []byte(payload)
+		claimed = append(claimed, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(claimed) == 0 {
+		return 0, nil
+	}
+
+	submitCtx, cancel := context.WithTimeout(ctx, r.cfg.SubmitTimeout)
+	results := r.gateway.submit(submitCtx, claimed)
+	cancel()
+
+	batch := &pgx.Batch{}
+	for i, row := range claimed {
+		queueOutcome(batch, row, results[i], r.cfg.MaxAttempts)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, err
+	}
+	return len(claimed), tx.Commit(ctx)
+}
+
+// queueOutcome marks a row delivered, schedules a retry with exponential
+// backoff, or parks it once it is out of attempts
+func queueOutcome(batch *pgx.Batch, row outboxRow, result rowResult, maxAttempts int) {
+	if result.Err == nil {
+		batch.Queue(`UPDATE audit_outbox SET delivered_at = NOW(), event_id = $2, attempts = attempts + 1, last_error = NULL WHERE id = $1`,
+			row.ID, result.EventID)
+		return
+	}
+	attempts := row.Attempts + 1
+	if result.Permanent || attempts >= maxAttempts {
+		batch.Queue(`UPDATE audit_outbox SET parked_at = NOW(), attempts = $2, last_error = $3 WHERE id = $1`,
+			row.ID, attempts, result.Err.Error())
+		return
+	}
+	batch.Queue(`UPDATE audit_outbox SET attempts = $2, last_error = $3, next_attempt_at = NOW() + $4::interval WHERE id = $1`,
+		row.ID, attempts, result.Err.Error(), retryDelay(attempts).String())
+}
+
+// retryDelay doubles from one second up to five minutes
+func retryDelay(attempts int) time.Duration {
+	delay := time.Duration(math.Pow(2, float64(attempts-1))) * time.Second
+	if delay <= 0 || delay > 5*time.Minute {
+		return 5 * time.Minute
+	}
+	return delay
+}
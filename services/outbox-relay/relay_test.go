@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSubmit_UsesRowIDAsIdempotencyKey(t *testing.T) {
+	var got struct {
+		Events []map[string]interface{} `json:"events"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/events/batch" || r.Header.Get("X-API-Key") != "k1" || r.Header.Get("X-Consumer-Name") != "orders" {
+			t.Errorf("Unexpected request %s with key %q", r.URL.Path, r.Header.Get("X-API-Key"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"accepted":1,"rejected":1,"events":[
+			{"event_id":"e-1","status":"accepted"},
+			{"event_id":"","status":"rejected","error":"actor.id is required"}]}`))
+	}))
+	defer srv.Close()
+
+	g := gatewayClient{URL: srv.URL, APIKey: "k1", Producer: "orders", HTTP: srv.Client()}
+	results := g.submit(context.Background(), []outboxRow{
+		{ID: "row-1", TenantID: "shop", Payload: []byte(`{"actor":{"id":"alice"},"action":{"name":"order.paid"},"resource":{"type":"order","id":"42"}}`)},
+		{ID: "row-2", Payload: []byte(`{"action":{"name":"order.paid"}}`)},
+	})
+
+	if len(got.Events) != 2 || got.Events[0]["idempotency_key"] != "row-1" || got.Events[0]["tenant_id"] != "shop" {
+		t.Fatalf("Unexpected submitted events: %v", got.Events)
+	}
+	if _, ok := got.Events[1]["tenant_id"]; ok {
+		t.Errorf("Expected no tenant_id for a row without tenant, got %v", got.Events[1])
+	}
+	if results[0].Err != nil || results[0].EventID != "e-1" {
+		t.Errorf("Expected row-1 delivered, got %+v", results[0])
+	}
+	if results[1].Err == nil || !results[1].Permanent {
+		t.Errorf("Expected row-2 permanently rejected, got %+v", results[1])
+	}
+}
+
+func TestSubmit_ServerErrorFailsBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	g := gatewayClient{URL: srv.URL, HTTP: srv.Client()}
+	results := g.submit(context.Background(), []outboxRow{{ID: "a", Payload: []byte(`{}`)}, {ID: "b", Payload: []byte(`{}`)}})
+	for _, res := range results {
+		if res.Err == nil || res.Permanent {
+			t.Errorf("Expected retryable error, got %+v", res)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 5: 16 * time.Second, 20: 5 * time.Minute}
+	for attempts, want := range cases {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
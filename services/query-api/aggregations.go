@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultTopN          = 100
+	maxTopN              = 1000
+	maxAggregationGroups = 4
+)
+
+// aggregationDimensions maps group_by names to string key expressions
+var aggregationDimensions = map[string]string{
+	"action":        "action_name",
+	"actor":         "actor_id",
+	"actor_type":    "actor_type",
+	"resource_type": "resource_type",
+	"resource_id":   "resource_id",
+	"success":       "if(result_success, 'true', 'false')",
+	"date":          "toString(event_date)",
+	"tenant":        "tenant_id",
+}
+
+// aggregationMetrics maps metric names to the expression computing them
+// per group, used for having and top_n ordering
+var aggregationMetrics = map[string]string{
+	"count":            "count()",
+	"success":          "countIf(result_success)",
+	"failed":           "countIf(NOT result_success)",
+	"success_rate":     "countIf(result_success) / count()",
+	"failure_rate":     "countIf(NOT result_success) / count()",
+	"unique_actors":    "uniq(actor_id)",
+	"unique_resources": "uniq(resource_id)",
+}
+
+var defaultAggregationMetrics = []string{"count", "success", "failed"}
+
+// AggregationBucket is one group. Key holds a value per group_by
+// dimension; metrics that were not requested are left out.
+type AggregationBucket struct {
+	Key             map[string]string `json:"key"`
+	Count           int64             `json:"count"`
+	Success         *int64            `json:"success,omitempty"`
+	Failed          *int64            `json:"failed,omitempty"`
+	SuccessRate     *float64          `json:"success_rate,omitempty"`
+	FailureRate     *float64          `json:"failure_rate,omitempty"`
+	UniqueActors    *uint64           `json:"unique_actors,omitempty"`
+	UniqueResources *uint64           `json:"unique_resources,omitempty"`
+}
+
+// AggregationResponse holds the top_n groups in order, the remaining
+// groups summed into other, and the total count across both
+type AggregationResponse struct {
+	GroupBy      []string            `json:"group_by"`
+	Metrics      []string            `json:"metrics"`
+	OrderBy      string              `json:"order_by"`
+	Aggregations []AggregationBucket `json:"aggregations"`
+	Other        *AggregationBucket  `json:"other,omitempty"`
+	Total        int64               `json:"total"`
+}
+
+// havingClause is a threshold such as count>=10
+type havingClause struct {
+	metric string
+	op     string
+	value  float64
+}
+
+var havingPattern = regexp.MustCompile(`^([a-z_]+)\s*(>=|<=|!=|>|<|=)\s*(-?[0-9]+(?:\.[0-9]+)?)$`)
+
+func parseHaving(s string) ([]havingClause, error) {
+	var clauses []havingClause
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		m := havingPattern.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("having %q must look like metric>=number", part)
+		}
+		if _, ok := aggregationMetrics[m[1]]; !ok {
+			return nil, fmt.Errorf("unknown metric %q in having", m[1])
+		}
+		value, _ := strconv.ParseFloat(m[3], 64)
+		clauses = append(clauses, havingClause{metric: m[1], op: m[2], value: value})
+	}
+	return clauses, nil
+}
+
+// parseNames splits a comma-separated list, checking each name against
+// known and rejecting duplicates
+func parseNames(s string, def []string, known map[string]string, what string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return def, nil
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("unknown %s %q", what, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s %q given twice", what, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+type aggregationRequest struct {
+	groupBy []string
+	metrics []string
+	orderBy string
+	having  []havingClause
+	topN    int
+}
+
+func parseAggregationRequest(c *fiber.Ctx) (aggregationRequest, error) {
+	var req aggregationRequest
+	var err error
+	if req.groupBy, err = parseNames(c.Query("group_by"), []string{"action"}, aggregationDimensions, "dimension"); err != nil {
+		return req, err
+	}
+	if len(req.groupBy) > maxAggregationGroups {
+		return req, fmt.Errorf("at most %d group_by dimensions", maxAggregationGroups)
+	}
+	if req.metrics, err = parseNames(c.Query("metrics"), defaultAggregationMetrics, aggregationMetrics, "metric"); err != nil {
+		return req, err
+	}
+	req.orderBy = c.Query("order_by", "count")
+	if _, ok := aggregationMetrics[req.orderBy]; !ok {
+		return req, fmt.Errorf("unknown metric %q in order_by", req.orderBy)
+	}
+	if req.having, err = parseHaving(c.Query("having")); err != nil {
+		return req, err
+	}
+	req.topN = defaultTopN
+	if s := c.Query("top_n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxTopN {
+			return req, fmt.Errorf("top_n must be between 1 and %d", maxTopN)
+		}
+		req.topN = n
+	}
+	return req, nil
+}
+
+func (r aggregationRequest) wants(metric string) bool {
+	for _, m := range r.metrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// query groups by the dimensions, ranks the groups by order_by
+// and folds every group after top_n into a single row. Distinct counts
+// are carried as uniq states so the folded row merges them instead of
+// adding them up.
+func (r aggregationRequest) query(where string, whereArgs []interface{}) (string, []interface{}) {
+	var inner, outer, keys []string
+	for i, dim := range r.groupBy {
+		inner = append(inner, fmt.Sprintf("%s AS d%d", aggregationDimensions[dim], i))
+		outer = append(outer, fmt.Sprintf("if(top, d%d, '') AS k%d", i, i))
+		keys = append(keys, fmt.Sprintf("d%d", i))
+	}
+	inner = append(inner, "count() AS cnt", "countIf(result_success) AS succ")
+	outer = append(outer, "sum(cnt)", "sum(succ)")
+	if r.wants("unique_actors") {
+		inner = append(inner, "uniqState(actor_id) AS ua")
+		outer = append(outer, "uniqMerge(ua)")
+	}
+	if r.wants("unique_resources") {
+		inner = append(inner, "uniqState(resource_id) AS ur")
+		outer = append(outer, "uniqMerge(ur)")
+	}
+	inner = append(inner, fmt.Sprintf("row_number() OVER (ORDER BY %s DESC, %s) AS rn",
+		aggregationMetrics[r.orderBy], strings.Join(keys, ", ")))
+
+	args := []interface{}{r.topN}
+	sub := "SELECT " + strings.Join(inner, ", ") + " FROM audit.events" + where
+	args = append(args, whereArgs...)
+	sub += " GROUP BY " + strings.Join(keys, ", ")
+	if len(r.having) > 0 {
+		var conds []string
+		for _, h := range r.having {
+			conds = append(conds, fmt.Sprintf("%s %s ?", aggregationMetrics[h.metric], h.op))
+			args = append(args, h.value)
+		}
+		sub += " HAVING " + strings.Join(conds, " AND ")
+	}
+
+	groupKeys := []string{"top"}
+	for i := range r.groupBy {
+		groupKeys = append(groupKeys, fmt.Sprintf("k%d", i))
+	}
+	query := "SELECT toUInt8(rn <= ?) AS top, " + strings.Join(outer, ", ") +
+		" FROM (" + sub + ") GROUP BY " + strings.Join(groupKeys, ", ")
+	return query, args
+}
+
+// bucket builds the response entry from summed counts
+func (r aggregationRequest) bucket(key map[string]string, count, success int64, actors, resources uint64) AggregationBucket {
+	b := AggregationBucket{Key: key, Count: count}
+	failed := count - success
+	rate := func(n int64) *float64 {
+		v := 0.0
+		if count > 0 {
+			v = float64(n) / float64(count)
+		}
+		return &v
+	}
+	if r.wants("success") {
+		b.Success = &success
+	}
+	if r.wants("failed") {
+		b.Failed = &failed
+	}
+	if r.wants("success_rate") {
+		b.SuccessRate = rate(success)
+	}
+	if r.wants("failure_rate") {
+		b.FailureRate = rate(failed)
+	}
+	if r.wants("unique_actors") {
+		b.UniqueActors = &actors
+	}
+	if r.wants("unique_resources") {
+		b.UniqueResources = &resources
+	}
+	return b
+}
+
+// sortValue is the order_by metric of a bucket
+func (r aggregationRequest) sortValue(b AggregationBucket, success int64, actors, resources uint64) float64 {
+	switch r.orderBy {
+	case "success":
+		return float64(success)
+	case "failed":
+		return float64(b.Count - success)
+	case "success_rate", "failure_rate":
+		if b.Count == 0 {
+			return 0
+		}
+		if r.orderBy == "success_rate" {
+			return float64(success) / float64(b.Count)
+		}
+		return float64(b.Count-success) / float64(b.Count)
+	case "unique_actors":
+		return float64(actors)
+	case "unique_resources":
+		return float64(resources)
+	}
+	return float64(b.Count)
+}
+
+func aggregationsHandler(c *fiber.Ctx) error {
+	req, err := parseAggregationRequest(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	where, whereArgs := listFilters(c)
+	qWhere, qArgs, err := fieldQueryFilter(c.Query("q"))
+	if err != nil {
+		return queryError(c, err)
+	}
+	where += qWhere
+	whereArgs = append(whereArgs, qArgs...)
+
+	query, args := req.query(where, whereArgs)
+	rows, err := chConn.Query(context.Background(), query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+
+	type ranked struct {
+		bucket AggregationBucket
+		value  float64
+	}
+	var top []ranked
+	resp := AggregationResponse{GroupBy: req.groupBy, Metrics: req.metrics, OrderBy: req.orderBy}
+	for rows.Next() {
+		var isTop uint8
+		keys := make([]string, len(req.groupBy))
+		var count, success, actors, resources uint64
+		dest := []interface{}{&isTop}
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		dest = append(dest, &count, &success)
+		if req.wants("unique_actors") {
+			dest = append(dest, &actors)
+		}
+		if req.wants("unique_resources") {
+			dest = append(dest, &resources)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		resp.Total += int64(count)
+		if isTop == 0 {
+			other := req.bucket(nil, int64(count), int64(success), actors, resources)
+			resp.Other = &other
+			continue
+		}
+		key := make(map[string]string, len(keys))
+		for i, dim := range req.groupBy {
+			key[dim] = keys[i]
+		}
+		b := req.bucket(key, int64(count), int64(success), actors, resources)
+		top = append(top, ranked{b, req.sortValue(b, int64(success), actors, resources)})
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].value != top[j].value {
+			return top[i].value > top[j].value
+		}
+		for _, dim := range req.groupBy {
+			if a, b := top[i].bucket.Key[dim], top[j].bucket.Key[dim]; a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+	resp.Aggregations = make([]AggregationBucket, len(top))
+	for i, r := range top {
+		resp.Aggregations[i] = r.bucket
+	}
+	return c.JSON(resp)
+}
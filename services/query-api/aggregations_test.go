@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func parseAggregationURL(t *testing.T, target string) (aggregationRequest, error) {
+	t.Helper()
+	var req aggregationRequest
+	var err error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		req, err = parseAggregationRequest(c)
+		return nil
+	})
+	if _, testErr := app.Test(httptest.NewRequest("GET", target, nil)); testErr != nil {
+		t.Fatal(testErr)
+	}
+	return req, err
+}
+
+func TestAggregationQuery(t *testing.T) {
+	req, err := parseAggregationURL(t, "/?group_by=action,resource_type&metrics=count,unique_actors,failure_rate&having=count>=10,failure_rate>0.5&top_n=5&order_by=unique_actors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args := req.query(" WHERE 1=1 AND tenant_id = ?", []interface{}{"t1"})
+
+	for _, want := range []string{
+		"SELECT toUInt8(rn <= ?) AS top, if(top, d0, '') AS k0, if(top, d1, '') AS k1, sum(cnt), sum(succ), uniqMerge(ua) FROM (",
+		"action_name AS d0, resource_type AS d1",
+		"row_number() OVER (ORDER BY uniq(actor_id) DESC, d0, d1) AS rn",
+		"HAVING count() >= ? AND countIf(NOT result_success) / count() > ?",
+		"GROUP BY top, k0, k1",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("Query lacks %q:\n%s", want, query)
+		}
+	}
+	// top_n is bound first because it appears in the outer SELECT
+	if want := []interface{}{5, "t1", 10.0, 0.5}; !reflect.DeepEqual(args, want) {
+		t.Errorf("Args = %v, want %v", args, want)
+	}
+
+	b := req.bucket(map[string]string{"action": "a"}, 4, 1, 3, 0)
+	if b.Success != nil || b.Failed != nil || *b.FailureRate != 0.75 || *b.UniqueActors != 3 {
+		t.Errorf("Bucket = %+v", b)
+	}
+}
+
+func TestAggregationRequest_Errors(t *testing.T) {
+	for _, target := range []string{
+		"/?group_by=action,action",
+		"/?group_by=email",
+		"/?group_by=action,actor,actor_type,resource_type,resource_id",
+		"/?metrics=sum(actor_id)",
+		"/?having=count>=1;DROP",
+		"/?having=events>1",
+		"/?order_by=action",
+		"/?top_n=0",
+	} {
+		if _, err := parseAggregationURL(t, target); err == nil {
+			t.Errorf("%s: expected an error", target)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// principal is who a request acts for. APISIX authenticates the API key
+// and names its consumer; the consumer is mapped to a tenant and roles
+// here. Admins see every tenant, everyone else only their own.
+type principal struct {
+	Consumer string
+	Tenant   string
+	Roles    []string
+	Admin    bool
+}
+
+// scope identifies what a principal can see, for binding cursors and
+// export jobs
+func (p principal) scope() string {
+	if p.Admin {
+		return "*"
+	}
+	return p.Tenant
+}
+
+// consumerEntry is one consumer in CONSUMER_TENANTS
+type consumerEntry struct {
+	Tenant string   `json:"tenant"`
+	Roles  []string `json:"roles"`
+}
+
+// principalSource looks up a consumer. ok is false for unknown consumers.
+type principalSource interface {
+	lookup(ctx context.Context, consumer string) (entry consumerEntry, ok bool, err error)
+}
+
+// staticConsumers maps consumers from configuration
+type staticConsumers map[string]consumerEntry
+
+func (s staticConsumers) lookup(ctx context.Context, consumer string) (consumerEntry, bool, error) {
+	e, ok := s[consumer]
+	return e, ok, nil
+}
+
+// parseConsumers reads CONSUMER_TENANTS, a JSON object such as
+// {"acme-reader": {"tenant": "<uuid>", "roles": ["events:read"]}}
+func parseConsumers(data string) (staticConsumers, error) {
+	consumers := staticConsumers{}
+	if strings.TrimSpace(data) == "" {
+		return consumers, nil
+	}
+	if err := json.Unmarshal([]byte(data), &consumers); err != nil {
+		return nil, fmt.Errorf("invalid consumer mapping: %w", err)
+	}
+	return consumers, nil
+}
+
+// apiKeyConsumers maps consumers to active rows of the gateway's api_keys
+// table by key ID or name, using the key's scopes as roles. Lookups are
+// cached for ttl, misses included, so revocations take effect within ttl.
+type apiKeyConsumers struct {
+	query func(ctx context.Context, consumer string) (consumerEntry, bool, error)
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedConsumer
+}
+
+type cachedConsumer struct {
+	entry   consumerEntry
+	ok      bool
+	expires time.Time
+}
+
+// apiKeyRow is an active api_keys row matching a consumer
+type apiKeyRow struct {
+	Tenant string
+	Scopes []string
+}
+
+// pickAPIKey maps the rows matching a consumer, newest first, to an entry.
+// Names are free-form labels, so a name shared by keys of different
+// tenants maps to nothing rather than to whichever key is newest.
+func pickAPIKey(rows []apiKeyRow) (consumerEntry, bool) {
+	if len(rows) == 0 {
+		return consumerEntry{}, false
+	}
+	for _, row := range rows[1:] {
+		if row.Tenant != rows[0].Tenant {
+			return consumerEntry{}, false
+		}
+	}
+	return consumerEntry{Tenant: rows[0].Tenant, Roles: rows[0].Scopes}, true
+}
+
+func newAPIKeyConsumers(pool *pgxpool.Pool, ttl time.Duration) *apiKeyConsumers {
+	return &apiKeyConsumers{
+		ttl:   ttl,
+		cache: map[string]cachedConsumer{},
+		query: func(ctx context.Context, consumer string) (consumerEntry, bool, error) {
+			rows, err := pool.Query(ctx, `
+				SELECT tenant_id::text, COALESCE(scopes, '{}')
+				FROM api_keys
+				WHERE (id::text = $1 OR name = $1) AND is_active AND (expires_at IS NULL OR expires_at > NOW())
+				ORDER BY created_at DESC`, consumer)
+			if err != nil {
+				return consumerEntry{}, false, err
+			}
+			keys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (apiKeyRow, error) {
+				var k apiKeyRow
+				err := row.Scan(&k.Tenant, &k.Scopes)
+				return k, err
+			})
+			if err != nil {
+				return consumerEntry{}, false, err
+			}
+			e, ok := pickAPIKey(keys)
+			if !ok && len(keys) > 0 {
+				log.Printf("Consumer %q names api keys of several tenants, rejecting it", consumer)
+			}
+			return e, ok, nil
+		},
+	}
+}
+
+func (s *apiKeyConsumers) lookup(ctx context.Context, consumer string) (consumerEntry, bool, error) {
+	s.mu.Lock()
+	cached, hit := s.cache[consumer]
+	s.mu.Unlock()
+	if hit && time.Now().Before(cached.expires) {
+		return cached.entry, cached.ok, nil
+	}
+
+	e, ok, err := s.query(ctx, consumer)
+	if err != nil {
+		return e, false, err
+	}
+	s.mu.Lock()
+	s.cache[consumer] = cachedConsumer{entry: e, ok: ok, expires: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+	return e, ok, nil
+}
+
+// authorizer resolves consumers to principals. Sources are tried in
+// order, so configured consumers take precedence over the api_keys table.
+type authorizer struct {
+	sources    []principalSource
+	adminRoles map[string]bool
+	readRoles  map[string]bool
+}
+
+var errNoReadAccess = errors.New("consumer may not read events")
+
+func newAuthorizer(adminRoles, readRoles string, sources ...principalSource) *authorizer {
+	return &authorizer{sources: sources, adminRoles: roleSet(adminRoles), readRoles: roleSet(readRoles)}
+}
+
+func roleSet(s string) map[string]bool {
+	roles := map[string]bool{}
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles[r] = true
+		}
+	}
+	return roles
+}
+
+// resolve maps a consumer to its principal. ok is false when the consumer
+// is unknown or is not an admin and has no tenant.
+func (a *authorizer) resolve(ctx context.Context, consumer string) (principal, bool, error) {
+	for _, src := range a.sources {
+		e, ok, err := src.lookup(ctx, consumer)
+		if err != nil {
+			return principal{}, false, err
+		}
+		if !ok {
+			continue
+		}
+		p := principal{Consumer: consumer, Tenant: e.Tenant, Roles: e.Roles}
+		canRead := false
+		for _, r := range e.Roles {
+			p.Admin = p.Admin || a.adminRoles[r]
+			canRead = canRead || a.readRoles[r]
+		}
+		if !p.Admin && !canRead {
+			return p, true, errNoReadAccess
+		}
+		return p, p.Admin || p.Tenant != "", nil
+	}
+	return principal{}, false, nil
+}
+
+// authorize is the middleware every data route passes through. It fails
+// closed: no consumer is 401, a consumer without a tenant or read role 403.
+func authorize(a *authorizer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		consumer := c.Get("X-Consumer-Name")
+		if consumer == "" {
+			return c.Status(401).JSON(fiber.Map{"error": "missing consumer identity"})
+		}
+		p, ok, err := a.resolve(c.Context(), consumer)
+		if errors.Is(err, errNoReadAccess) {
+			return c.Status(403).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			log.Printf("Resolving consumer %q: %v", consumer, err)
+			return c.Status(503).JSON(fiber.Map{"error": "authorization unavailable"})
+		}
+		if !ok {
+			return c.Status(403).JSON(fiber.Map{"error": "consumer is not mapped to a tenant"})
+		}
+		c.Locals("principal", p)
+		return c.Next()
+	}
+}
+
+// currentPrincipal is the principal set by authorize. Without one it is
+// the zero principal, which is scoped to the empty tenant and sees nothing.
+func currentPrincipal(c *fiber.Ctx) principal {
+	p, _ := c.Locals("principal").(principal)
+	return p
+}
+
+// initAuth builds the authorizer from CONSUMER_TENANTS (or the file named
+// by CONSUMER_TENANTS_FILE) and, when API_KEYS_DATABASE_URL is set, the
+// api_keys table
+func initAuth() (*authorizer, error) {
+	data := os.Getenv("CONSUMER_TENANTS")
+	if file := os.Getenv("CONSUMER_TENANTS_FILE"); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		data = string(b)
+	}
+	consumers, err := parseConsumers(data)
+	if err != nil {
+		return nil, err
+	}
+	sources := []principalSource{consumers}
+
+	if dsn := os.Getenv("API_KEYS_DATABASE_URL"); dsn != "" {
+		pool, err := pgxpool.New(context.Background(), dsn)
+		if err != nil {
+			return nil, err
+		}
+		ttl := time.Minute
+		if d, err := time.ParseDuration(getEnv("AUTH_CACHE_TTL", "")); err == nil && d > 0 {
+			ttl = d
+		}
+		sources = append(sources, newAPIKeyConsumers(pool, ttl))
+	} else if len(consumers) == 0 {
+		log.Println("Warning: no consumers configured, every request will be rejected")
+	}
+	return newAuthorizer(getEnv("ADMIN_ROLES", "admin"), getEnv("READ_ROLES", "events:read"), sources...), nil
+}
+
+// tenantFilter scopes a query to the principal's tenant. Admins are not
+// filtered; anyone else is filtered even with an empty tenant, so a
+// missing principal matches nothing.
+func tenantFilter(query string, args []interface{}, p principal) (string, []interface{}) {
+	if p.Admin {
+		return query, args
+	}
+	return query + " AND tenant_id = ?", append(args, p.Tenant)
+}
+
+func addTenantFilter(query string, args []interface{}, c *fiber.Ctx) (string, []interface{}) {
+	return tenantFilter(query, args, currentPrincipal(c))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newTestAuthApp(t *testing.T) *fiber.App {
+	t.Helper()
+	consumers, err := parseConsumers(`{
+		"acme-reader": {"tenant": "acme", "roles": ["events:read"]},
+		"acme-writer": {"tenant": "acme", "roles": ["events:write"]},
+		"ops":         {"roles": ["admin"]},
+		"no-tenant":   {"roles": ["events:read"]}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Use(authorize(newAuthorizer("admin", "events:read", consumers)))
+	app.Get("/whoami", func(c *fiber.Ctx) error {
+		p := currentPrincipal(c)
+		return c.JSON(fiber.Map{"tenant": p.Tenant, "admin": p.Admin, "scope": p.scope()})
+	})
+	return app
+}
+
+func TestAuthorize_FailsClosed(t *testing.T) {
+	app := newTestAuthApp(t)
+	tests := []struct {
+		consumer string
+		path     string
+		want     int
+	}{
+		{"", "/health", 200},
+		{"", "/whoami", 401},
+		{"stranger", "/whoami", 403},
+		{"acme-writer", "/whoami", 403},
+		{"no-tenant", "/whoami", 403},
+		{"acme-reader", "/whoami", 200},
+		{"ops", "/whoami", 200},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.consumer != "" {
+			req.Header.Set("X-Consumer-Name", tt.consumer)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%q %s: status %d, want %d", tt.consumer, tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestTenantFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     principal
+		query string
+		args  []interface{}
+	}{
+		{"tenant", principal{Tenant: "acme"}, " WHERE 1=1 AND tenant_id = ?", []interface{}{"acme"}},
+		{"admin", principal{Tenant: "acme", Admin: true}, " WHERE 1=1", []interface{}{}},
+		// Without a principal nothing matches
+		{"none", principal{}, " WHERE 1=1 AND tenant_id = ?", []interface{}{""}},
+	}
+	for _, tt := range tests {
+		query, args := tenantFilter(" WHERE 1=1", []interface{}{}, tt.p)
+		if query != tt.query || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: got %q %v, want %q %v", tt.name, query, args, tt.query, tt.args)
+		}
+	}
+}
+
+func TestAPIKeyConsumers_CachesLookups(t *testing.T) {
+	calls := 0
+	src := &apiKeyConsumers{
+		ttl:   time.Minute,
+		cache: map[string]cachedConsumer{},
+		query: func(ctx context.Context, consumer string) (consumerEntry, bool, error) {
+			calls++
+			if consumer == "acme" {
+				return consumerEntry{Tenant: "t-acme", Roles: []string{"events:read"}}, true, nil
+			}
+			return consumerEntry{}, false, nil
+		},
+	}
+	a := newAuthorizer("admin", "events:read", staticConsumers{"ops": {Roles: []string{"admin"}}}, src)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		p, ok, err := a.resolve(ctx, "acme")
+		if err != nil || !ok || p.Tenant != "t-acme" || p.Admin {
+			t.Fatalf("acme: %+v %v %v", p, ok, err)
+		}
+		if _, ok, _ := a.resolve(ctx, "gone"); ok {
+			t.Fatal("Unknown consumer resolved")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Queries = %d, want 2 with misses cached", calls)
+	}
+	// Configured consumers never reach the table
+	if p, ok, _ := a.resolve(ctx, "ops"); !ok || !p.Admin || calls != 2 {
+		t.Errorf("ops: %+v %v, queries %d", p, ok, calls)
+	}
+}
+
+func TestPickAPIKey_RejectsNamesSharedAcrossTenants(t *testing.T) {
+	newest := apiKeyRow{Tenant: "t-acme", Scopes: []string{"events:read"}}
+	older := apiKeyRow{Tenant: "t-acme", Scopes: []string{"events:write"}}
+	if e, ok := pickAPIKey([]apiKeyRow{newest, older}); !ok || e.Tenant != "t-acme" || !reflect.DeepEqual(e.Roles, newest.Scopes) {
+		t.Errorf("Same tenant: %+v %v, want the newest key", e, ok)
+	}
+	if e, ok := pickAPIKey([]apiKeyRow{newest, {Tenant: "t-other", Scopes: []string{"admin"}}}); ok {
+		t.Errorf("Shared name resolved to %+v", e)
+	}
+	if _, ok := pickAPIKey(nil); ok {
+		t.Error("No rows resolved")
+	}
+}
+
+func TestExportJobVisibility(t *testing.T) {
+	tenantJob := &ExportJob{Tenant: "acme"}
+	adminJob := &ExportJob{AllTenants: true}
+	acme, other, admin := principal{Tenant: "acme"}, principal{Tenant: "other"}, principal{Admin: true}
+
+	if !tenantJob.visibleTo(acme) || tenantJob.visibleTo(other) || !tenantJob.visibleTo(admin) {
+		t.Error("Tenant job visibility")
+	}
+	if adminJob.visibleTo(acme) || !adminJob.visibleTo(admin) {
+		t.Error("Admin job visibility")
+	}
+	if q, _ := filterWhere(adminJob.principal(), func(string) string { return "" }); q != " WHERE 1=1" {
+		t.Errorf("Admin job filter = %q", q)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CatalogEntry describes one action or resource type seen in the catalog
+type CatalogEntry struct {
+	Name         string    `json:"name"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Volume30d    uint64    `json:"volume_30d"`
+	SuccessRatio *float64  `json:"success_ratio"`
+	Producers    []string  `json:"producers"`
+	Sample       *Event    `json:"sample,omitempty"`
+}
+
+// CatalogResponse is the response of the catalog endpoints
+type CatalogResponse struct {
+	Kind string         `json:"kind"`
+	Data []CatalogEntry `json:"data"`
+}
+
+// catalogActionsHandler lists the action names seen for the tenant
+func catalogActionsHandler(c *fiber.Ctx) error {
+	return catalogHandler(c, "action")
+}
+
+// catalogResourceTypesHandler lists the resource types seen for the tenant
+func catalogResourceTypesHandler(c *fiber.Ctx) error {
+	return catalogHandler(c, "resource_type")
+}
+
+// catalogHandler reads audit.event_catalog, which materialized views keep
+// up to date, so discovery never scans audit.events. Query parameters:
+// prefix (name prefix), limit (default 100, max 1000) and sample=false to
+// skip the sample events.
+func catalogHandler(c *fiber.Ctx, kind string) error {
+	query := `
+		SELECT name,
+			   min(first_seen) AS first_seen,
+			   max(last_seen) AS last_seen,
+			   sumIf(events, day >= today() - 29) AS volume_30d,
+			   sumIf(successes, day >= today() - 29) AS successes_30d,
+			   groupUniqArrayMerge(20)(producers) AS producers,
+			   toString(argMaxMerge(sample_event_id)) AS sample_event_id,
+			   argMax(tenant_id, last_seen) AS sample_tenant_id,
+			   argMax(day, last_seen) AS sample_day
+		FROM audit.event_catalog
+		WHERE kind = ?
+	`
+	args := []interface{}{kind}
+
+	query, args = addTenantFilter(query, args, c)
+
+	if prefix := c.Query("prefix"); prefix != "" {
+		query += " AND startsWith(name, ?)"
+		args = append(args, prefix)
+	}
+
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+	query += " GROUP BY name ORDER BY volume_30d DESC, name LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := chConn.Query(context.Background(), query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+
+	entries := []CatalogEntry{}
+	sampleIDs := []string{}
+	// The sample is the event with the latest received_at, so the row with
+	// the latest last_seen names its tenant and day
+	sampleTenants, sampleDays := map[string]bool{}, map[string]bool{}
+	for rows.Next() {
+		var entry CatalogEntry
+		var successes uint64
+		var sampleID, sampleTenant string
+		var sampleDay time.Time
+		if err := rows.Scan(&entry.Name, &entry.FirstSeen, &entry.LastSeen, &entry.Volume30d, &successes, &entry.Producers, &sampleID, &sampleTenant, &sampleDay); err != nil {
+			continue
+		}
+		sampleTenants[sampleTenant] = true
+		sampleDays[sampleDay.Format("2006-01-02")] = true
+		if entry.Volume30d > 0 {
+			ratio := float64(successes) / float64(entry.Volume30d)
+			entry.SuccessRatio = &ratio
+		}
+		if entry.Producers == nil {
+			entry.Producers = []string{}
+		}
+		entries = append(entries, entry)
+		sampleIDs = append(sampleIDs, sampleID)
+	}
+
+	if c.Query("sample") != "false" && len(entries) > 0 {
+		samples, err := loadSampleEvents(c, sampleIDs, setMembers(sampleTenants), setMembers(sampleDays))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		for i := range entries {
+			if sample, ok := samples[sampleIDs[i]]; ok {
+				entries[i].Sample = &sample
+			}
+		}
+	}
+
+	return c.JSON(CatalogResponse{Kind: kind, Data: entries})
+}
+
+// loadSampleEvents fetches the catalog's sample events in one query,
+// restricted to the samples' tenants and days so the primary key prunes
+// the scan. A sample can be missing once its event has expired.
+func loadSampleEvents(c *fiber.Ctx, ids, tenants, days []string) (map[string]Event, error) {
+	query := "SELECT event_id, tenant_id, event_date, received_at, actor_id, action_name, resource_type, resource_id, result_success FROM audit.events WHERE tenant_id IN (?) AND event_date IN (?) AND has(?, toString(event_id))"
+	args := []interface{}{tenants, days, ids}
+
+	query, args = addTenantFilter(query, args, c)
+	query += " LIMIT 1 BY event_id"
+
+	rows, err := chConn.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := map[string]Event{}
+	for rows.Next() {
+		var e struct {
+			EventID       string
+			TenantID      string
+			EventDate     time.Time
+			ReceivedAt    time.Time
+			ActorID       string
+			ActionName    string
+			ResourceType  string
+			ResourceID    string
+			ResultSuccess bool
+		}
+		if err := rows.Scan(&e.EventID, &e.TenantID, &e.EventDate, &e.ReceivedAt, &e.ActorID, &e.ActionName, &e.ResourceType, &e.ResourceID, &e.ResultSuccess); err != nil {
+			continue
+		}
+		samples[e.EventID] = Event{
+			EventID:    e.EventID,
+			TenantID:   e.TenantID,
+			EventDate:  e.EventDate.Format("2006-01-02"),
+			ReceivedAt: e.ReceivedAt,
+			Actor:      map[string]interface{}{"id": e.ActorID},
+			Action:     map[string]interface{}{"name": e.ActionName},
+			Resource:   map[string]interface{}{"type": e.ResourceType, "id": e.ResourceID},
+			Result:     map[string]interface{}{"success": e.ResultSuccess},
+		}
+	}
+	return samples, rows.Err()
+}
+
+func setMembers(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	return out
+}
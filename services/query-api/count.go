@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Count modes for the count query parameter
+const (
+	countAuto   = "auto"
+	countExact  = "exact"
+	countApprox = "approx"
+	countNone   = "none"
+)
+
+// exactCountMaxDays is the widest date range auto mode counts exactly
+const exactCountMaxDays = 31
+
+// approxCountCap bounds the rows an approximate count may read when no
+// pre-aggregated table can answer the filters
+const approxCountCap = 100000
+
+type countResult struct {
+	count int64
+	exact bool
+	err   error
+}
+
+func parseCountMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return countAuto, nil
+	case countAuto, countExact, countApprox, countNone:
+		return mode, nil
+	}
+	return "", fmt.Errorf("count must be one of auto, exact, approx, none")
+}
+
+// autoCountMode counts exactly when both ends of the date range are given
+// and at most exactCountMaxDays apart
+func autoCountMode(from, to string) string {
+	fromDate, errFrom := time.Parse("2006-01-02", from)
+	toDate, errTo := time.Parse("2006-01-02", to)
+	if errFrom != nil || errTo != nil || toDate.Sub(fromDate) > exactCountMaxDays*24*time.Hour {
+		return countApprox
+	}
+	return countExact
+}
+
+// eventCounter holds everything needed to count a list request. It is
+// built from the request up front because the count can run after the
+// handler has returned, so it copies the strings Fiber lends from the
+// request buffers.
+type eventCounter struct {
+	where     string
+	whereArgs []interface{}
+
+	// Pre-aggregated estimates only apply to these filters
+	tenantWhere string
+	tenantArgs  []interface{}
+	from, to    string
+	action      string
+	resType     string
+	other       bool // actor_id, resource_id, success or q is filtered
+}
+
+func newEventCounter(c *fiber.Ctx, where string, whereArgs []interface{}) eventCounter {
+	tenantWhere, tenantArgs := addTenantFilter(" WHERE 1=1", []interface{}{}, c)
+	args := make([]interface{}, len(whereArgs))
+	for i, arg := range whereArgs {
+		if s, ok := arg.(string); ok {
+			arg = utils.CopyString(s)
+		}
+		args[i] = arg
+	}
+	return eventCounter{
+		where:       where,
+		whereArgs:   args,
+		tenantWhere: tenantWhere,
+		tenantArgs:  tenantArgs,
+		from:        utils.CopyString(c.Query("from")),
+		to:          utils.CopyString(c.Query("to")),
+		action:      utils.CopyString(c.Query("action")),
+		resType:     utils.CopyString(c.Query("resource_type")),
+		other:       c.Query("actor_id") != "" || c.Query("resource_id") != "" || c.Query("success") != "" || c.Query("q") != "",
+	}
+}
+
+func (ec eventCounter) count(ctx context.Context, mode string) countResult {
+	switch mode {
+	case countNone:
+		return countResult{}
+	case countExact:
+		var n uint64
+		err := chConn.QueryRow(ctx, "SELECT count() FROM audit.events"+ec.where, ec.whereArgs...).Scan(&n)
+		return countResult{count: int64(n), exact: true, err: err}
+	}
+	return ec.approximate(ctx)
+}
+
+// approximate answers from the pre-aggregated tables when the filters
+// allow it: events_hourly_count for date-only filters and event_catalog for
+// a single action or resource type. Both count deliveries before
+// deduplication. Otherwise it counts up to approxCountCap rows, which is
+// exact below the cap and a lower bound at it.
+func (ec eventCounter) approximate(ctx context.Context) countResult {
+	var query string
+	args := append([]interface{}{}, ec.tenantArgs...)
+	switch {
+	case !ec.other && ec.action == "" && ec.resType == "":
+		query = "SELECT sum(event_count) FROM audit.events_hourly_count" + ec.tenantWhere
+	case !ec.other && (ec.action == "") != (ec.resType == ""):
+		query = "SELECT sum(events) FROM audit.event_catalog" + ec.tenantWhere + " AND kind = ? AND name = ?"
+		if ec.action != "" {
+			args = append(args, "action", ec.action)
+		} else {
+			args = append(args, "resource_type", ec.resType)
+		}
+	}
+
+	if query != "" {
+		dateCol := "event_date"
+		if ec.action != "" || ec.resType != "" {
+			dateCol = "day"
+		}
+		if ec.from != "" {
+			query += " AND " + dateCol + " >= ?"
+			args = append(args, ec.from)
+		}
+		if ec.to != "" {
+			query += " AND " + dateCol + " <= ?"
+			args = append(args, ec.to)
+		}
+		var n uint64
+		err := chConn.QueryRow(ctx, query, args...).Scan(&n)
+		return countResult{count: int64(n), exact: false, err: err}
+	}
+
+	var n uint64
+	capped := "SELECT count() FROM (SELECT 1 FROM audit.events" + ec.where + " LIMIT ?)"
+	err := chConn.QueryRow(ctx, capped, append(append([]interface{}{}, ec.whereArgs...), approxCountCap+1)...).Scan(&n)
+	if n > approxCountCap {
+		return countResult{count: approxCountCap, exact: false, err: err}
+	}
+	return countResult{count: int64(n), exact: true, err: err}
+}
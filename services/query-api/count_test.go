@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestAutoCountMode(t *testing.T) {
+	cases := []struct{ from, to, want string }{
+		{"2025-01-01", "2025-01-31", countExact},
+		{"2025-01-01", "2025-02-01", countExact},
+		{"2025-01-01", "2025-03-01", countApprox},
+		{"2025-01-01", "", countApprox},
+		{"", "", countApprox},
+	}
+	for _, tc := range cases {
+		if got := autoCountMode(tc.from, tc.to); got != tc.want {
+			t.Errorf("autoCountMode(%q, %q) = %s, want %s", tc.from, tc.to, got, tc.want)
+		}
+	}
+	if _, err := parseCountMode("sometimes"); err == nil {
+		t.Error("Expected error for unknown count mode")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCursor_RoundTrip(t *testing.T) {
+	token := encodeCursor(pageCursor{ReceivedAt: 1735689600123, EventID: "0190b3c4-5d6e-7f80-9a1b-2c3d4e5f6a7b", Direction: cursorNext, Filters: "f1", Tenant: "acme"})
+
+	cur, err := decodeCursor(token, "f1", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur.ReceivedAt != 1735689600123 || cur.EventID != "0190b3c4-5d6e-7f80-9a1b-2c3d4e5f6a7b" || cur.Direction != cursorNext {
+		t.Errorf("Unexpected cursor: %+v", cur)
+	}
+}
+
+func TestCursor_RejectsTamperingAndReplay(t *testing.T) {
+	token := encodeCursor(pageCursor{ReceivedAt: 1, EventID: "e", Direction: cursorNext, Filters: "f1", Tenant: "acme"})
+
+	if _, err := decodeCursor(token, "f2", "acme"); err != errCursorMismatch {
+		t.Errorf("Expected mismatch for other filters, got %v", err)
+	}
+	if _, err := decodeCursor(token, "f1", "other"); err != errCursorMismatch {
+		t.Errorf("Expected mismatch for other tenant, got %v", err)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := encodeCursor(pageCursor{ReceivedAt: 1, EventID: "e", Direction: cursorNext, Filters: "f1", Tenant: "other"})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	for _, bad := range []string{forgedPayload + "." + sig, payload, payload + ".xx", "garbage"} {
+		if _, err := decodeCursor(bad, "f1", "acme"); err != errInvalidCursor {
+			t.Errorf("Expected invalid cursor for %q, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportMaxRows bounds a single synchronous export; larger result sets go
+// through export jobs (POST /v1/exports)
+const exportMaxRows = 100000
+
+// exportBatchRows is how many rows go into one Arrow record batch or
+// Parquet row group
+const exportBatchRows = 10000
+
+// Type mapping shared by every format:
+//
+//	received_at  RFC 3339 UTC with milliseconds in text formats,
+//	             timestamp[ms, UTC] in Arrow and Parquet
+//	event_date   YYYY-MM-DD in text formats, date32 in Arrow and Parquet
+//	booleans     true/false in CSV, JSON booleans, boolean columns
+//	nested maps  JSON objects in JSON and NDJSON, JSON-encoded strings
+//	             in CSV, Arrow and Parquet
+type exportKind int
+
+const (
+	exportString exportKind = iota
+	exportBool
+	exportTimestamp
+	exportDate
+	exportMap
+)
+
+const exportTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// exportColumn is a column that can be exported. Columns without expr are
+// taken from raw_event.
+type exportColumn struct {
+	name string
+	expr string
+	kind exportKind
+}
+
+var exportColumns = []exportColumn{
+	{"event_id", "toString(event_id)", exportString},
+	{"tenant_id", "tenant_id", exportString},
+	{"event_date", "event_date", exportDate},
+	{"received_at", "received_at", exportTimestamp},
+	{"actor_id", "actor_id", exportString},
+	{"actor_type", "actor_type", exportString},
+	{"actor_email", "actor_email", exportString},
+	{"action", "action_name", exportString},
+	{"resource_type", "resource_type", exportString},
+	{"resource_id", "resource_id", exportString},
+	{"success", "result_success", exportBool},
+	{"result_message", "result_message", exportString},
+	{"context_ip", "context_ip", exportString},
+	{"context_user_agent", "context_user_agent", exportString},
+	{"context", "", exportMap},
+	{"metadata", "", exportMap},
+}
+
+var defaultExportColumns = []string{"event_id", "event_date", "received_at", "actor_id", "action", "resource_type", "resource_id", "success", "context"}
+
+// exportFormats maps each format to its content type and file extension
+var exportFormats = map[string]struct{ contentType, ext string }{
+	"csv":     {"text/csv", "csv"},
+	"ndjson":  {"application/x-ndjson", "ndjson"},
+	"json":    {"application/json", "json"},
+	"parquet": {"application/vnd.apache.parquet", "parquet"},
+	"arrow":   {"application/vnd.apache.arrow.stream", "arrow"},
+}
+
+// parseExportColumns resolves a comma-separated columns parameter
+func parseExportColumns(s string) ([]exportColumn, error) {
+	names := defaultExportColumns
+	if strings.TrimSpace(s) != "" {
+		names = strings.Split(s, ",")
+	}
+	var cols []exportColumn
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		col, ok := findExportColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		seen[name] = true
+		cols = append(cols, col)
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no columns selected")
+	}
+	return cols, nil
+}
+
+func findExportColumn(name string) (exportColumn, bool) {
+	for _, col := range exportColumns {
+		if col.name == name {
+			return col, true
+		}
+	}
+	return exportColumn{}, false
+}
+
+// exportQuery builds the SELECT for the columns; raw_event and then any
+// extra expressions follow when a nested column needs it
+func exportQuery(cols []exportColumn, where string, extra ...string) (string, bool) {
+	var exprs []string
+	needRaw := false
+	for _, col := range cols {
+		if col.expr == "" {
+			needRaw = true
+			continue
+		}
+		exprs = append(exprs, col.expr)
+	}
+	if needRaw {
+		exprs = append(exprs, "raw_event")
+	}
+	exprs = append(exprs, extra...)
+	return "SELECT " + strings.Join(exprs, ", ") + " FROM audit.events" + where, needRaw
+}
+
+func exportHandler(c *fiber.Ctx) error {
+	format := c.Query("format", "csv")
+	spec, ok := exportFormats[format]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be one of csv, ndjson, json, parquet, arrow"})
+	}
+	cols, err := parseExportColumns(c.Query("columns"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Build query with the list filters
+	where, args := listFilters(c)
+	qWhere, qArgs, err := fieldQueryFilter(c.Query("q"))
+	if err != nil {
+		return queryError(c, err)
+	}
+	where += qWhere
+	args = append(args, qArgs...)
+	query, needRaw := exportQuery(cols, where)
+	query += " ORDER BY received_at DESC LIMIT ?"
+	args = append(args, exportMaxRows)
+
+	rows, err := chConn.Query(context.Background(), query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set("Content-Type", spec.contentType)
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-events-%s.%s\"", time.Now().Format("2006-01-02"), spec.ext))
+
+	// The body is written after the handler returns, so rows are closed there
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer rows.Close()
+		if err := writeExport(w, format, cols, needRaw, rows); err != nil {
+			log.Printf("Export failed: %v", err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// writeExport streams rows from the ClickHouse cursor through the
+// format's encoder
+func writeExport(w io.Writer, format string, cols []exportColumn, needRaw bool, rows driver.Rows) error {
+	enc, err := newExportEncoder(w, format, cols)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		row, err := scanExportRow(rows, cols, needRaw)
+		if err != nil {
+			return err
+		}
+		if err := enc.write(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+// scanExportRow reads one row into Go values matching each column's kind:
+// string, bool, time.Time or map[string]interface{}. Extra expressions of
+// the query are scanned into extra.
+func scanExportRow(rows driver.Rows, cols []exportColumn, needRaw bool, extra ...interface{}) ([]interface{}, error) {
+	dest := make([]interface{}, 0, len(cols)+1)
+	for _, col := range cols {
+		switch col.kind {
+		case exportBool:
+			dest = append(dest, new(bool))
+		case exportTimestamp, exportDate:
+			dest = append(dest, new(time.Time))
+		case exportMap:
+			// Filled from raw_event below
+		default:
+			dest = append(dest, new(string))
+		}
+	}
+	var raw string
+	if needRaw {
+		dest = append(dest, &raw)
+	}
+	dest = append(dest, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	var full Event
+	if needRaw {
+		full, _ = eventFromRaw(Event{}, raw)
+	}
+	row := make([]interface{}, len(cols))
+	i := 0
+	for n, col := range cols {
+		if col.kind == exportMap {
+			switch col.name {
+			case "context":
+				row[n] = full.Context
+			case "metadata":
+				row[n] = full.Metadata
+			}
+			continue
+		}
+		switch d := dest[i].(type) {
+		case *bool:
+			row[n] = *d
+		case *time.Time:
+			row[n] = *d
+		case *string:
+			row[n] = *d
+		}
+		i++
+	}
+	return row, nil
+}
+
+type exportEncoder interface {
+	write(row []interface{}) error
+	close() error
+}
+
+func newExportEncoder(w io.Writer, format string, cols []exportColumn) (exportEncoder, error) {
+	switch format {
+	case "csv":
+		return newCSVEncoder(w, cols)
+	case "ndjson":
+		return &jsonEncoder{w: w, cols: cols}, nil
+	case "json":
+		return &jsonEncoder{w: w, cols: cols, array: true}, nil
+	case "parquet", "arrow":
+		return newArrowEncoder(w, cols, format == "parquet")
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// textValue renders a value for CSV
+func textValue(col exportColumn, v interface{}) string {
+	switch col.kind {
+	case exportBool:
+		return strconv.FormatBool(v.(bool))
+	case exportTimestamp:
+		return v.(time.Time).UTC().Format(exportTimeFormat)
+	case exportDate:
+		return v.(time.Time).Format("2006-01-02")
+	case exportMap:
+		return mapJSON(v)
+	}
+	return v.(string)
+}
+
+// mapJSON encodes a nested map, with a missing map as {}
+func mapJSON(v interface{}) string {
+	m, _ := v.(map[string]interface{})
+	if m == nil {
+		return "{}"
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+type csvEncoder struct {
+	w    *csv.Writer
+	cols []exportColumn
+}
+
+func newCSVEncoder(w io.Writer, cols []exportColumn) (*csvEncoder, error) {
+	enc := &csvEncoder{w: csv.NewWriter(w), cols: cols}
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = col.name
+	}
+	return enc, enc.w.Write(header)
+}
+
+func (e *csvEncoder) write(row []interface{}) error {
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = textValue(e.cols[i], v)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvEncoder) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonEncoder writes one object per line, or a single array when array is set
+type jsonEncoder struct {
+	w     io.Writer
+	cols  []exportColumn
+	array bool
+	n     int
+}
+
+func (e *jsonEncoder) write(row []interface{}) error {
+	obj := make(map[string]interface{}, len(row))
+	for i, v := range row {
+		col := e.cols[i]
+		switch col.kind {
+		case exportTimestamp:
+			obj[col.name] = v.(time.Time).UTC().Format(exportTimeFormat)
+		case exportDate:
+			obj[col.name] = v.(time.Time).Format("2006-01-02")
+		case exportMap:
+			if m, _ := v.(map[string]interface{}); m != nil {
+				obj[col.name] = m
+			} else {
+				obj[col.name] = map[string]interface{}{}
+			}
+		default:
+			obj[col.name] = v
+		}
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if e.array {
+		if e.n == 0 {
+			out = append(out, "[\n"...)
+		} else {
+			out = append(out, ",\n"...)
+		}
+		out = append(out, b...)
+	} else {
+		out = append(b, '\n')
+	}
+	e.n++
+	_, err = e.w.Write(out)
+	return err
+}
+
+func (e *jsonEncoder) close() error {
+	if !e.array {
+		return nil
+	}
+	end := "\n]\n"
+	if e.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// arrowEncoder builds record batches of exportBatchRows rows and writes
+// them as an Arrow IPC stream or as Parquet row groups
+type arrowEncoder struct {
+	cols        []exportColumn
+	builder     *array.RecordBuilder
+	writeRecord func(arrow.Record) error
+	finish      func() error
+}
+
+func exportSchema(cols []exportColumn) *arrow.Schema {
+	fields := make([]arrow.Field, len(cols))
+	for i, col := range cols {
+		var typ arrow.DataType
+		switch col.kind {
+		case exportBool:
+			typ = arrow.FixedWidthTypes.Boolean
+		case exportTimestamp:
+			typ = &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}
+		case exportDate:
+			typ = arrow.FixedWidthTypes.Date32
+		default:
+			typ = arrow.BinaryTypes.String
+		}
+		fields[i] = arrow.Field{Name: col.name, Type: typ}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+func newArrowEncoder(w io.Writer, cols []exportColumn, asParquet bool) (*arrowEncoder, error) {
+	schema := exportSchema(cols)
+	enc := &arrowEncoder{cols: cols, builder: array.NewRecordBuilder(memory.DefaultAllocator, schema)}
+	if asParquet {
+		props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+		fw, err := pqarrow.NewFileWriter(schema, w, props, pqarrow.DefaultWriterProps())
+		if err != nil {
+			return nil, err
+		}
+		enc.writeRecord, enc.finish = fw.Write, fw.Close
+	} else {
+		iw := ipc.NewWriter(w, ipc.WithSchema(schema))
+		enc.writeRecord, enc.finish = iw.Write, iw.Close
+	}
+	return enc, nil
+}
+
+func (e *arrowEncoder) write(row []interface{}) error {
+	for i, v := range row {
+		switch b := e.builder.Field(i).(type) {
+		case *array.BooleanBuilder:
+			b.Append(v.(bool))
+		case *array.TimestampBuilder:
+			b.Append(arrow.Timestamp(v.(time.Time).UnixMilli()))
+		case *array.Date32Builder:
+			b.Append(arrow.Date32FromTime(v.(time.Time)))
+		case *array.StringBuilder:
+			b.Append(textValue(e.cols[i], v))
+		}
+	}
+	if e.builder.Field(0).Len() >= exportBatchRows {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *arrowEncoder) flush() error {
+	rec := e.builder.NewRecord()
+	defer rec.Release()
+	if rec.NumRows() == 0 {
+		return nil
+	}
+	return e.writeRecord(rec)
+}
+
+func (e *arrowEncoder) close() error {
+	defer e.builder.Release()
+	if err := e.flush(); err != nil {
+		return err
+	}
+	return e.finish()
+}
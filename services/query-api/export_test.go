@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+var exportTestRows = [][]interface{}{
+	{"e1", time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC), true, map[string]interface{}{"ip": "10.0.0.1"}},
+	{"e2", time.Date(2025, 3, 1, 10, 0, 1, 500e6, time.UTC), false, nil},
+}
+
+func encodeTestRows(t *testing.T, format string) ([]exportColumn, []byte) {
+	t.Helper()
+	cols, err := parseExportColumns("event_id, received_at,success,context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc, err := newExportEncoder(&buf, format, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range exportTestRows {
+		if err := enc.write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.close(); err != nil {
+		t.Fatal(err)
+	}
+	return cols, buf.Bytes()
+}
+
+func TestExport_TextFormats(t *testing.T) {
+	_, out := encodeTestRows(t, "csv")
+	want := "event_id,received_at,success,context\n" +
+		"e1,2025-03-01T10:00:00.000Z,true,\"{\"\"ip\"\":\"\"10.0.0.1\"\"}\"\n" +
+		"e2,2025-03-01T10:00:01.500Z,false,{}\n"
+	if string(out) != want {
+		t.Errorf("csv:\n%s\nwant:\n%s", out, want)
+	}
+
+	_, out = encodeTestRows(t, "ndjson")
+	want = `{"context":{"ip":"10.0.0.1"},"event_id":"e1","received_at":"2025-03-01T10:00:00.000Z","success":true}` + "\n" +
+		`{"context":{},"event_id":"e2","received_at":"2025-03-01T10:00:01.500Z","success":false}` + "\n"
+	if string(out) != want {
+		t.Errorf("ndjson:\n%s\nwant:\n%s", out, want)
+	}
+
+	_, out = encodeTestRows(t, "json")
+	if !strings.HasPrefix(string(out), "[\n{") || !strings.HasSuffix(string(out), "}\n]\n") || strings.Count(string(out), "\n,") != 0 {
+		t.Errorf("json:\n%s", out)
+	}
+}
+
+func TestExport_ArrowAndParquet(t *testing.T) {
+	check := func(format string, rec arrow.Record) {
+		if rec.NumRows() != 2 {
+			t.Fatalf("%s: %d rows", format, rec.NumRows())
+		}
+		ts := rec.Column(1).(*array.Timestamp)
+		if ts.Value(1) != arrow.Timestamp(time.Date(2025, 3, 1, 10, 0, 1, 500e6, time.UTC).UnixMilli()) {
+			t.Errorf("%s: received_at = %v", format, ts.Value(1))
+		}
+		if !rec.Column(2).(*array.Boolean).Value(0) {
+			t.Errorf("%s: success not true", format)
+		}
+		if got := rec.Column(3).(*array.String).Value(0); got != `{"ip":"10.0.0.1"}` {
+			t.Errorf("%s: context = %s", format, got)
+		}
+	}
+
+	_, out := encodeTestRows(t, "arrow")
+	r, err := ipc.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Release()
+	if !r.Next() {
+		t.Fatal("arrow: no record")
+	}
+	check("arrow", r.Record())
+
+	_, out = encodeTestRows(t, "parquet")
+	tbl, err := pqarrow.ReadTable(context.Background(), bytes.NewReader(out), nil, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tbl.Release()
+	tr := array.NewTableReader(tbl, 0)
+	defer tr.Release()
+	if !tr.Next() {
+		t.Fatal("parquet: no record")
+	}
+	check("parquet", tr.Record())
+}
+
+func TestExport_Columns(t *testing.T) {
+	if _, err := parseExportColumns("event_id,raw_event"); err == nil {
+		t.Error("Expected error for unknown column")
+	}
+	cols, _ := parseExportColumns("")
+	query, needRaw := exportQuery(cols, " WHERE 1=1")
+	if !needRaw || !strings.HasSuffix(query, ", raw_event FROM audit.events WHERE 1=1") {
+		t.Errorf("Default export query = %s", query)
+	}
+}
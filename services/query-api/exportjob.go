@@ -0,0 +1,751 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Export job states
+const (
+	exportQueued    = "queued"
+	exportRunning   = "running"
+	exportCompleted = "completed"
+	exportFailed    = "failed"
+)
+
+// exportMaxAttempts is how often a job is retried after an error before
+// it is marked failed
+const exportMaxAttempts = 3
+
+// ExportJob is the persisted state of an asynchronous export. It is stored
+// as "<id>/job.json" next to its result files and rewritten after every
+// chunk, so a job picks up after the last written chunk when restarted.
+// Unfinished jobs also have an "_active/<id>" marker, so resuming does not
+// read every job ever run.
+type ExportJob struct {
+	ID          string            `json:"id"`
+	Tenant      string            `json:"tenant"`
+	AllTenants  bool              `json:"all_tenants,omitempty"`
+	Status      string            `json:"status"`
+	Format      string            `json:"format"`
+	Columns     []string          `json:"columns"`
+	Filters     map[string]string `json:"filters"`
+	TotalRows   *int64            `json:"total_rows,omitempty"`
+	RowsWritten int64             `json:"rows_written"`
+	Files       []ExportFile      `json:"files"`
+	Error       string            `json:"error,omitempty"`
+	Attempts    int               `json:"attempts"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+
+	// Keyset position after the last written chunk
+	After *exportPosition `json:"after,omitempty"`
+	// The replica working on the job and until when it holds it
+	Owner      string    `json:"owner,omitempty"`
+	LeaseUntil time.Time `json:"lease_until"`
+}
+
+type exportPosition struct {
+	ReceivedAt int64  `json:"received_at"` // Unix milliseconds
+	EventID    string `json:"event_id"`
+}
+
+// ExportFile is one chunk of an export's result
+type ExportFile struct {
+	Name  string `json:"name"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"`
+}
+
+// ExportJobRequest is the body of POST /v1/exports
+type ExportJobRequest struct {
+	Format  string            `json:"format"`
+	Columns []string          `json:"columns"`
+	Filters map[string]string `json:"filters"`
+}
+
+// ExportJobResponse is what clients see of a job
+type ExportJobResponse struct {
+	ID          string               `json:"id"`
+	Status      string               `json:"status"`
+	Format      string               `json:"format"`
+	Columns     []string             `json:"columns"`
+	Filters     map[string]string    `json:"filters"`
+	TotalRows   *int64               `json:"total_rows,omitempty"`
+	RowsWritten int64                `json:"rows_written"`
+	Progress    *float64             `json:"progress,omitempty"`
+	Files       []ExportFileResponse `json:"files"`
+	Error       string               `json:"error,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+	CompletedAt *time.Time           `json:"completed_at,omitempty"`
+}
+
+type ExportFileResponse struct {
+	ExportFile
+	URL       string     `json:"url,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type exportConfig struct {
+	ChunkRows int
+	Workers   int
+	URLTTL    time.Duration
+	Lease     time.Duration
+	// Jobs and their files are deleted once job.json has not changed for
+	// this long
+	Retention time.Duration
+}
+
+// exportActivePrefix holds the markers of unfinished jobs
+const exportActivePrefix = "_active/"
+
+// exportManager runs export jobs on a small worker pool and resumes jobs
+// whose lease ran out, including this replica's own after a restart
+type exportManager struct {
+	store exportStore
+	cfg   exportConfig
+	owner string
+	queue chan string
+
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+var exports *exportManager
+
+// initExports sets up export jobs from EXPORT_STORAGE and starts the
+// workers
+func initExports() error {
+	store, err := newExportStore(getEnv("EXPORT_STORAGE", "/tmp/audit-exports"))
+	if err != nil {
+		return err
+	}
+	cfg := exportConfig{
+		ChunkRows: exportMaxRows,
+		Workers:   2,
+		URLTTL:    15 * time.Minute,
+		Lease:     2 * time.Minute,
+		Retention: 7 * 24 * time.Hour,
+	}
+	if n, err := strconv.Atoi(getEnv("EXPORT_CHUNK_ROWS", "")); err == nil && n > 0 {
+		cfg.ChunkRows = n
+	}
+	if n, err := strconv.Atoi(getEnv("EXPORT_WORKERS", "")); err == nil && n > 0 {
+		cfg.Workers = n
+	}
+	if d, err := time.ParseDuration(getEnv("EXPORT_URL_TTL", "")); err == nil && d > 0 {
+		cfg.URLTTL = d
+	}
+	if d, err := time.ParseDuration(getEnv("EXPORT_RETENTION", "")); err == nil && d > 0 {
+		cfg.Retention = d
+	}
+	owner, _ := os.Hostname()
+	exports = newExportManager(store, cfg, owner+"/"+uuid.NewString()[:8])
+	exports.start()
+	return nil
+}
+
+func newExportManager(store exportStore, cfg exportConfig, owner string) *exportManager {
+	return &exportManager{
+		store:  store,
+		cfg:    cfg,
+		owner:  owner,
+		queue:  make(chan string, 1000),
+		active: map[string]bool{},
+	}
+}
+
+func (m *exportManager) start() {
+	for i := 0; i < m.cfg.Workers; i++ {
+		go func() {
+			for id := range m.queue {
+				m.run(id)
+			}
+		}()
+	}
+	go func() {
+		var swept time.Time
+		for {
+			m.resume(context.Background())
+			if time.Since(swept) > time.Hour {
+				m.sweep(context.Background())
+				swept = time.Now()
+			}
+			time.Sleep(m.cfg.Lease / 2)
+		}
+	}()
+}
+
+func (m *exportManager) load(ctx context.Context, id string) (*ExportJob, error) {
+	job, _, err := m.loadVersion(ctx, id)
+	return job, err
+}
+
+// loadVersion reads a job with the version a conditional save expects
+func (m *exportManager) loadVersion(ctx context.Context, id string) (*ExportJob, string, error) {
+	data, version, err := m.store.GetVersion(ctx, id+"/job.json")
+	if err != nil {
+		return nil, "", err
+	}
+	var job ExportJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, "", err
+	}
+	return &job, version, nil
+}
+
+func (m *exportManager) save(ctx context.Context, job *ExportJob) error {
+	job.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return m.store.Put(ctx, job.ID+"/job.json", bytes.NewReader(data), int64(len(data)))
+}
+
+// create saves a new job and marks it unfinished
+func (m *exportManager) create(ctx context.Context, job *ExportJob) error {
+	if err := m.save(ctx, job); err != nil {
+		return err
+	}
+	return m.store.Put(ctx, exportActivePrefix+job.ID, bytes.NewReader(nil), 0)
+}
+
+// finish drops the marker of a job that completed or failed
+func (m *exportManager) finish(ctx context.Context, id string) {
+	if err := m.store.Delete(ctx, exportActivePrefix+id); err != nil {
+		log.Printf("Export %s: %v", id, err)
+	}
+}
+
+// enqueue hands a job to the workers unless this replica already has it
+func (m *exportManager) enqueue(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active[id] {
+		return
+	}
+	select {
+	case m.queue <- id:
+		m.active[id] = true
+	default:
+		// The next resume pass picks it up
+	}
+}
+
+// resume queues unfinished jobs nobody holds a lease on
+func (m *exportManager) resume(ctx context.Context) {
+	markers, err := m.store.List(ctx, exportActivePrefix)
+	if err != nil {
+		log.Printf("Listing export jobs: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, marker := range markers {
+		id := strings.TrimPrefix(marker.Key, exportActivePrefix)
+		job, err := m.load(ctx, id)
+		switch {
+		case errors.Is(err, errExportNotFound):
+			m.finish(ctx, id)
+		case err != nil:
+			continue
+		case job.Status == exportCompleted || job.Status == exportFailed:
+			m.finish(ctx, id)
+		case now.After(job.LeaseUntil):
+			m.enqueue(id)
+		}
+	}
+}
+
+// sweep deletes jobs and their files once job.json is older than the
+// retention. Running jobs rewrite it at least once per lease.
+func (m *exportManager) sweep(ctx context.Context) {
+	objects, err := m.store.List(ctx, "")
+	if err != nil {
+		log.Printf("Listing exports: %v", err)
+		return
+	}
+	cutoff := time.Now().Add(-m.cfg.Retention)
+	expired := map[string]bool{}
+	for _, obj := range objects {
+		if id, ok := strings.CutSuffix(obj.Key, "/job.json"); ok && obj.Modified.Before(cutoff) {
+			expired[id] = true
+		}
+	}
+	// job.json goes last, so an interrupted sweep is picked up again
+	var jobs []string
+	for _, obj := range objects {
+		id, _, _ := strings.Cut(strings.TrimPrefix(obj.Key, exportActivePrefix), "/")
+		if !expired[id] {
+			continue
+		}
+		if strings.HasSuffix(obj.Key, "/job.json") {
+			jobs = append(jobs, obj.Key)
+			continue
+		}
+		if err := m.store.Delete(ctx, obj.Key); err != nil {
+			log.Printf("Deleting %s: %v", obj.Key, err)
+			delete(expired, id)
+		}
+	}
+	for _, key := range jobs {
+		if id, _ := strings.CutSuffix(key, "/job.json"); expired[id] {
+			if err := m.store.Delete(ctx, key); err != nil {
+				log.Printf("Deleting %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// exportLease is a replica's hold on a job. Every write of job.json is
+// conditional on the version this replica wrote last, so of two replicas
+// that saw a lease run out only one takes the job over, and one that lost
+// its job stops instead of overwriting the new owner's progress.
+type exportLease struct {
+	m  *exportManager
+	id string
+
+	mu      sync.Mutex
+	saved   ExportJob // as last written, for renewals
+	version string
+	lost    bool
+}
+
+// save writes job as it is
+func (l *exportLease) save(ctx context.Context, job *ExportJob) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	job.UpdatedAt = time.Now().UTC()
+	return l.write(ctx, *job)
+}
+
+// renew extends the lease of the job as last saved, so it is safe while
+// the export is changing the job
+func (l *exportLease) renew(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	job := l.saved
+	job.LeaseUntil = time.Now().Add(l.m.cfg.Lease)
+	return l.write(ctx, job)
+}
+
+func (l *exportLease) write(ctx context.Context, job ExportJob) error {
+	if l.lost {
+		return errExportConflict
+	}
+	job.Files = append([]ExportFile(nil), job.Files...)
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	version, err := l.m.store.PutIf(ctx, l.id+"/job.json", data, l.version)
+	if errors.Is(err, errExportConflict) {
+		l.lost = true
+	}
+	if err != nil {
+		return err
+	}
+	l.saved, l.version = job, version
+	return nil
+}
+
+func (l *exportLease) isLost() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// hold renews the lease while a chunk runs, until the returned stop is
+// called. Losing the lease cancels the export.
+func (l *exportLease) hold(cancel context.CancelFunc) (stop func()) {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(l.m.cfg.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if err := l.renew(context.Background()); err != nil {
+				log.Printf("Export %s: renewing lease: %v", l.id, err)
+				if errors.Is(err, errExportConflict) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// run exports a job chunk by chunk from where it left off
+func (m *exportManager) run(id string) {
+	defer func() {
+		m.mu.Lock()
+		delete(m.active, id)
+		m.mu.Unlock()
+	}()
+	ctx := context.Background()
+
+	job, version, err := m.loadVersion(ctx, id)
+	if err != nil {
+		log.Printf("Export %s: %v", id, err)
+		return
+	}
+	if job.Status == exportCompleted || job.Status == exportFailed {
+		m.finish(ctx, id)
+		return
+	}
+	if job.Owner != m.owner && time.Now().Before(job.LeaseUntil) {
+		return
+	}
+	job.Status, job.Owner = exportRunning, m.owner
+	job.LeaseUntil = time.Now().Add(m.cfg.Lease)
+	lease := &exportLease{m: m, id: id, version: version}
+	if err := lease.save(ctx, job); err != nil {
+		log.Printf("Export %s: %v", id, err)
+		return
+	}
+
+	exportCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := lease.hold(cancel)
+	err = m.export(exportCtx, lease, job)
+	stop()
+	if lease.isLost() {
+		log.Printf("Export %s: taken over by another replica", id)
+		return
+	}
+	if err != nil {
+		job.Attempts++
+		job.Error = err.Error()
+		log.Printf("Export %s attempt %d: %v", id, job.Attempts, err)
+		if job.Attempts >= exportMaxAttempts {
+			job.Status = exportFailed
+		} else {
+			// Release the lease so a resume pass retries it
+			job.LeaseUntil = time.Now().Add(m.cfg.Lease / 2)
+		}
+		if err := lease.save(ctx, job); err != nil {
+			log.Printf("Export %s: %v", id, err)
+		} else if job.Status == exportFailed {
+			m.finish(ctx, id)
+		}
+		return
+	}
+
+	now := time.Now().UTC()
+	job.Status, job.Error, job.CompletedAt = exportCompleted, "", &now
+	if err := lease.save(ctx, job); err != nil {
+		log.Printf("Export %s: %v", id, err)
+		return
+	}
+	m.finish(ctx, id)
+}
+
+// principal is the scope the job exports, that of its creator
+func (job *ExportJob) principal() principal {
+	return principal{Tenant: job.Tenant, Admin: job.AllTenants}
+}
+
+// visibleTo reports whether p may see the job. Admins see every job;
+// jobs of admins are hidden from tenants.
+func (job *ExportJob) visibleTo(p principal) bool {
+	return p.Admin || (!job.AllTenants && job.Tenant == p.Tenant)
+}
+
+// export writes the remaining chunks, saving the job after each one
+func (m *exportManager) export(ctx context.Context, lease *exportLease, job *ExportJob) error {
+	if chConn == nil {
+		return errors.New("ClickHouse not available")
+	}
+	cols, err := parseExportColumns(strings.Join(job.Columns, ","))
+	if err != nil {
+		return err
+	}
+	where, args := filterWhere(job.principal(), func(key string) string { return job.Filters[key] })
+	qWhere, qArgs, err := fieldQueryFilter(job.Filters["q"])
+	if err != nil {
+		return err
+	}
+	where += qWhere
+	args = append(args, qArgs...)
+
+	if job.TotalRows == nil {
+		var n uint64
+		if err := chConn.QueryRow(ctx, "SELECT count() FROM audit.events"+where, args...).Scan(&n); err != nil {
+			return err
+		}
+		total := int64(n)
+		job.TotalRows = &total
+	}
+
+	for {
+		written, err := m.exportChunk(ctx, job, cols, where, args)
+		if err != nil {
+			return err
+		}
+		job.LeaseUntil = time.Now().Add(m.cfg.Lease)
+		if err := lease.save(ctx, job); err != nil {
+			return err
+		}
+		if written < int64(m.cfg.ChunkRows) {
+			return nil
+		}
+	}
+}
+
+// exportChunk writes the next chunk of rows after job.After to a new file
+// and advances the job. An empty chunk is only written when the export
+// has no files yet, so an empty result still has a file to download.
+func (m *exportManager) exportChunk(ctx context.Context, job *ExportJob, cols []exportColumn, where string, args []interface{}) (int64, error) {
+	query, needRaw := exportQuery(cols, where, "toUnixTimestamp64Milli(received_at)", "toString(event_id)")
+	args = append([]interface{}{}, args...)
+	if job.After != nil {
+		query += " AND (received_at, event_id) < (fromUnixTimestamp64Milli(?), toUUID(?))"
+		args = append(args, job.After.ReceivedAt, job.After.EventID)
+	}
+	query += " ORDER BY received_at DESC, event_id DESC LIMIT ?"
+	args = append(args, m.cfg.ChunkRows)
+
+	rows, err := chConn.Query(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	tmp, err := os.CreateTemp("", "export-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	enc, err := newExportEncoder(tmp, job.Format, cols)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	var last exportPosition
+	for rows.Next() {
+		row, err := scanExportRow(rows, cols, needRaw, &last.ReceivedAt, &last.EventID)
+		if err != nil {
+			return 0, err
+		}
+		if err := enc.write(row); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := enc.close(); err != nil {
+		return 0, err
+	}
+	if n == 0 && len(job.Files) > 0 {
+		return 0, nil
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	name := fmt.Sprintf("part-%05d.%s", len(job.Files)+1, exportFormats[job.Format].ext)
+	if err := m.store.Put(ctx, job.ID+"/"+name, tmp, size); err != nil {
+		return 0, err
+	}
+
+	job.Files = append(job.Files, ExportFile{Name: name, Rows: n, Bytes: size})
+	job.RowsWritten += n
+	if n > 0 {
+		job.After = &last
+	}
+	return n, nil
+}
+
+// response describes a job with fresh download links once it completed
+func (m *exportManager) response(ctx context.Context, job *ExportJob) (ExportJobResponse, error) {
+	resp := ExportJobResponse{
+		ID:          job.ID,
+		Status:      job.Status,
+		Format:      job.Format,
+		Columns:     job.Columns,
+		Filters:     job.Filters,
+		TotalRows:   job.TotalRows,
+		RowsWritten: job.RowsWritten,
+		Files:       []ExportFileResponse{},
+		Error:       job.Error,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+		CompletedAt: job.CompletedAt,
+	}
+	if job.TotalRows != nil {
+		progress := 1.0
+		if *job.TotalRows > 0 {
+			progress = float64(job.RowsWritten) / float64(*job.TotalRows)
+		}
+		if progress > 1 || job.Status == exportCompleted {
+			progress = 1
+		}
+		resp.Progress = &progress
+	}
+	for _, f := range job.Files {
+		file := ExportFileResponse{ExportFile: f}
+		if job.Status == exportCompleted {
+			u, err := m.store.URL(ctx, job.ID+"/"+f.Name, m.cfg.URLTTL)
+			if err != nil {
+				return resp, err
+			}
+			expires := time.Now().Add(m.cfg.URLTTL).UTC()
+			file.URL, file.ExpiresAt = u, &expires
+		}
+		resp.Files = append(resp.Files, file)
+	}
+	return resp, nil
+}
+
+// createExportHandler validates and queues an export job. Jobs are
+// scoped to the tenant of the consumer that created them.
+func createExportHandler(c *fiber.Ctx) error {
+	if exports == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "exports not available"})
+	}
+	var req ExportJobRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid JSON body"})
+	}
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+	if _, ok := exportFormats[req.Format]; !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be one of csv, ndjson, json, parquet, arrow"})
+	}
+	cols, err := parseExportColumns(strings.Join(req.Columns, ","))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	filters := map[string]string{}
+	for key, value := range req.Filters {
+		if !isListFilterParam(key) {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown filter %q", key)})
+		}
+		if value != "" {
+			filters[key] = value
+		}
+	}
+	if _, _, err := fieldQueryFilter(filters["q"]); err != nil {
+		return queryError(c, err)
+	}
+
+	columns := make([]string, len(cols))
+	for i, col := range cols {
+		columns[i] = col.name
+	}
+	now := time.Now().UTC()
+	p := currentPrincipal(c)
+	job := &ExportJob{
+		ID:         uuid.NewString(),
+		Tenant:     p.Tenant,
+		AllTenants: p.Admin,
+		Status:     exportQueued,
+		Format:     req.Format,
+		Columns:    columns,
+		Filters:    filters,
+		Files:      []ExportFile{},
+		CreatedAt:  now,
+		// Held for this replica until a worker starts it
+		Owner:      exports.owner,
+		LeaseUntil: now.Add(exports.cfg.Lease),
+	}
+	if err := exports.create(c.Context(), job); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	exports.enqueue(job.ID)
+
+	resp, err := exports.response(c.Context(), job)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set("Location", "/v1/exports/"+job.ID)
+	return c.Status(202).JSON(resp)
+}
+
+func getExportHandler(c *fiber.Ctx) error {
+	if exports == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "exports not available"})
+	}
+	job, err := exports.load(c.Context(), c.Params("id"))
+	// Other tenants' jobs look the same as missing ones
+	if errors.Is(err, errExportNotFound) || (err == nil && !job.visibleTo(currentPrincipal(c))) {
+		return c.Status(404).JSON(fiber.Map{"error": "export not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	resp, err := exports.response(c.Context(), job)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(resp)
+}
+
+// exportFileHandler serves files of the local store. The signed link is
+// the authorization, so it works without an API key.
+func exportFileHandler(c *fiber.Ctx) error {
+	if exports == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "exports not available"})
+	}
+	id, name := c.Params("id"), c.Params("name")
+	if _, local := exports.store.(dirStore); !local || name == "job.json" ||
+		!verifyExportFileSignature(id, name, c.Query("expires"), c.Query("signature")) {
+		return c.Status(403).JSON(fiber.Map{"error": "invalid or expired link"})
+	}
+	r, err := exports.store.Get(c.Context(), id+"/"+name)
+	if errors.Is(err, errExportNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "export not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, spec := range exportFormats {
+		if strings.HasSuffix(name, "."+spec.ext) {
+			c.Set("Content-Type", spec.contentType)
+		}
+	}
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	return c.SendStream(r)
+}
+
+func isListFilterParam(key string) bool {
+	for _, p := range listFilterParams {
+		if p == key {
+			return true
+		}
+	}
+	return false
+}
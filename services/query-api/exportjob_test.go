@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newTestExports(t *testing.T) *fiber.App {
+	t.Helper()
+	store, err := newExportStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := exports
+	exports = newExportManager(store, exportConfig{ChunkRows: 10, Workers: 1, URLTTL: time.Minute, Lease: time.Minute}, "test")
+	t.Cleanup(func() { exports = saved })
+
+	app := fiber.New()
+	// As in main, the signed file links bypass authorize
+	app.Get("/v1/exports/:id/files/:name", exportFileHandler)
+	app.Use(authorize(newAuthorizer("admin", "events:read", staticConsumers{
+		"tenant-a": {Tenant: "tenant-a", Roles: []string{"events:read"}},
+		"tenant-b": {Tenant: "tenant-b", Roles: []string{"events:read"}},
+	})))
+	app.Post("/v1/exports", createExportHandler)
+	app.Get("/v1/exports/:id", getExportHandler)
+	return app
+}
+
+func doExportRequest(t *testing.T, app *fiber.App, method, target, consumer, body string) (int, ExportJobResponse) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Consumer-Name", consumer)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job ExportJobResponse
+	json.NewDecoder(resp.Body).Decode(&job)
+	return resp.StatusCode, job
+}
+
+func TestExportJobs_CreateIsTenantScoped(t *testing.T) {
+	app := newTestExports(t)
+
+	status, job := doExportRequest(t, app, "POST", "/v1/exports", "tenant-a",
+		`{"format":"parquet","filters":{"from":"2025-01-01","q":"actor.id:alice"}}`)
+	if status != 202 || job.Status != exportQueued || job.ID == "" {
+		t.Fatalf("Create: status %d, job %+v", status, job)
+	}
+	if len(job.Columns) != len(defaultExportColumns) {
+		t.Errorf("Columns = %v", job.Columns)
+	}
+
+	if status, _ := doExportRequest(t, app, "GET", "/v1/exports/"+job.ID, "tenant-a", ""); status != 200 {
+		t.Errorf("Owner GET: status %d", status)
+	}
+	if status, _ := doExportRequest(t, app, "GET", "/v1/exports/"+job.ID, "tenant-b", ""); status != 404 {
+		t.Errorf("Other tenant GET: status %d, want 404", status)
+	}
+
+	for _, body := range []string{
+		`{"format":"xlsx"}`,
+		`{"columns":["event_id","password"]}`,
+		`{"filters":{"tenant_id":"tenant-b"}}`,
+		`{"filters":{"q":"alice"}}`,
+	} {
+		if status, _ := doExportRequest(t, app, "POST", "/v1/exports", "tenant-a", body); status != 400 {
+			t.Errorf("Create %s: status %d, want 400", body, status)
+		}
+	}
+}
+
+func TestExportJobs_SignedDownload(t *testing.T) {
+	app := newTestExports(t)
+	ctx := context.Background()
+
+	job := &ExportJob{ID: "job-1", Tenant: "tenant-a", Status: exportCompleted, Format: "csv",
+		Files: []ExportFile{{Name: "part-00001.csv", Rows: 1, Bytes: 4}}}
+	if err := exports.save(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := exports.store.Put(ctx, "job-1/part-00001.csv", strings.NewReader("a,b\n"), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	_, resp := doExportRequest(t, app, "GET", "/v1/exports/job-1", "tenant-a", "")
+	if len(resp.Files) != 1 || resp.Files[0].URL == "" || resp.Progress != nil {
+		t.Fatalf("Response = %+v", resp)
+	}
+	link := resp.Files[0].URL
+
+	res, err := app.Test(httptest.NewRequest("GET", link, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(res.Body); res.StatusCode != 200 || string(body) != "a,b\n" {
+		t.Errorf("Download: status %d, body %q", res.StatusCode, body)
+	}
+
+	u, _ := url.Parse(link)
+	q := u.Query()
+	for name, tamper := range map[string]func(url.Values){
+		"expired":   func(v url.Values) { v.Set("expires", "1") },
+		"signature": func(v url.Values) { v.Set("signature", strings.Repeat("0", 64)) },
+	} {
+		v := url.Values{}
+		for k, vals := range q {
+			v[k] = vals
+		}
+		tamper(v)
+		res, _ := app.Test(httptest.NewRequest("GET", u.Path+"?"+v.Encode(), nil))
+		if res.StatusCode != 403 {
+			t.Errorf("%s link: status %d, want 403", name, res.StatusCode)
+		}
+	}
+	// The signature covers the file name
+	res, _ = app.Test(httptest.NewRequest("GET", strings.Replace(link, "part-00001.csv", "job.json", 1), nil))
+	if res.StatusCode != 403 {
+		t.Errorf("job.json: status %d, want 403", res.StatusCode)
+	}
+}
+
+func TestExportStore_ListsByPrefix(t *testing.T) {
+	store, err := newExportStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"a/job.json", "a/part-00001.csv", "b/job.json", "_active/b"} {
+		if err := store.Put(ctx, key, strings.NewReader("{}"), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for prefix, want := range map[string]string{
+		"_active/": "_active/b",
+		"a/":       "a/job.json,a/part-00001.csv",
+	} {
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+		if strings.Join(keys, ",") != want {
+			t.Errorf("List(%q) = %v, want %s", prefix, keys, want)
+		}
+	}
+}
+
+func TestExportLease_TakeoverIsConditional(t *testing.T) {
+	store, err := newExportStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newExportManager(store, exportConfig{Lease: time.Minute}, "a")
+	ctx := context.Background()
+	if err := m.create(ctx, &ExportJob{ID: "job-1", Status: exportQueued}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two replicas saw the same expired lease; only the first write wins
+	job, version, err := m.loadVersion(ctx, "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &exportLease{m: m, id: "job-1", version: version}
+	second := &exportLease{m: m, id: "job-1", version: version}
+	if err := first.save(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.save(ctx, job); !errors.Is(err, errExportConflict) || !second.isLost() {
+		t.Errorf("Second takeover: %v, want a conflict", err)
+	}
+	if err := first.renew(ctx); err != nil {
+		t.Errorf("Renewing the held lease: %v", err)
+	}
+}
+
+func TestExportManager_SweepsExpiredJobs(t *testing.T) {
+	root := t.TempDir()
+	store, err := newExportStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newExportManager(store, exportConfig{Lease: time.Minute, Retention: 24 * time.Hour}, "a")
+	ctx := context.Background()
+	for _, id := range []string{"old", "new"} {
+		if err := m.create(ctx, &ExportJob{ID: id, Status: exportCompleted}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(ctx, id+"/part-00001.csv", strings.NewReader("a\n"), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "old", "job.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	m.sweep(ctx)
+	objects, err := store.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	if strings.Join(keys, ",") != "_active/new,new/job.json,new/part-00001.csv" {
+		t.Errorf("After sweep: %v", keys)
+	}
+}
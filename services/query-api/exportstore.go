@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// exportStore holds export job state and result files. Keys are
+// slash-separated, "<job id>/<file>".
+type exportStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// PutIf writes data only while key is still at version, as returned by
+	// GetVersion or an earlier PutIf, and returns the new version. It fails
+	// with errExportConflict when someone else wrote key in between. An
+	// empty version writes unconditionally.
+	PutIf(ctx context.Context, key string, data []byte, version string) (string, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetVersion reads a small object along with its version
+	GetVersion(ctx context.Context, key string) ([]byte, string, error)
+	// List returns the objects whose keys start with prefix
+	List(ctx context.Context, prefix string) ([]exportObject, error)
+	Delete(ctx context.Context, key string) error
+	// URL returns a download link for key that stops working after ttl
+	URL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// exportObject is a stored key and when it was last written
+type exportObject struct {
+	Key      string
+	Modified time.Time
+}
+
+var (
+	errExportNotFound = errors.New("export not found")
+	errExportConflict = errors.New("export job was changed by another replica")
+)
+
+// newExportStore builds a store from EXPORT_STORAGE, which is either
+// s3://bucket/prefix or a local directory
+func newExportStore(uri string) (exportStore, error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if !ok {
+		root := strings.TrimPrefix(uri, "file://")
+		if err := os.MkdirAll(root, 0o700); err != nil {
+			return nil, err
+		}
+		return dirStore{root: root, baseURL: getEnv("EXPORT_PUBLIC_URL", ""), mu: &sync.Mutex{}}, nil
+	}
+
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	client, err := minio.New(getEnv("EXPORT_S3_ENDPOINT", "s3.amazonaws.com"), &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv("EXPORT_S3_ACCESS_KEY"), os.Getenv("EXPORT_S3_SECRET_KEY"), ""),
+		Secure: getEnv("EXPORT_S3_USE_SSL", "true") == "true",
+		Region: os.Getenv("EXPORT_S3_REGION"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3Store{client: client, bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+// dirStore keeps exports in a local directory. Its download links point
+// back at this service and are signed with the cursor key. Conditional
+// writes are only atomic within one process, so a directory store serves
+// a single replica.
+type dirStore struct {
+	root    string
+	baseURL string
+	mu      *sync.Mutex
+}
+
+func (s dirStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+// Put writes through a temporary file so readers never see partial files
+func (s dirStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	dst := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// PutIf versions files by their content hash
+func (s dirStore) PutIf(ctx context.Context, key string, data []byte, version string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version != "" {
+		current, err := os.ReadFile(s.path(key))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if err != nil || contentVersion(current) != version {
+			return "", errExportConflict
+		}
+	}
+	if err := s.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return "", err
+	}
+	return contentVersion(data), nil
+}
+
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s dirStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errExportNotFound
+	}
+	return f, err
+}
+
+func (s dirStore) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", errExportNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentVersion(data), nil
+}
+
+// List skips the temporary files of writes in progress
+func (s dirStore) List(ctx context.Context, prefix string) ([]exportObject, error) {
+	var objects []exportObject
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, exportObject{Key: key, Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// Delete removes key and, once it is empty, the job's directory
+func (s dirStore) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	os.Remove(filepath.Dir(s.path(key)))
+	return nil
+}
+
+func (s dirStore) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	id, name, _ := strings.Cut(key, "/")
+	expires := time.Now().Add(ttl).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", exportFileSignature(id, name, expires))
+	return fmt.Sprintf("%s/v1/exports/%s/files/%s?%s", s.baseURL, url.PathEscape(id), url.PathEscape(name), q.Encode()), nil
+}
+
+// exportFileSignature signs a local download link. The prefix keeps these
+// MACs apart from cursor MACs made with the same key.
+func exportFileSignature(id, name string, expires int64) string {
+	mac := hmac.New(sha256.New, cursorKey)
+	fmt.Fprintf(mac, "export-file\n%s\n%s\n%d", id, name, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyExportFileSignature checks a link's signature and expiry
+func verifyExportFileSignature(id, name, expires, signature string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	want := exportFileSignature(id, name, exp)
+	return hmac.Equal([]byte(signature), []byte(want))
+}
+
+// s3Store keeps exports in an S3-compatible bucket and hands out
+// presigned links
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s s3Store) key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+func (s s3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(key), r, size, minio.PutObjectOptions{})
+	return err
+}
+
+// PutIf relies on If-Match, so versions are ETags
+func (s s3Store) PutIf(ctx context.Context, key string, data []byte, version string) (string, error) {
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	if version != "" {
+		opts.SetMatchETag(version)
+	}
+	info, err := s.client.PutObject(ctx, s.bucket, s.key(key), bytes.NewReader(data), int64(len(data)), opts)
+	if code := minio.ToErrorResponse(err).Code; code == "PreconditionFailed" || code == "NoSuchKey" {
+		return "", errExportConflict
+	}
+	if err != nil {
+		return "", err
+	}
+	return info.ETag, nil
+}
+
+func (s s3Store) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	obj := r.(*minio.Object)
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(obj)
+	return data, info.ETag, err
+}
+
+func (s s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat surfaces a missing key
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, errExportNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s s3Store) List(ctx context.Context, prefix string) ([]exportObject, error) {
+	var objects []exportObject
+	root := ""
+	if s.prefix != "" {
+		root = s.prefix + "/"
+	}
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: root + prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		objects = append(objects, exportObject{Key: strings.TrimPrefix(obj.Key, root), Modified: obj.LastModified})
+	}
+	return objects, nil
+}
+
+func (s s3Store) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.key(key), minio.RemoveObjectOptions{})
+}
+
+func (s s3Store) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", path.Base(key)))
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, s.key(key), ttl, params)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
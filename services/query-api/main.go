@@ -1,17 +1,12 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
-	"encoding/csv"
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
@@ -45,33 +40,29 @@ type Event struct {
 	Resource   map[string]interface{} `json:"resource"`
 	Result     map[string]interface{} `json:"result,omitempty"`
 	Context    map[string]interface{} `json:"context,omitempty"`
+	Processing map[string]interface{} `json:"processing,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+
+	// Set on full-text search results
+	Score     *float64            `json:"score,omitempty"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
 }
 
-// ListResponse represents paginated list response
+// ListResponse represents paginated list response. The total is left out
+// when the client opts out of counting with count=none.
 type ListResponse struct {
-	Data       []Event    `json:"data"`
-	Pagination Pagination `json:"pagination"`
-	TotalCount int64      `json:"total_count"`
+	Data            []Event    `json:"data"`
+	Pagination      Pagination `json:"pagination"`
+	TotalCount      *int64     `json:"total_count,omitempty"`
+	TotalCountExact *bool      `json:"total_count_exact,omitempty"`
 }
 
-// Pagination represents cursor-based pagination
+// Pagination represents cursor-based pagination. Cursor fetches the next
+// (older) page and PrevCursor the previous (newer) one.
 type Pagination struct {
-	Cursor  string `json:"cursor"`
-	HasMore bool   `json:"has_more"`
-}
-
-// Aggregation represents an aggregation result
-type Aggregation struct {
-	Action  string `json:"action"`
-	Count   int64  `json:"count"`
-	Success int64  `json:"success"`
-	Failed  int64  `json:"failed"`
-}
-
-// AggregationResponse represents aggregation response
-type AggregationResponse struct {
-	Aggregations []Aggregation `json:"aggregations"`
-	Total        int64         `json:"total"`
+	Cursor     string `json:"cursor"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
 }
 
 var (
@@ -147,55 +138,47 @@ func main() {
 	if err := initOpenSearch(); err != nil {
 		log.Printf("Warning: OpenSearch connection failed: %v", err)
 	}
+	if err := initExports(); err != nil {
+		log.Printf("Warning: export jobs unavailable: %v", err)
+	}
 
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 	})
 	app.Use(logger.New())
 
-	// Tenant Filtering Middleware
-	app.Use(func(c *fiber.Ctx) error {
-		// Skip for health check
-		if c.Path() == "/health" {
-			return c.Next()
-		}
-
-		consumer := c.Get("X-Consumer-Name")
-		if consumer == "" {
-			// If missing header, assume public or dev mode, or reject.
-			// For POC, we'll allow if empty but log warning, or default to "default_tenant"
-		}
-		c.Locals("consumer", consumer)
-		return c.Next()
-	})
+	auth, err := initAuth()
+	if err != nil {
+		log.Fatalf("Failed to load consumer mapping: %v", err)
+	}
 
-	// Health check
+	// Routes before authorize are public. Export file links carry their
+	// own signature.
 	app.Get("/health", healthHandler)
+	app.Get("/v1/exports/:id/files/:name", exportFileHandler)
+
+	// Everything after resolves the consumer to a tenant or admin
+	app.Use(authorize(auth))
 
 	// Event endpoints
 	app.Get("/v1/events", listEventsHandler)
 	app.Get("/v1/events/aggregations", aggregationsHandler)
+	app.Get("/v1/events/timeseries", timeseriesHandler)
 	app.Get("/v1/events/export", exportHandler)
 	app.Get("/v1/events/:id", getEventHandler)
 
+	// Asynchronous exports
+	app.Post("/v1/exports", createExportHandler)
+	app.Get("/v1/exports/:id", getExportHandler)
+
+	// Catalog of actions and resource types seen per tenant
+	app.Get("/v1/catalog/actions", catalogActionsHandler)
+	app.Get("/v1/catalog/resource-types", catalogResourceTypesHandler)
+
 	log.Printf("Query API starting on port %s", config.Port)
 	log.Fatal(app.Listen(":" + config.Port))
 }
 
-// Helper to add tenant filter
-func addTenantFilter(query string, args []interface{}, c *fiber.Ctx) (string, []interface{}) {
-	consumer, ok := c.Locals("consumer").(string)
-	if !ok || consumer == "" {
-		return query, args
-	}
-	// If consumer is admin/producer, allow all. Otherwise filter.
-	if consumer != "audit-producer" {
-		query += " AND tenant_id = ?"
-		args = append(args, consumer)
-	}
-	return query, args
-}
-
 func healthHandler(c *fiber.Ctx) error {
 	status := fiber.Map{
 		"status":     "healthy",
@@ -223,55 +206,80 @@ func healthHandler(c *fiber.Ctx) error {
 }
 
 func listEventsHandler(c *fiber.Ctx) error {
-	// Check if full-text search query
-	if q := c.Query("q"); q != "" {
-		return searchEventsHandler(c, q)
-	}
-
 	// Build ClickHouse query
-	query := "SELECT event_id, tenant_id, event_date, received_at, actor_id, action_name, resource_type, resource_id, result_success FROM audit.events WHERE 1=1"
-	args := []interface{}{}
-
-	query, args = addTenantFilter(query, args, c)
-
-	if action := c.Query("action"); action != "" {
-		query += " AND action_name = ?"
-		args = append(args, action)
-	}
-	if actorID := c.Query("actor_id"); actorID != "" {
-		query += " AND actor_id = ?"
-		args = append(args, actorID)
-	}
-	if resourceType := c.Query("resource_type"); resourceType != "" {
-		query += " AND resource_type = ?"
-		args = append(args, resourceType)
+	where, whereArgs := listFilters(c)
+	if q := c.Query("q"); q != "" {
+		node, err := parseQuery(q)
+		if err != nil {
+			return queryError(c, err)
+		}
+		// Free text needs the search index; structured queries stay in ClickHouse
+		if hasFreeText(node) {
+			return searchEventsHandler(c, node)
+		}
+		sql, sqlArgs := compileSQL(node)
+		where += " AND " + sql
+		whereArgs = append(whereArgs, sqlArgs...)
 	}
-	if resourceID := c.Query("resource_id"); resourceID != "" {
-		query += " AND resource_id = ?"
-		args = append(args, resourceID)
+	fields, err := parseFields(c.Query("fields"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
-	if from := c.Query("from"); from != "" {
-		query += " AND event_date >= ?"
-		args = append(args, from)
+	columns := "event_id, tenant_id, event_date, received_at, actor_id, action_name, resource_type, resource_id, result_success"
+	if !fields.empty() {
+		// raw_event is only read when nested data was asked for
+		columns += ", raw_event"
 	}
-	if to := c.Query("to"); to != "" {
-		query += " AND event_date <= ?"
-		args = append(args, to)
+	query := "SELECT " + columns + " FROM audit.events" + where
+	args := append([]interface{}{}, whereArgs...)
+
+	countMode, err := parseCountMode(c.Query("count"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
-	if success := c.Query("success"); success != "" {
-		query += " AND result_success = ?"
-		args = append(args, success == "true")
+	if countMode == countAuto {
+		countMode = autoCountMode(c.Query("from"), c.Query("to"))
 	}
 
 	// Pagination
-	limit := 50
-	if l := c.Query("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
-			limit = parsed
+	limit := pageLimit(c)
+
+	// Keyset pagination on (received_at, event_id): pages stay stable while
+	// newer events are inserted
+	filters, tenant := filterHash(c), cursorTenant(c)
+	var cursor *pageCursor
+	if token := c.Query("cursor"); token != "" {
+		cur, err := decodeCursor(token, filters, tenant)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		cursor = &cur
+	}
+
+	// The request is valid; count in parallel with the page
+	counter := newEventCounter(c, where, whereArgs)
+	countCtx, cancelCount := context.WithCancel(context.Background())
+	defer cancelCount()
+	countCh := make(chan countResult, 1)
+	go func() {
+		countCh <- counter.count(countCtx, countMode)
+	}()
+
+	backward := cursor != nil && cursor.Direction == cursorPrev
+	if cursor != nil {
+		if backward {
+			query += " AND (received_at, event_id) > (fromUnixTimestamp64Milli(?), toUUID(?))"
+		} else {
+			query += " AND (received_at, event_id) < (fromUnixTimestamp64Milli(?), toUUID(?))"
 		}
+		args = append(args, cursor.ReceivedAt, cursor.EventID)
 	}
 
-	query += " ORDER BY received_at DESC LIMIT ?"
+	if backward {
+		query += " ORDER BY received_at ASC, event_id ASC LIMIT ?"
+	} else {
+		query += " ORDER BY received_at DESC, event_id DESC LIMIT ?"
+	}
 	args = append(args, limit+1) // +1 to check for more
 
 	// Execute query
@@ -293,11 +301,16 @@ func listEventsHandler(c *fiber.Ctx) error {
 			ResourceType  string
 			ResourceID    string
 			ResultSuccess bool
+			RawEvent      string
 		}
-		if err := rows.Scan(&e.EventID, &e.TenantID, &e.EventDate, &e.ReceivedAt, &e.ActorID, &e.ActionName, &e.ResourceType, &e.ResourceID, &e.ResultSuccess); err != nil {
+		dest := []interface{}{&e.EventID, &e.TenantID, &e.EventDate, &e.ReceivedAt, &e.ActorID, &e.ActionName, &e.ResourceType, &e.ResourceID, &e.ResultSuccess}
+		if !fields.empty() {
+			dest = append(dest, &e.RawEvent)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			continue
 		}
-		events = append(events, Event{
+		event := Event{
 			EventID:    e.EventID,
 			TenantID:   e.TenantID,
 			EventDate:  e.EventDate.Format("2006-01-02"),
@@ -306,99 +319,122 @@ func listEventsHandler(c *fiber.Ctx) error {
 			Action:     map[string]interface{}{"name": e.ActionName},
 			Resource:   map[string]interface{}{"type": e.ResourceType, "id": e.ResourceID},
 			Result:     map[string]interface{}{"success": e.ResultSuccess},
-		})
+		}
+		if !fields.empty() {
+			if full, err := eventFromRaw(event, e.RawEvent); err == nil {
+				event = fields.project(event, full)
+			}
+		}
+		events = append(events, event)
 	}
 
-	hasMore := len(events) > limit
-	if hasMore {
+	extra := len(events) > limit
+	if extra {
 		events = events[:limit]
 	}
+	if backward {
+		// Fetched oldest first; return newest first like every other page
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
 
-	return c.JSON(ListResponse{
-		Data: events,
-		Pagination: Pagination{
-			Cursor:  "", // Simplified for MVP
-			HasMore: hasMore,
-		},
-		TotalCount: int64(len(events)),
-	})
-}
+	// Going forward, the extra row means older events remain and a cursor
+	// means newer ones exist. Going backward it is the other way round.
+	hasOlder, hasNewer := extra, cursor != nil
+	if backward {
+		hasOlder, hasNewer = true, extra
+	}
 
-func searchEventsHandler(c *fiber.Ctx, q string) error {
-	if osClient == nil {
-		return c.Status(503).JSON(fiber.Map{"error": "OpenSearch not available"})
+	pagination := Pagination{HasMore: hasOlder && len(events) > 0}
+	if len(events) == 0 && backward {
+		// Nothing newer any more: point back to where the client came from
+		pagination.HasMore = true
+		pagination.Cursor = encodeCursor(pageCursor{
+			ReceivedAt: cursor.ReceivedAt, EventID: cursor.EventID,
+			Direction: cursorNext, Filters: filters, Tenant: tenant,
+		})
+	}
+	if len(events) > 0 {
+		if pagination.HasMore {
+			last := events[len(events)-1]
+			pagination.Cursor = encodeCursor(pageCursor{
+				ReceivedAt: last.ReceivedAt.UnixMilli(), EventID: last.EventID,
+				Direction: cursorNext, Filters: filters, Tenant: tenant,
+			})
+		}
+		if hasNewer {
+			first := events[0]
+			pagination.PrevCursor = encodeCursor(pageCursor{
+				ReceivedAt: first.ReceivedAt.UnixMilli(), EventID: first.EventID,
+				Direction: cursorPrev, Filters: filters, Tenant: tenant,
+			})
+		}
 	}
 
-	// Build OpenSearch query
-	var queryPart string
-	consumer, ok := c.Locals("consumer").(string)
+	resp := ListResponse{Data: events, Pagination: pagination}
+	if total := <-countCh; total.err != nil {
+		log.Printf("Error counting events: %v", total.err)
+	} else if countMode != countNone {
+		resp.TotalCount = &total.count
+		resp.TotalCountExact = &total.exact
+	}
+	return c.JSON(resp)
+}
 
-	// Base multi_match query
-	baseQuery := fmt.Sprintf(`{
-		"multi_match": {
-			"query": "%s",
-			"fields": ["actor.email", "actor.id", "action.name", "resource.id"],
-			"fuzziness": "AUTO"
+// pageLimit reads limit, defaulting to 50 and capped at 1000
+func pageLimit(c *fiber.Ctx) int {
+	limit := 50
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
 		}
-	}`, q)
-
-	if ok && consumer != "" && consumer != "audit-producer" {
-		queryPart = fmt.Sprintf(`{
-			"bool": {
-				"must": %s,
-				"filter": [
-					{ "term": { "tenant_id": "%s" } }
-				]
-			}
-		}`, baseQuery, consumer)
-	} else {
-		queryPart = baseQuery
 	}
+	return limit
+}
 
-	searchBody := fmt.Sprintf(`{
-		"query": %s,
-		"size": 50,
-		"sort": [{"received_at": "desc"}]
-	}`, queryPart)
+// listFilters builds the WHERE clause shared by the list and count queries
+func listFilters(c *fiber.Ctx) (string, []interface{}) {
+	return filterWhere(currentPrincipal(c), func(key string) string { return c.Query(key) })
+}
 
-	res, err := osClient.Search(
-		osClient.Search.WithContext(context.Background()),
-		osClient.Search.WithIndex("audit-events-*"),
-		osClient.Search.WithBody(strings.NewReader(searchBody)),
-	)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	defer res.Body.Close()
+// filterWhere builds the list WHERE clause for a principal from filter
+// values looked up by parameter name
+func filterWhere(p principal, get func(string) string) (string, []interface{}) {
+	query := " WHERE 1=1"
+	args := []interface{}{}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
+	query, args = tenantFilter(query, args, p)
 
-	// Extract hits
-	events := []Event{}
-	if hits, ok := result["hits"].(map[string]interface{}); ok {
-		if hitList, ok := hits["hits"].([]interface{}); ok {
-			for _, hit := range hitList {
-				if h, ok := hit.(map[string]interface{}); ok {
-					if source, ok := h["_source"].(map[string]interface{}); ok {
-						e := Event{
-							EventID: fmt.Sprintf("%v", source["event_id"]),
-						}
-						// simplified parsing
-						events = append(events, e)
-					}
-				}
-			}
-		}
+	if action := get("action"); action != "" {
+		query += " AND action_name = ?"
+		args = append(args, action)
 	}
-
-	return c.JSON(ListResponse{
-		Data:       events,
-		Pagination: Pagination{HasMore: false},
-		TotalCount: int64(len(events)),
-	})
+	if actorID := get("actor_id"); actorID != "" {
+		query += " AND actor_id = ?"
+		args = append(args, actorID)
+	}
+	if resourceType := get("resource_type"); resourceType != "" {
+		query += " AND resource_type = ?"
+		args = append(args, resourceType)
+	}
+	if resourceID := get("resource_id"); resourceID != "" {
+		query += " AND resource_id = ?"
+		args = append(args, resourceID)
+	}
+	if from := get("from"); from != "" {
+		query += " AND event_date >= ?"
+		args = append(args, from)
+	}
+	if to := get("to"); to != "" {
+		query += " AND event_date <= ?"
+		args = append(args, to)
+	}
+	if success := get("success"); success != "" {
+		query += " AND result_success = ?"
+		args = append(args, success == "true")
+	}
+	return query, args
 }
 
 func getEventHandler(c *fiber.Ctx) error {
@@ -435,7 +471,7 @@ func getEventHandler(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "event not found"})
 	}
 
-	return c.JSON(Event{
+	event, err := eventFromRaw(Event{
 		EventID:    e.EventID,
 		TenantID:   e.TenantID,
 		EventDate:  e.EventDate.Format("2006-01-02"),
@@ -444,133 +480,10 @@ func getEventHandler(c *fiber.Ctx) error {
 		Action:     map[string]interface{}{"name": e.ActionName},
 		Resource:   map[string]interface{}{"type": e.ResourceType, "id": e.ResourceID},
 		Result:     map[string]interface{}{"success": e.ResultSuccess},
-	})
-}
-
-func aggregationsHandler(c *fiber.Ctx) error {
-	groupBy := c.Query("group_by", "action")
-	if groupBy != "action" && groupBy != "actor" && groupBy != "resource_type" {
-		groupBy = "action"
-	}
-
-	var groupCol string
-	switch groupBy {
-	case "action":
-		groupCol = "action_name"
-	case "actor":
-		groupCol = "actor_id"
-	case "resource_type":
-		groupCol = "resource_type"
-	}
-
-	query := fmt.Sprintf(`
-		SELECT %s, 
-			   count() as total,
-			   countIf(result_success = true) as success,
-			   countIf(result_success = false) as failed
-		FROM audit.events
-		WHERE 1=1
-	`, groupCol)
-	args := []interface{}{}
-
-	query, args = addTenantFilter(query, args, c)
-
-	if from := c.Query("from"); from != "" {
-		query += " AND event_date >= ?"
-		args = append(args, from)
-	}
-	if to := c.Query("to"); to != "" {
-		query += " AND event_date <= ?"
-		args = append(args, to)
-	}
-
-	query += fmt.Sprintf(" GROUP BY %s ORDER BY total DESC LIMIT 100", groupCol)
-
-	rows, err := chConn.Query(context.Background(), query, args...)
+	}, e.RawEvent)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	defer rows.Close()
-
-	aggs := []Aggregation{}
-	var total int64
-	for rows.Next() {
-		var a Aggregation
-		if err := rows.Scan(&a.Action, &a.Count, &a.Success, &a.Failed); err != nil {
-			continue
-		}
-		total += a.Count
-		aggs = append(aggs, a)
-	}
-
-	return c.JSON(AggregationResponse{
-		Aggregations: aggs,
-		Total:        total,
-	})
-}
-
-func exportHandler(c *fiber.Ctx) error {
-	format := c.Query("format", "csv")
-	if format != "csv" {
-		return c.Status(400).JSON(fiber.Map{"error": "only csv format supported"})
+		// Still return what the columns hold rather than failing the lookup
+		log.Printf("Event %s: %v", e.EventID, err)
 	}
-
-	// Set headers for CSV download
-	c.Set("Content-Type", "text/csv")
-	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-events-%s.csv\"", time.Now().Format("2006-01-02")))
-
-	// Build query with filters
-	query := "SELECT event_id, event_date, received_at, actor_id, action_name, resource_type, resource_id, result_success FROM audit.events WHERE 1=1"
-	args := []interface{}{}
-
-	query, args = addTenantFilter(query, args, c)
-
-	if action := c.Query("action"); action != "" {
-		query += " AND action_name = ?"
-		args = append(args, action)
-	}
-	if from := c.Query("from"); from != "" {
-		query += " AND event_date >= ?"
-		args = append(args, from)
-	}
-	if to := c.Query("to"); to != "" {
-		query += " AND event_date <= ?"
-		args = append(args, to)
-	}
-
-	query += " ORDER BY received_at DESC LIMIT 100000"
-
-	rows, err := chConn.Query(context.Background(), query, args...)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	defer rows.Close()
-
-	// Write CSV
-	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		csvWriter := csv.NewWriter(w)
-		csvWriter.Write([]string{"event_id", "event_date", "received_at", "actor_id", "action", "resource_type", "resource_id", "success"})
-
-		for rows.Next() {
-			var eventID, actorID, actionName, resourceType, resourceID string
-			var eventDate, receivedAt time.Time
-			var success bool
-			if err := rows.Scan(&eventID, &eventDate, &receivedAt, &actorID, &actionName, &resourceType, &resourceID, &success); err != nil {
-				continue
-			}
-			csvWriter.Write([]string{
-				eventID,
-				eventDate.Format("2006-01-02"),
-				receivedAt.Format(time.RFC3339),
-				actorID,
-				actionName,
-				resourceType,
-				resourceID,
-				strconv.FormatBool(success),
-			})
-		}
-		csvWriter.Flush()
-	})
-
-	return nil
+	return c.JSON(event)
 }
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The q parameter takes a small query language:
+//
+//	actor.id:alice AND action:auth.* AND NOT result.success:true AND context.ip:10.0.0.0/8
+//
+// Terms are field:value (or field:>value, >=, <, <= on time fields) or bare
+// words and "quoted phrases" that search the whole event. Terms next to each
+// other are ANDed; AND, OR and NOT are upper case and parentheses group. A
+// trailing or embedded * in a keyword value is a wildcard.
+//
+// A query is parsed once into an AST and compiled separately to
+// ClickHouse SQL (values always bound as parameters) and to OpenSearch DSL
+// (built as Go values and marshalled), so user input is never spliced into
+// either query text.
+
+const (
+	maxQueryLength = 2048
+	maxQueryDepth  = 32
+)
+
+type fieldKind int
+
+const (
+	kindKeyword fieldKind = iota
+	kindText
+	kindBool
+	kindIP
+	kindTime
+	kindDate
+)
+
+// queryField maps a query field to its ClickHouse column expression and
+// its OpenSearch path
+type queryField struct {
+	column string
+	path   string
+	kind   fieldKind
+}
+
+var queryFields = map[string]queryField{
+	"event_id":               {"toString(event_id)", "event_id", kindKeyword},
+	"actor.id":               {"actor_id", "actor.id", kindKeyword},
+	"actor.type":             {"actor_type", "actor.type", kindKeyword},
+	"actor.email":            {"actor_email", "actor.email", kindKeyword},
+	"action":                 {"action_name", "action.name", kindKeyword},
+	"action.name":            {"action_name", "action.name", kindKeyword},
+	"resource.type":          {"resource_type", "resource.type", kindKeyword},
+	"resource.id":            {"resource_id", "resource.id", kindKeyword},
+	"result.success":         {"result_success", "result.success", kindBool},
+	"result.message":         {"result_message", "result.message", kindText},
+	"context.ip":             {"context_ip", "context.ip", kindIP},
+	"context.user_agent":     {"context_user_agent", "context.user_agent", kindText},
+	"processing.vector_node": {"processing_vector_node", "processing.vector_node", kindKeyword},
+	"received_at":            {"received_at", "received_at", kindTime},
+	"event_date":             {"event_date", "event_date", kindDate},
+}
+
+// freeTextFields are searched by bare words in OpenSearch
+var freeTextFields = []string{"actor.email", "actor.id", "action.name", "resource.id", "result.message"}
+
+// querySyntaxError reports where a query stopped making sense. Pos is a
+// zero-based byte offset into the query.
+type querySyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *querySyntaxError) Error() string {
+	return fmt.Sprintf("query syntax error at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// AST nodes
+type queryNode interface{ node() }
+
+type andNode struct{ children []queryNode }
+
+type orNode struct{ children []queryNode }
+
+type notNode struct{ child queryNode }
+
+// termNode is field:value. op is "=", ">", ">=", "<" or "<=".
+type termNode struct {
+	name     string
+	field    queryField
+	op       string
+	value    string
+	wildcard bool
+	millis   int64        // kindTime
+	prefix   netip.Prefix // kindIP with a CIDR value
+}
+
+// textNode is a bare word or quoted phrase
+type textNode struct{ text string }
+
+func (andNode) node()  {}
+func (orNode) node()   {}
+func (notNode) node()  {}
+func (termNode) node() {}
+func (textNode) node() {}
+
+// parseQuery parses q into an AST
+func parseQuery(q string) (queryNode, error) {
+	if len(q) > maxQueryLength {
+		return nil, &querySyntaxError{Pos: maxQueryLength, Msg: fmt.Sprintf("query longer than %d bytes", maxQueryLength)}
+	}
+	p := &queryParser{src: q}
+	p.skipSpace()
+	if p.eof() {
+		return nil, &querySyntaxError{Pos: 0, Msg: "empty query"}
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return n, nil
+}
+
+type queryParser struct {
+	src   string
+	pos   int
+	depth int
+}
+
+func (p *queryParser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return &querySyntaxError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.eof() && isQuerySpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func isQuerySpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// keyword consumes an upper-case operator if it stands alone at pos
+func (p *queryParser) keyword(kw string) bool {
+	end := p.pos + len(kw)
+	if !strings.HasPrefix(p.src[p.pos:], kw) {
+		return false
+	}
+	if end < len(p.src) && !isQuerySpace(p.src[end]) && p.src[end] != '(' {
+		return false
+	}
+	p.pos = end
+	p.skipSpace()
+	return true
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []queryNode{first}
+	for p.keyword("OR") {
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return orNode{children}, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	children := []queryNode{first}
+	for {
+		p.skipSpace()
+		if p.eof() || p.src[p.pos] == ')' {
+			break
+		}
+		save := p.pos
+		if p.keyword("OR") {
+			p.pos = save
+			break
+		}
+		p.keyword("AND")
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return andNode{children}, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	p.skipSpace()
+	if p.keyword("NOT") {
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("unexpected end of query")
+	}
+	switch p.src[p.pos] {
+	case '(':
+		if p.depth++; p.depth > maxQueryDepth {
+			return nil, p.errorf("query nested deeper than %d levels", maxQueryDepth)
+		}
+		open := p.pos
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.eof() || p.src[p.pos] != ')' {
+			return nil, &querySyntaxError{Pos: open, Msg: "unclosed ("}
+		}
+		p.pos++
+		p.depth--
+		return n, nil
+	case ')':
+		return nil, p.errorf("unexpected )")
+	case '"':
+		s, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return textNode{s}, nil
+	}
+
+	start := p.pos
+	for !p.eof() && !isQuerySpace(p.src[p.pos]) && !strings.ContainsRune("():\"", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	word := p.src[start:p.pos]
+	if word == "" {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	if word == "AND" || word == "OR" {
+		return nil, &querySyntaxError{Pos: start, Msg: fmt.Sprintf("%s needs a term on both sides", word)}
+	}
+	if p.eof() || p.src[p.pos] != ':' {
+		return textNode{word}, nil
+	}
+	p.pos++
+	return p.parseTerm(word, start)
+}
+
+// parseTerm parses the value after "field:"
+func (p *queryParser) parseTerm(name string, start int) (queryNode, error) {
+	field, ok := queryFields[name]
+	if !ok {
+		return nil, &querySyntaxError{Pos: start, Msg: fmt.Sprintf("unknown field %q", name)}
+	}
+	t := termNode{name: name, field: field, op: "="}
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			if field.kind != kindTime && field.kind != kindDate {
+				return nil, p.errorf("%s only supports exact matches", name)
+			}
+			t.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+
+	valuePos := p.pos
+	quoted := !p.eof() && p.src[p.pos] == '"'
+	if quoted {
+		s, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		t.value = s
+	} else {
+		for !p.eof() && !isQuerySpace(p.src[p.pos]) && p.src[p.pos] != '(' && p.src[p.pos] != ')' {
+			p.pos++
+		}
+		t.value = p.src[valuePos:p.pos]
+	}
+	if t.value == "" && !quoted {
+		return nil, &querySyntaxError{Pos: valuePos, Msg: fmt.Sprintf("expected a value after %s:", name)}
+	}
+
+	bad := func(format string, args ...interface{}) error {
+		return &querySyntaxError{Pos: valuePos, Msg: fmt.Sprintf(format, args...)}
+	}
+	switch field.kind {
+	case kindKeyword:
+		t.wildcard = !quoted && strings.Contains(t.value, "*")
+	case kindBool:
+		if t.value != "true" && t.value != "false" {
+			return nil, bad("%s must be true or false", name)
+		}
+	case kindIP:
+		if prefix, err := netip.ParsePrefix(t.value); err == nil {
+			t.prefix = prefix.Masked()
+		} else if _, err := netip.ParseAddr(t.value); err != nil {
+			return nil, bad("%s must be an IP address or CIDR range", name)
+		}
+	case kindTime:
+		ts, err := parseQueryTime(t.value)
+		if err != nil {
+			return nil, bad("%s must be an RFC 3339 timestamp or YYYY-MM-DD", name)
+		}
+		t.millis = ts.UnixMilli()
+	case kindDate:
+		if _, err := time.Parse("2006-01-02", t.value); err != nil {
+			return nil, bad("%s must be YYYY-MM-DD", name)
+		}
+	}
+	return t, nil
+}
+
+func parseQueryTime(s string) (time.Time, error) {
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return ts, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// quoted reads a "..." string with \" and \\ escapes
+func (p *queryParser) quoted() (string, error) {
+	open := p.pos
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		ch := p.src[p.pos]
+		switch {
+		case ch == '"':
+			p.pos++
+			return b.String(), nil
+		case ch == '\\' && p.pos+1 < len(p.src):
+			b.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(ch)
+			p.pos++
+		}
+	}
+	return "", &querySyntaxError{Pos: open, Msg: "unterminated quoted string"}
+}
+
+// hasFreeText reports whether the query searches text outside the indexed
+// columns, which only OpenSearch can answer well
+func hasFreeText(n queryNode) bool {
+	switch n := n.(type) {
+	case andNode:
+		for _, c := range n.children {
+			if hasFreeText(c) {
+				return true
+			}
+		}
+	case orNode:
+		for _, c := range n.children {
+			if hasFreeText(c) {
+				return true
+			}
+		}
+	case notNode:
+		return hasFreeText(n.child)
+	case textNode:
+		return true
+	}
+	return false
+}
+
+// compileSQL turns the AST into a ClickHouse boolean expression with ?
+// placeholders
+func compileSQL(n queryNode) (string, []interface{}) {
+	switch n := n.(type) {
+	case andNode:
+		return joinSQL(n.children, " AND ")
+	case orNode:
+		return joinSQL(n.children, " OR ")
+	case notNode:
+		sql, args := compileSQL(n.child)
+		return "NOT " + sql, args
+	case textNode:
+		return "positionCaseInsensitiveUTF8(raw_event, ?) > 0", []interface{}{n.text}
+	case termNode:
+		col := n.field.column
+		switch n.field.kind {
+		case kindText:
+			return "positionCaseInsensitiveUTF8(" + col + ", ?) > 0", []interface{}{n.value}
+		case kindBool:
+			return col + " = ?", []interface{}{n.value == "true"}
+		case kindIP:
+			if n.prefix.IsValid() {
+				// isIPAddressInRange throws on values that are not addresses
+				return "((isIPv4String(" + col + ") OR isIPv6String(" + col + ")) AND isIPAddressInRange(" + col + ", ?))", []interface{}{n.prefix.String()}
+			}
+		case kindTime:
+			return col + " " + n.op + " fromUnixTimestamp64Milli(?)", []interface{}{n.millis}
+		case kindDate:
+			return col + " " + n.op + " ?", []interface{}{n.value}
+		}
+		if n.wildcard {
+			return col + " LIKE ?", []interface{}{likePattern(n.value)}
+		}
+		return col + " = ?", []interface{}{n.value}
+	}
+	return "1", nil
+}
+
+func joinSQL(children []queryNode, op string) (string, []interface{}) {
+	parts := make([]string, len(children))
+	var args []interface{}
+	for i, c := range children {
+		var a []interface{}
+		parts[i], a = compileSQL(c)
+		args = append(args, a...)
+	}
+	return "(" + strings.Join(parts, op) + ")", args
+}
+
+// likePattern escapes LIKE metacharacters and turns * into %
+func likePattern(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`)
+	return r.Replace(s)
+}
+
+// compileDSL turns the AST into an OpenSearch query clause
+func compileDSL(n queryNode) map[string]interface{} {
+	switch n := n.(type) {
+	case andNode:
+		return map[string]interface{}{"bool": map[string]interface{}{"must": compileDSLList(n.children)}}
+	case orNode:
+		return map[string]interface{}{"bool": map[string]interface{}{
+			"should":               compileDSLList(n.children),
+			"minimum_should_match": 1,
+		}}
+	case notNode:
+		return map[string]interface{}{"bool": map[string]interface{}{"must_not": []interface{}{compileDSL(n.child)}}}
+	case textNode:
+		return map[string]interface{}{"multi_match": map[string]interface{}{
+			"query":     n.text,
+			"fields":    freeTextFields,
+			"fuzziness": "AUTO",
+		}}
+	case termNode:
+		path := n.field.path
+		switch n.field.kind {
+		case kindText:
+			return map[string]interface{}{"match": map[string]interface{}{
+				path: map[string]interface{}{"query": n.value, "operator": "and"},
+			}}
+		case kindBool:
+			return map[string]interface{}{"term": map[string]interface{}{path: n.value == "true"}}
+		case kindTime, kindDate:
+			if n.op != "=" {
+				ops := map[string]string{">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}
+				return map[string]interface{}{"range": map[string]interface{}{
+					path: map[string]interface{}{ops[n.op]: n.value},
+				}}
+			}
+		}
+		if n.wildcard {
+			return map[string]interface{}{"wildcard": map[string]interface{}{
+				path: map[string]interface{}{"value": wildcardPattern(n.value)},
+			}}
+		}
+		// term on an ip field also matches CIDR ranges
+		return map[string]interface{}{"term": map[string]interface{}{path: n.value}}
+	}
+	return map[string]interface{}{"match_all": map[string]interface{}{}}
+}
+
+func compileDSLList(children []queryNode) []interface{} {
+	out := make([]interface{}, len(children))
+	for i, c := range children {
+		out[i] = compileDSL(c)
+	}
+	return out
+}
+
+// wildcardPattern escapes OpenSearch wildcard metacharacters other than *
+func wildcardPattern(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `?`, `\?`)
+	return r.Replace(s)
+}
+
+var errFreeTextQuery = errors.New("free text is only supported when listing events; use field:value terms")
+
+// fieldQueryFilter compiles q into an AND clause for endpoints that read
+// ClickHouse only, where free text is rejected
+func fieldQueryFilter(q string) (string, []interface{}, error) {
+	if q == "" {
+		return "", nil, nil
+	}
+	node, err := parseQuery(q)
+	if err != nil {
+		return "", nil, err
+	}
+	if hasFreeText(node) {
+		return "", nil, errFreeTextQuery
+	}
+	sql, args := compileSQL(node)
+	return " AND " + sql, args, nil
+}
+
+// queryError responds 400 with the position of a syntax error
+func queryError(c *fiber.Ctx, err error) error {
+	resp := fiber.Map{"error": err.Error()}
+	if se, ok := err.(*querySyntaxError); ok {
+		resp["position"] = se.Pos + 1
+	}
+	return c.Status(400).JSON(resp)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery_CompilesToParameterizedSQL(t *testing.T) {
+	node, err := parseQuery(`actor.id:alice AND action:auth.* AND NOT result.success:true AND context.ip:10.0.0.0/8`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasFreeText(node) {
+		t.Error("Structured query reported as free text")
+	}
+	sql, args := compileSQL(node)
+	want := "(actor_id = ? AND action_name LIKE ? AND NOT result_success = ? AND " +
+		"((isIPv4String(context_ip) OR isIPv6String(context_ip)) AND isIPAddressInRange(context_ip, ?)))"
+	if sql != want {
+		t.Errorf("SQL = %s\nwant  %s", sql, want)
+	}
+	wantArgs := []interface{}{"alice", "auth.%", true, "10.0.0.0/8"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("Args = %v, want %v", args, wantArgs)
+	}
+}
+
+func TestParseQuery_Precedence(t *testing.T) {
+	node, err := parseQuery(`actor.id:a OR actor.id:b resource.type:doc`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, _ := compileSQL(node)
+	if sql != "(actor_id = ? OR (actor_id = ? AND resource_type = ?))" {
+		t.Errorf("SQL = %s", sql)
+	}
+	node, err = parseQuery(`(actor.id:a OR actor.id:b) AND received_at:>=2025-01-01`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, _ = compileSQL(node)
+	if sql != "((actor_id = ? OR actor_id = ?) AND received_at >= fromUnixTimestamp64Milli(?))" {
+		t.Errorf("SQL = %s", sql)
+	}
+}
+
+func TestParseQuery_InjectionStaysInValues(t *testing.T) {
+	hostile := `"x\" } }, \"filter\": [] ' OR 1=1 --"`
+	node, err := parseQuery(`actor.id:` + hostile + ` admin`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, args := compileSQL(node)
+	if strings.Contains(sql, "1=1") || strings.Contains(sql, "'") {
+		t.Errorf("Input leaked into SQL: %s", sql)
+	}
+	if args[0] != `x" } }, "filter": [] ' OR 1=1 --` {
+		t.Errorf("Value = %q", args[0])
+	}
+
+	body, err := json.Marshal(compileDSL(node))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	must := decoded["bool"].(map[string]interface{})["must"].([]interface{})
+	term := must[0].(map[string]interface{})["term"].(map[string]interface{})
+	if term["actor.id"] != args[0] {
+		t.Errorf("DSL term = %v", term)
+	}
+	if _, ok := must[1].(map[string]interface{})["multi_match"]; !ok {
+		t.Errorf("Free text should compile to multi_match: %v", must[1])
+	}
+}
+
+func TestParseQuery_SyntaxErrors(t *testing.T) {
+	cases := []struct {
+		q   string
+		pos int
+	}{
+		{`actor.id:alice AND`, 18},
+		{`(actor.id:alice`, 0},
+		{`owner:alice`, 0},
+		{`result.success:maybe`, 15},
+		{`context.ip:10.0.0.300`, 11},
+		{`actor.id:>alice`, 9},
+		{`actor.id:"alice`, 9},
+		{`actor.id:`, 9},
+		{`OR actor.id:a`, 0},
+		{`actor.id:a)`, 10},
+	}
+	for _, tc := range cases {
+		_, err := parseQuery(tc.q)
+		var se *querySyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("parseQuery(%q) error = %v, want syntax error", tc.q, err)
+			continue
+		}
+		if se.Pos != tc.pos {
+			t.Errorf("parseQuery(%q) position = %d, want %d (%s)", tc.q, se.Pos, tc.pos, se.Msg)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// storageColumns are the flattened copies Vector adds before storing
+// raw_event; the nested objects they came from are returned instead
+var storageColumns = map[string]bool{
+	"event_id": true, "tenant_id": true, "event_date": true, "received_at": true, "timestamp": true,
+	"actor": true, "action": true, "resource": true, "result": true, "context": true, "processing": true,
+	"actor_id": true, "actor_type": true, "actor_email": true, "action_name": true,
+	"resource_type": true, "resource_id": true, "result_success": true, "result_message": true,
+	"context_ip": true, "context_user_agent": true, "processing_vector_node": true, "raw_event": true,
+}
+
+// pipelineFields are added by Vector sources on the way in and belong
+// with the processing metadata
+var pipelineFields = map[string]bool{
+	"source_type": true, "path": true, "topic": true, "partition": true, "offset": true, "headers": true,
+}
+
+// eventFromRaw reconstructs the full event from raw_event. The columns in
+// base stay authoritative for identity and storage fields; everything else
+// comes from the original payload. Fields that are neither columns nor
+// pipeline metadata (integrity hashes, signatures, producer,
+// import_batch_id, ...) are returned in metadata.
+func eventFromRaw(base Event, raw string) (Event, error) {
+	if raw == "" {
+		return base, nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return base, fmt.Errorf("decoding raw_event: %w", err)
+	}
+
+	event := base
+	for _, part := range []struct {
+		key string
+		dst *map[string]interface{}
+	}{
+		{"actor", &event.Actor},
+		{"action", &event.Action},
+		{"resource", &event.Resource},
+		{"result", &event.Result},
+		{"context", &event.Context},
+	} {
+		if m, ok := doc[part.key].(map[string]interface{}); ok {
+			*part.dst = mergeMaps(*part.dst, m)
+		}
+	}
+	if ts, ok := doc["timestamp"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			event.Timestamp = parsed.UTC()
+		}
+	}
+
+	processing, _ := doc["processing"].(map[string]interface{})
+	for key, val := range doc {
+		switch {
+		case storageColumns[key]:
+		case pipelineFields[key]:
+			if processing == nil {
+				processing = map[string]interface{}{}
+			}
+			processing[key] = val
+		default:
+			if event.Metadata == nil {
+				event.Metadata = map[string]interface{}{}
+			}
+			event.Metadata[key] = val
+		}
+	}
+	event.Processing = processing
+	return event, nil
+}
+
+// mergeMaps returns base overlaid with extra, keeping base values on conflict
+func mergeMaps(base, extra map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(extra))
+	for k, v := range extra {
+		merged[k] = v
+	}
+	for k, v := range base {
+		merged[k] = v
+	}
+	return merged
+}
+
+// eventSections are the top-level parts fields= can select
+var eventSections = map[string]bool{
+	"actor": true, "action": true, "resource": true, "result": true, "context": true,
+	"timestamp": true, "processing": true, "metadata": true,
+}
+
+// fieldSet is a parsed fields= projection: whole sections, or single keys
+// such as context.ip
+type fieldSet struct {
+	sections map[string]bool
+	keys     map[string][]string
+}
+
+func (f fieldSet) empty() bool {
+	return len(f.sections) == 0 && len(f.keys) == 0
+}
+
+// parseFields parses a comma-separated projection. "*" selects every section.
+func parseFields(s string) (fieldSet, error) {
+	fs := fieldSet{sections: map[string]bool{}, keys: map[string][]string{}}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if field == "*" {
+			for section := range eventSections {
+				fs.sections[section] = true
+			}
+			continue
+		}
+		section, key, nested := strings.Cut(field, ".")
+		if !eventSections[section] || (nested && (key == "" || section == "timestamp")) {
+			return fs, fmt.Errorf("unknown field %q", field)
+		}
+		if nested {
+			fs.keys[section] = append(fs.keys[section], key)
+		} else {
+			fs.sections[section] = true
+		}
+	}
+	return fs, nil
+}
+
+// project adds the selected parts of the full event to the summary
+func (f fieldSet) project(summary, full Event) Event {
+	sections := map[string]struct {
+		dst *map[string]interface{}
+		src map[string]interface{}
+	}{
+		"actor":      {&summary.Actor, full.Actor},
+		"action":     {&summary.Action, full.Action},
+		"resource":   {&summary.Resource, full.Resource},
+		"result":     {&summary.Result, full.Result},
+		"context":    {&summary.Context, full.Context},
+		"processing": {&summary.Processing, full.Processing},
+		"metadata":   {&summary.Metadata, full.Metadata},
+	}
+	for name, part := range sections {
+		if f.sections[name] {
+			*part.dst = part.src
+			continue
+		}
+		for _, key := range f.keys[name] {
+			val, ok := part.src[key]
+			if !ok {
+				continue
+			}
+			if *part.dst == nil {
+				*part.dst = map[string]interface{}{}
+			}
+			(*part.dst)[key] = val
+		}
+	}
+	if f.sections["timestamp"] {
+		summary.Timestamp = full.Timestamp
+	}
+	return summary
+}
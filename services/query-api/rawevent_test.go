@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+const sampleRaw = `{"event_id":"x","tenant_id":"t1","timestamp":"2025-03-01T10:00:00Z",
+"actor":{"id":"u1","type":"user","email":"u1@example.com"},
+"action":{"name":"user.login"},"result":{"success":false,"message":"bad password"},
+"context":{"ip":"10.0.0.1","user_agent":"curl"},"processing":{"vector_node":"v-0"},
+"topic":"audit-events","offset":42,"actor_id":"u1","context_ip":"10.0.0.1",
+"signature":"abc","producer":"svc-a"}`
+
+func TestEventFromRaw(t *testing.T) {
+	base := Event{
+		EventID:  "e1",
+		TenantID: "t1",
+		Actor:    map[string]interface{}{"id": "u1"},
+		Result:   map[string]interface{}{"success": false},
+	}
+	event, err := eventFromRaw(base, sampleRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventID != "e1" {
+		t.Errorf("Column event_id must win, got %s", event.EventID)
+	}
+	if event.Actor["email"] != "u1@example.com" || event.Result["message"] != "bad password" {
+		t.Errorf("Nested fields missing: %v %v", event.Actor, event.Result)
+	}
+	if event.Timestamp.IsZero() || event.Context["user_agent"] != "curl" {
+		t.Errorf("Timestamp or context missing: %v %v", event.Timestamp, event.Context)
+	}
+	if event.Processing["topic"] != "audit-events" || event.Processing["vector_node"] != "v-0" {
+		t.Errorf("Processing = %v", event.Processing)
+	}
+	if event.Metadata["signature"] != "abc" || event.Metadata["producer"] != "svc-a" {
+		t.Errorf("Metadata = %v", event.Metadata)
+	}
+	if _, ok := event.Metadata["actor_id"]; ok {
+		t.Error("Flattened columns must not leak into metadata")
+	}
+}
+
+func TestFieldsProjection(t *testing.T) {
+	if _, err := parseFields("actor,secrets"); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+	fields, err := parseFields("actor, context.ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := Event{EventID: "e1", Actor: map[string]interface{}{"id": "u1"}}
+	full, _ := eventFromRaw(summary, sampleRaw)
+	got := fields.project(summary, full)
+	if got.Actor["email"] != "u1@example.com" {
+		t.Errorf("Actor = %v", got.Actor)
+	}
+	if len(got.Context) != 1 || got.Context["ip"] != "10.0.0.1" {
+		t.Errorf("Context = %v", got.Context)
+	}
+	if got.Processing != nil || !got.Timestamp.IsZero() {
+		t.Error("Unrequested sections must stay empty")
+	}
+}
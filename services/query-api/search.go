@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// searchEventsHandler answers list requests whose q has free text. It
+// applies the same filters and paging as the ClickHouse list, ordering by
+// relevance, then received_at and event_id so search_after is stable.
+func searchEventsHandler(c *fiber.Ctx, node queryNode) error {
+	if osClient == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "OpenSearch not available"})
+	}
+
+	countMode, err := parseCountMode(c.Query("count"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	limit := pageLimit(c)
+
+	filters, tenant := filterHash(c), cursorTenant(c)
+	var after []interface{}
+	if token := c.Query("cursor"); token != "" {
+		cur, err := decodeCursor(token, filters, tenant)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if len(cur.After) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": errInvalidCursor.Error()})
+		}
+		if cur.Direction != cursorNext {
+			return c.Status(400).JSON(fiber.Map{"error": "search results can only be paged forward"})
+		}
+		after = cur.After
+	}
+
+	// Build OpenSearch query as values so nothing from the request is
+	// interpreted as JSON
+	body := map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{
+			"must":   []interface{}{compileDSL(node)},
+			"filter": listFiltersDSL(c),
+		}},
+		"size": limit + 1, // +1 to check for more
+		"sort": []interface{}{
+			map[string]interface{}{"_score": "desc"},
+			map[string]interface{}{"received_at": "desc"},
+			map[string]interface{}{"event_id": "asc"},
+		},
+		"highlight": map[string]interface{}{
+			"fields":              map[string]interface{}{"*": map[string]interface{}{}},
+			"require_field_match": false,
+		},
+	}
+	switch countMode {
+	case countNone:
+		body["track_total_hits"] = false
+	case countExact:
+		body["track_total_hits"] = true
+	}
+	if after != nil {
+		body["search_after"] = after
+	}
+	searchBody, err := json.Marshal(body)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := osClient.Search(
+		osClient.Search.WithContext(context.Background()),
+		osClient.Search.WithIndex("audit-events-*"),
+		osClient.Search.WithBody(bytes.NewReader(searchBody)),
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return c.Status(502).JSON(fiber.Map{"error": fmt.Sprintf("search failed: %s", res.Status())})
+	}
+
+	var result searchResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	hits := result.Hits.Hits
+	hasMore := len(hits) > limit
+	if hasMore {
+		hits = hits[:limit]
+	}
+	events, err := hydrateHits(c, hits)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	pagination := Pagination{HasMore: hasMore}
+	if hasMore {
+		pagination.Cursor = encodeCursor(pageCursor{
+			After: hits[len(hits)-1].Sort, Direction: cursorNext, Filters: filters, Tenant: tenant,
+		})
+	}
+
+	resp := ListResponse{Data: events, Pagination: pagination}
+	if total := result.Hits.Total; total != nil {
+		// OpenSearch counts up to track_total_hits and reports "gte" beyond it
+		exact := total.Relation != "gte"
+		resp.TotalCount, resp.TotalCountExact = &total.Value, &exact
+	}
+	return c.JSON(resp)
+}
+
+type searchResult struct {
+	Hits struct {
+		Total *struct {
+			Value    int64  `json:"value"`
+			Relation string `json:"relation"`
+		} `json:"total"`
+		Hits []searchHit `json:"hits"`
+	} `json:"hits"`
+}
+
+type searchHit struct {
+	Score     *float64               `json:"_score"`
+	Source    map[string]interface{} `json:"_source"`
+	Highlight map[string][]string    `json:"highlight"`
+	Sort      []interface{}          `json:"sort"`
+}
+
+// listFiltersDSL mirrors listFilters as OpenSearch filter clauses
+func listFiltersDSL(c *fiber.Ctx) []interface{} {
+	term := func(field string, value interface{}) interface{} {
+		return map[string]interface{}{"term": map[string]interface{}{field: value}}
+	}
+	filters := []interface{}{}
+	// The same scoping as tenantFilter
+	if p := currentPrincipal(c); !p.Admin {
+		filters = append(filters, term("tenant_id", p.Tenant))
+	}
+	for _, f := range []struct{ param, field string }{
+		{"action", "action.name"},
+		{"actor_id", "actor.id"},
+		{"resource_type", "resource.type"},
+		{"resource_id", "resource.id"},
+	} {
+		if v := c.Query(f.param); v != "" {
+			filters = append(filters, term(f.field, v))
+		}
+	}
+	if success := c.Query("success"); success != "" {
+		filters = append(filters, term("result.success", success == "true"))
+	}
+	dates := map[string]interface{}{}
+	if from := c.Query("from"); from != "" {
+		dates["gte"] = from
+	}
+	if to := c.Query("to"); to != "" {
+		dates["lte"] = to
+	}
+	if len(dates) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"event_date": dates}})
+	}
+	return filters
+}
+
+// hydrateHits turns hits into full events. The indexed document is used
+// when it carries the event; otherwise the event is loaded from ClickHouse
+// by ID. Hits that are in neither store are dropped.
+func hydrateHits(c *fiber.Ctx, hits []searchHit) ([]Event, error) {
+	events := make([]Event, len(hits))
+	found := make([]bool, len(hits))
+	var missing []string
+	for i, hit := range hits {
+		if event, ok := eventFromSource(hit.Source); ok {
+			events[i], found[i] = event, true
+		} else if id, _ := hit.Source["event_id"].(string); id != "" {
+			events[i].EventID = id
+			missing = append(missing, id)
+		}
+	}
+
+	var loaded map[string]Event
+	if len(missing) > 0 {
+		var err error
+		if loaded, err = loadEventsByID(c, missing); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]Event, 0, len(hits))
+	for i, hit := range hits {
+		event := events[i]
+		if !found[i] {
+			var ok bool
+			if event, ok = loaded[event.EventID]; !ok {
+				continue
+			}
+		}
+		event.Score, event.Highlight = hit.Score, hit.Highlight
+		out = append(out, event)
+	}
+	return out, nil
+}
+
+// eventFromSource rebuilds an event from an indexed document, which holds
+// either the nested event or the flattened columns with raw_event
+func eventFromSource(src map[string]interface{}) (Event, bool) {
+	str := func(key string) string {
+		s, _ := src[key].(string)
+		return s
+	}
+	if str("event_id") == "" {
+		return Event{}, false
+	}
+	raw := str("raw_event")
+	if raw == "" {
+		if _, nested := src["actor"].(map[string]interface{}); !nested {
+			return Event{}, false
+		}
+		b, err := json.Marshal(src)
+		if err != nil {
+			return Event{}, false
+		}
+		raw = string(b)
+	}
+	base := Event{EventID: str("event_id"), TenantID: str("tenant_id"), EventDate: str("event_date")}
+	if ts, err := time.Parse(time.RFC3339Nano, str("received_at")); err == nil {
+		base.ReceivedAt = ts.UTC()
+	}
+	event, err := eventFromRaw(base, raw)
+	return event, err == nil
+}
+
+// loadEventsByID fetches full events from ClickHouse in one query
+func loadEventsByID(c *fiber.Ctx, ids []string) (map[string]Event, error) {
+	query := "SELECT event_id, tenant_id, event_date, received_at, actor_id, action_name, resource_type, resource_id, result_success, raw_event FROM audit.events WHERE has(?, toString(event_id))"
+	args := []interface{}{ids}
+
+	query, args = addTenantFilter(query, args, c)
+	query += " LIMIT 1 BY event_id"
+
+	rows, err := chConn.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := map[string]Event{}
+	for rows.Next() {
+		var e struct {
+			EventID       string
+			TenantID      string
+			EventDate     time.Time
+			ReceivedAt    time.Time
+			ActorID       string
+			ActionName    string
+			ResourceType  string
+			ResourceID    string
+			ResultSuccess bool
+			RawEvent      string
+		}
+		if err := rows.Scan(&e.EventID, &e.TenantID, &e.EventDate, &e.ReceivedAt, &e.ActorID, &e.ActionName, &e.ResourceType, &e.ResourceID, &e.ResultSuccess, &e.RawEvent); err != nil {
+			continue
+		}
+		event, err := eventFromRaw(Event{
+			EventID:    e.EventID,
+			TenantID:   e.TenantID,
+			EventDate:  e.EventDate.Format("2006-01-02"),
+			ReceivedAt: e.ReceivedAt,
+			Actor:      map[string]interface{}{"id": e.ActorID},
+			Action:     map[string]interface{}{"name": e.ActionName},
+			Resource:   map[string]interface{}{"type": e.ResourceType, "id": e.ResourceID},
+			Result:     map[string]interface{}{"success": e.ResultSuccess},
+		}, e.RawEvent)
+		if err != nil {
+			log.Printf("Event %s: %v", e.EventID, err)
+		}
+		events[e.EventID] = event
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/opensearch-project/opensearch-go/v2"
+)
+
+func TestSearch_FiltersPagingAndHydration(t *testing.T) {
+	var requests []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"hits":{"total":{"value":2,"relation":"eq"},"hits":[
+			{"_score":2.5,"sort":[2.5,1740823200000,"e1"],"highlight":{"actor.email":["<em>alice</em>@example.com"]},
+			 "_source":{"event_id":"e1","tenant_id":"t1","event_date":"2025-03-01","received_at":"2025-03-01T10:00:00Z",
+			  "actor":{"id":"alice","email":"alice@example.com"},"action":{"name":"auth.login"},"context":{"ip":"10.0.0.1"}}},
+			{"_score":1.0,"sort":[1.0,1740823100000,"e2"],"_source":{"event_id":"e2"}}]}}`)
+	}))
+	defer srv.Close()
+
+	client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := osClient
+	osClient = client
+	defer func() { osClient = saved }()
+
+	app := fiber.New()
+	app.Get("/v1/events", func(c *fiber.Ctx) error {
+		c.Locals("principal", principal{Consumer: "c1", Tenant: "t1"})
+		return listEventsHandler(c)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/v1/events?q=alice&action=auth.login&limit=1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list ListResponse
+	json.NewDecoder(resp.Body).Decode(&list)
+	if resp.StatusCode != 200 || len(list.Data) != 1 {
+		t.Fatalf("Status %d, data %+v", resp.StatusCode, list.Data)
+	}
+	e := list.Data[0]
+	if e.Actor["email"] != "alice@example.com" || e.Context["ip"] != "10.0.0.1" {
+		t.Errorf("Event not hydrated: %+v", e)
+	}
+	if e.Score == nil || *e.Score != 2.5 || len(e.Highlight["actor.email"]) != 1 {
+		t.Errorf("Score %v, highlight %v", e.Score, e.Highlight)
+	}
+	if !list.Pagination.HasMore || list.Pagination.Cursor == "" {
+		t.Fatalf("Expected a next cursor: %+v", list.Pagination)
+	}
+
+	first := requests[0]
+	if first["size"] != float64(2) {
+		t.Errorf("size = %v, want limit+1", first["size"])
+	}
+	filters, _ := json.Marshal(first["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"])
+	want := `[{"term":{"tenant_id":"t1"}},{"term":{"action.name":"auth.login"}}]`
+	if string(filters) != want {
+		t.Errorf("filter = %s, want %s", filters, want)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/v1/events?q=alice&action=auth.login&limit=1&cursor="+list.Pagination.Cursor, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Second page status %d", resp.StatusCode)
+	}
+	after, _ := json.Marshal(requests[1]["search_after"])
+	if string(after) != `[2.5,1740823200000,"e1"]` {
+		t.Errorf("search_after = %s", after)
+	}
+
+	// A cursor is bound to the query it came from
+	resp, _ = app.Test(httptest.NewRequest("GET", "/v1/events?q=bob&limit=1&cursor="+list.Pagination.Cursor, nil))
+	if resp.StatusCode != 400 {
+		t.Errorf("Cursor for another query: status %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// timeseriesMaxBuckets bounds the points per series
+const timeseriesMaxBuckets = 10000
+
+// Series beyond the largest series_limit are summed into "other"
+const (
+	defaultSeriesLimit = 10
+	maxSeriesLimit     = 100
+	otherSeriesKey     = "other"
+)
+
+type timeseriesInterval struct {
+	step   time.Duration
+	expr   string        // ClickHouse bucket expression; ? is the timezone
+	span   time.Duration // default range when from is not given
+	hourly bool          // can be answered from events_received_hourly
+}
+
+var timeseriesIntervals = map[string]timeseriesInterval{
+	"1m": {time.Minute, "toStartOfInterval(received_at, INTERVAL 1 minute, ?)", time.Hour, false},
+	"5m": {5 * time.Minute, "toStartOfInterval(received_at, INTERVAL 5 minute, ?)", 6 * time.Hour, false},
+	"1h": {time.Hour, "toStartOfInterval(received_at, INTERVAL 1 hour, ?)", 24 * time.Hour, true},
+	"1d": {24 * time.Hour, "toStartOfDay(received_at, ?)", 30 * 24 * time.Hour, true},
+}
+
+// timeseriesGroups maps group_by to the series key expression
+var timeseriesGroups = map[string]string{
+	"action":        "action_name",
+	"actor":         "actor_id",
+	"resource_type": "resource_type",
+	"success":       "if(result_success, 'true', 'false')",
+}
+
+// TimeseriesResponse is the event volume per bucket, one series per
+// group_by value
+type TimeseriesResponse struct {
+	Interval string             `json:"interval"`
+	Timezone string             `json:"timezone"`
+	From     time.Time          `json:"from"`
+	To       time.Time          `json:"to"`
+	GroupBy  string             `json:"group_by,omitempty"`
+	Source   string             `json:"source"`
+	Series   []TimeseriesSeries `json:"series"`
+}
+
+type TimeseriesSeries struct {
+	Key    string            `json:"key"`
+	Total  int64             `json:"total"`
+	Points []TimeseriesPoint `json:"points"`
+}
+
+type TimeseriesPoint struct {
+	Time  time.Time `json:"time"`
+	Count int64     `json:"count"`
+}
+
+// timeseriesHandler counts events per interval of received_at in the
+// requested timezone. from and to are RFC 3339 times or dates in that
+// timezone, with to inclusive for dates. Buckets without events are
+// returned as zeros.
+func timeseriesHandler(c *fiber.Ctx) error {
+	intervalName := c.Query("interval", "1h")
+	interval, ok := timeseriesIntervals[intervalName]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "interval must be one of 1m, 5m, 1h, 1d"})
+	}
+	tzName := c.Query("tz", "UTC")
+	loc, err := time.LoadLocation(tzName)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown timezone %q", tzName)})
+	}
+	groupBy := c.Query("group_by")
+	groupExpr, ok := timeseriesGroups[groupBy]
+	if groupBy != "" && !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "group_by must be one of action, actor, resource_type, success"})
+	}
+	seriesLimit := defaultSeriesLimit
+	if s := c.Query("series_limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSeriesLimit {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("series_limit must be between 1 and %d", maxSeriesLimit)})
+		}
+		seriesLimit = n
+	}
+
+	to := time.Now().In(loc)
+	if s := c.Query("to"); s != "" {
+		if to, err = parseRangeBound(s, loc, true); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "to must be an RFC 3339 time or YYYY-MM-DD"})
+		}
+	}
+	from := to.Add(-interval.span)
+	if s := c.Query("from"); s != "" {
+		if from, err = parseRangeBound(s, loc, false); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "from must be an RFC 3339 time or YYYY-MM-DD"})
+		}
+	}
+	buckets := timeseriesBuckets(from, to, intervalName, loc)
+	if len(buckets) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "from must be before to"})
+	}
+	if len(buckets) > timeseriesMaxBuckets {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("range has more than %d buckets, use a larger interval", timeseriesMaxBuckets)})
+	}
+	start, end := buckets[0], to
+
+	// The same filters as the list, except that the range applies to
+	// received_at in the requested timezone rather than to event_date
+	p := currentPrincipal(c)
+	where, args := filterWhere(p, func(key string) string {
+		if key == "from" || key == "to" {
+			return ""
+		}
+		return c.Query(key)
+	})
+	qWhere, qArgs, err := fieldQueryFilter(c.Query("q"))
+	if err != nil {
+		return queryError(c, err)
+	}
+	where += qWhere
+	args = append(args, qArgs...)
+
+	key := "''"
+	if groupBy != "" {
+		key = groupExpr
+	}
+	eventsQuery := func(from, to time.Time) timeseriesQuery {
+		return timeseriesQuery{
+			"SELECT " + interval.expr + " AS bucket, " + key + " AS key, count() FROM audit.events" + where +
+				" AND received_at >= fromUnixTimestamp64Milli(?) AND received_at < fromUnixTimestamp64Milli(?)" +
+				" GROUP BY bucket, key",
+			append(append([]interface{}{tzName}, args...), from.UnixMilli(), to.UnixMilli()),
+		}
+	}
+
+	queries := []timeseriesQuery{eventsQuery(start, end)}
+	source := "events"
+	if interval.hourly && groupBy == "" && !hasFilters(c, "from", "to") && wholeHourOffsets(loc, start, end) {
+		// Only the tenant is filtered, so the view of events per hour of
+		// received_at has the answer for whole hours. A final partial hour
+		// (to = 10:30) is counted from audit.events, so that both sources
+		// give the same totals.
+		source = "events_received_hourly"
+		split := end.Truncate(time.Hour)
+		bucket := "toStartOfInterval(received_hour, INTERVAL 1 hour, ?)"
+		if intervalName == "1d" {
+			bucket = "toStartOfDay(received_hour, ?)"
+		}
+		tWhere, tArgs := tenantFilter(" WHERE 1=1", []interface{}{}, p)
+		queries = []timeseriesQuery{{
+			"SELECT " + bucket + " AS bucket, '' AS key, sum(event_count) FROM audit.events_received_hourly" + tWhere +
+				" AND received_hour >= fromUnixTimestamp64Milli(?) AND received_hour < fromUnixTimestamp64Milli(?)" +
+				" GROUP BY bucket",
+			append(append([]interface{}{tzName}, tArgs...), start.UnixMilli(), split.UnixMilli()),
+		}}
+		if split.Before(end) {
+			queries = append(queries, eventsQuery(split, end))
+		}
+	}
+
+	counts := map[string]map[int64]int64{}
+	for _, q := range queries {
+		if err := scanTimeseries(q, counts); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if groupBy == "" && len(counts) == 0 {
+		// Still one series of zeros
+		counts[""] = map[int64]int64{}
+	}
+
+	resp := TimeseriesResponse{
+		Interval: intervalName,
+		Timezone: tzName,
+		From:     start,
+		To:       end,
+		GroupBy:  groupBy,
+		Source:   source,
+		Series:   buildSeries(counts, buckets, seriesLimit),
+	}
+	return c.JSON(resp)
+}
+
+// timeseriesQuery is one query returning (bucket, key, count) rows
+type timeseriesQuery struct {
+	sql  string
+	args []interface{}
+}
+
+// scanTimeseries adds the counts returned by q to counts
+func scanTimeseries(q timeseriesQuery, counts map[string]map[int64]int64) error {
+	rows, err := chConn.Query(context.Background(), q.sql, q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket time.Time
+		var key string
+		var n uint64
+		if err := rows.Scan(&bucket, &key, &n); err != nil {
+			return err
+		}
+		if counts[key] == nil {
+			counts[key] = map[int64]int64{}
+		}
+		counts[key][bucket.Unix()] += int64(n)
+	}
+	return rows.Err()
+}
+
+// hasFilters reports whether any list filter other than except is set
+func hasFilters(c *fiber.Ctx, except ...string) bool {
+	for _, name := range listFilterParams {
+		skip := false
+		for _, e := range except {
+			skip = skip || e == name
+		}
+		if !skip && c.Query(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRangeBound parses an RFC 3339 time, or a date at midnight in loc.
+// As an end bound a date includes the whole day.
+func parseRangeBound(s string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.In(loc), nil
+	}
+	d, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		d = d.AddDate(0, 0, 1)
+	}
+	return d, nil
+}
+
+// bucketStart is the start of the bucket holding t, aligned to wall-clock
+// time in loc like the ClickHouse bucket expressions
+func bucketStart(t time.Time, interval string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	switch interval {
+	case "1m":
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, loc)
+	case "5m":
+		return time.Date(y, m, d, t.Hour(), t.Minute()-t.Minute()%5, 0, 0, loc)
+	case "1h":
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// timeseriesBuckets lists the bucket starts covering [from, to). Days
+// follow the calendar, so they are 23 or 25 hours long across DST changes.
+func timeseriesBuckets(from, to time.Time, interval string, loc *time.Location) []time.Time {
+	var buckets []time.Time
+	for b := bucketStart(from, interval, loc); b.Before(to); {
+		buckets = append(buckets, b)
+		if len(buckets) > timeseriesMaxBuckets {
+			break
+		}
+		if interval == "1d" {
+			b = time.Date(b.Year(), b.Month(), b.Day()+1, 0, 0, 0, 0, loc)
+		} else {
+			b = b.Add(timeseriesIntervals[interval].step)
+		}
+	}
+	return buckets
+}
+
+// wholeHourOffsets reports whether loc is a whole number of hours from UTC
+// over the range, so hourly UTC counts add up to its hours and days
+func wholeHourOffsets(loc *time.Location, from, to time.Time) bool {
+	for _, t := range []time.Time{from, to} {
+		if _, offset := t.In(loc).Zone(); offset%3600 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// buildSeries zero-fills every series over the buckets and keeps the
+// limit largest, summing the rest into "other"
+func buildSeries(counts map[string]map[int64]int64, buckets []time.Time, limit int) []TimeseriesSeries {
+	series := make([]TimeseriesSeries, 0, len(counts))
+	for key, byBucket := range counts {
+		s := TimeseriesSeries{Key: key, Points: make([]TimeseriesPoint, len(buckets))}
+		for i, b := range buckets {
+			n := byBucket[b.Unix()]
+			s.Points[i] = TimeseriesPoint{Time: b, Count: n}
+			s.Total += n
+		}
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Total != series[j].Total {
+			return series[i].Total > series[j].Total
+		}
+		return series[i].Key < series[j].Key
+	})
+	if len(series) <= limit {
+		return series
+	}
+
+	other := TimeseriesSeries{Key: otherSeriesKey, Points: make([]TimeseriesPoint, len(buckets))}
+	for i, b := range buckets {
+		other.Points[i].Time = b
+	}
+	for _, s := range series[limit:] {
+		for i, p := range s.Points {
+			other.Points[i].Count += p.Count
+		}
+		other.Total += s.Total
+	}
+	return append(series[:limit], other)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeseriesBuckets_DaysFollowDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Lisbon")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	from, _ := parseRangeBound("2025-03-29", loc, false)
+	to, _ := parseRangeBound("2025-03-31", loc, true)
+	buckets := timeseriesBuckets(from, to, "1d", loc)
+	if len(buckets) != 3 {
+		t.Fatalf("Got %d daily buckets, want 3", len(buckets))
+	}
+	// 30 March is 23 hours long in Lisbon
+	if d := buckets[2].Sub(buckets[1]); d != 23*time.Hour {
+		t.Errorf("DST day is %v long", d)
+	}
+	for _, b := range buckets {
+		if b.In(loc).Hour() != 0 {
+			t.Errorf("Bucket %v does not start at local midnight", b)
+		}
+	}
+}
+
+func TestTimeseriesBuckets_AlignToInterval(t *testing.T) {
+	from := time.Date(2025, 3, 1, 10, 7, 30, 0, time.UTC)
+	to := time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC)
+	buckets := timeseriesBuckets(from, to, "5m", time.UTC)
+	if len(buckets) != 5 || !buckets[0].Equal(time.Date(2025, 3, 1, 10, 5, 0, 0, time.UTC)) {
+		t.Errorf("Buckets = %v", buckets)
+	}
+	if wholeHourOffsets(time.FixedZone("IST", 5*3600+1800), from, to) {
+		t.Error("Half-hour offset reported as whole hours")
+	}
+}
+
+func TestBuildSeries_ZeroFillAndOther(t *testing.T) {
+	b0 := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	buckets := []time.Time{b0, b0.Add(time.Hour), b0.Add(2 * time.Hour)}
+	counts := map[string]map[int64]int64{
+		"auth.login":  {b0.Unix(): 5, b0.Add(2 * time.Hour).Unix(): 5},
+		"auth.logout": {b0.Add(time.Hour).Unix(): 3},
+		"doc.read":    {b0.Unix(): 1},
+	}
+	series := buildSeries(counts, buckets, 1)
+	if len(series) != 2 || series[0].Key != "auth.login" || series[1].Key != otherSeriesKey {
+		t.Fatalf("Series = %+v", series)
+	}
+	if got := series[0].Points[1].Count; got != 0 || len(series[0].Points) != 3 {
+		t.Errorf("Missing bucket not zero-filled: %+v", series[0].Points)
+	}
+	if series[1].Total != 4 || series[1].Points[0].Count != 1 || series[1].Points[1].Count != 3 {
+		t.Errorf("Other = %+v", series[1])
+	}
+}